@@ -1,6 +1,8 @@
 package prosemirror
 
 import (
+	"time"
+
 	"github.com/dkfbasel/protobuf/types/nullstring"
 	"github.com/dkfbasel/protobuf/types/timestamp"
 )
@@ -49,3 +51,30 @@ type CommentDeleteReply struct {
 	UserID    string              `json:"userId"`
 	Timestamp timestamp.Timestamp `json:"timestamp"`
 }
+
+// CommentThread is a comment of a document with its done and archived state
+// and all replies to the comment
+type CommentThread struct {
+	ID            string               `json:"id" db:"id"`
+	PreliminaryID *string              `json:"preliminaryId,omitempty" db:"preliminary_id"`
+	AuthorID      string               `json:"authorId" db:"author_id"`
+	Message       string               `json:"message" db:"message"`
+	Origin        string               `json:"origin" db:"origin"`
+	Done          *time.Time           `json:"done" db:"done"`
+	DoneBy        *string              `json:"doneBy" db:"done_by"`
+	Archived      *time.Time           `json:"archived" db:"archived"`
+	ArchivedBy    *string              `json:"archivedBy" db:"archived_by"`
+	Timestamp     time.Time            `json:"timestamp" db:"created"`
+	Replies       []CommentThreadReply `json:"replies" db:"-"`
+}
+
+// CommentThreadReply is a single reply to a comment
+type CommentThreadReply struct {
+	ReplyID    string     `json:"id" db:"id"`
+	CommentID  string     `json:"commentId" db:"comment_id"`
+	AuthorID   string     `json:"authorId" db:"author_id"`
+	Message    string     `json:"message" db:"message"`
+	Archived   *time.Time `json:"archived" db:"archived"`
+	ArchivedBy *string    `json:"archivedBy" db:"archived_by"`
+	Timestamp  time.Time  `json:"timestamp" db:"created"`
+}
@@ -3,13 +3,15 @@ package prosemirror
 // Permission indicates the users permissions on a specific document
 type Permission int
 
-// initialize available permissions
+// initialize available permissions. permissions are ordered, i.e. every
+// permission includes all permissions before it
 const (
 	None Permission = iota
+	View
 	Comment
 	Edit
 )
 
 func (p Permission) String() string {
-	return [...]string{"none", "comment", "edit"}[p]
+	return [...]string{"none", "view", "comment", "edit"}[p]
 }
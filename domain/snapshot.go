@@ -0,0 +1,14 @@
+package prosemirror
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DocumentSnapshot holds the content of a document at a given version
+type DocumentSnapshot struct {
+	DocumentID string          `json:"documentId" db:"document_id"`
+	Version    int64           `json:"version" db:"version"`
+	Content    json.RawMessage `json:"content" db:"content"`
+	Created    time.Time       `json:"created" db:"created"`
+}
@@ -0,0 +1,17 @@
+package prosemirror
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DocumentStep is a single step of the persisted step history of a
+// document. The version is the document version after applying the step
+type DocumentStep struct {
+	DocumentID string          `json:"documentId" db:"document_id"`
+	Version    int64           `json:"version" db:"version"`
+	Step       json.RawMessage `json:"step" db:"step"`
+	ClientID   int             `json:"clientId" db:"client_id"`
+	UserID     string          `json:"userId" db:"user_id"`
+	Created    time.Time       `json:"created" db:"created"`
+}
@@ -0,0 +1,168 @@
+package attribution
+
+import (
+	"sort"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/transform"
+)
+
+// Span is a range of the document that was authored by a single user. An
+// empty user id is used for content whose author is not known
+type Span struct {
+	From   int
+	To     int
+	UserID string
+}
+
+// Tracker keeps track of the authors of the content of a document. The
+// ranges inserted by every step are attributed to the user that sent the
+// step and mapped through all subsequent steps onto the current positions
+// of the document. Content that existed before the first tracked step is
+// not attributed to anyone
+type Tracker struct {
+	// version of the document the spans refer to
+	Version int64
+
+	// attributed ranges, sorted by position and not overlapping
+	spans []Span
+}
+
+// NewTracker will initialize a new tracker for the document at the given
+// version
+func NewTracker(version int64) *Tracker {
+	return &Tracker{Version: version}
+}
+
+// Apply will map all attributed ranges through the map of the given step
+// and attribute the content inserted by the step to the given user
+func (t *Tracker) Apply(stepMap *transform.StepMap, userID string) {
+
+	var replaced [][2]int
+	var inserted []Span
+
+	// spans are split at every replaced range, so that content inserted
+	// in the middle of a span is not attributed to the author of the span
+	stepMap.ForEach(func(oldStart, oldEnd, newStart, newEnd int) {
+		replaced = append(replaced, [2]int{oldStart, oldEnd})
+		if newEnd > newStart {
+			inserted = append(inserted, Span{From: newStart, To: newEnd, UserID: userID})
+		}
+	})
+
+	spans := make([]Span, 0, len(t.spans)+len(inserted))
+
+	for _, span := range t.spans {
+		for _, part := range subtractRanges(span, replaced) {
+			// content inserted at the boundaries of a span does not
+			// belong to the span
+			from := stepMap.Map(part.From, 1)
+			to := stepMap.Map(part.To, -1)
+			if to > from {
+				spans = append(spans, Span{From: from, To: to, UserID: span.UserID})
+			}
+		}
+	}
+
+	t.spans = normalize(append(spans, inserted...))
+	t.Version++
+}
+
+// Blame will return the authors of the content between the given positions.
+// The returned spans cover the whole range, ranges without a known author
+// are returned with an empty user id
+func (t *Tracker) Blame(from, to int) []Span {
+
+	var result []Span
+	pos := from
+
+	for _, span := range t.spans {
+		if span.To <= from {
+			continue
+		}
+		if span.From >= to {
+			break
+		}
+
+		if span.From > pos {
+			result = append(result, Span{From: pos, To: span.From})
+		}
+
+		start := maxInt(span.From, from)
+		end := minInt(span.To, to)
+		result = append(result, Span{From: start, To: end, UserID: span.UserID})
+		pos = end
+	}
+
+	if pos < to {
+		result = append(result, Span{From: pos, To: to})
+	}
+
+	return result
+}
+
+// subtractRanges will return the parts of the span that are not covered by
+// any of the given sorted ranges
+func subtractRanges(span Span, ranges [][2]int) []Span {
+
+	parts := []Span{span}
+
+	for _, r := range ranges {
+		last := &parts[len(parts)-1]
+		if r[1] <= last.From {
+			continue
+		}
+		if r[0] >= last.To {
+			break
+		}
+
+		// note that empty ranges will only split the span
+		rest := Span{From: r[1], To: last.To, UserID: span.UserID}
+		last.To = maxInt(last.From, r[0])
+		if last.To == last.From {
+			parts = parts[:len(parts)-1]
+		}
+		if rest.To > rest.From {
+			parts = append(parts, rest)
+		}
+		if len(parts) == 0 {
+			break
+		}
+	}
+
+	return parts
+}
+
+// normalize will sort the given spans and merge adjacent spans of the
+// same user
+func normalize(spans []Span) []Span {
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].From < spans[j].From
+	})
+
+	var result []Span
+	for _, span := range spans {
+		last := len(result) - 1
+		if last >= 0 && result[last].To == span.From && result[last].UserID == span.UserID {
+			result[last].To = span.To
+			continue
+		}
+		result = append(result, span)
+	}
+
+	return result
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,150 @@
+package attribution
+
+import (
+	"reflect"
+	"testing"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/transform"
+)
+
+// expectSpans will compare the given spans, treating no spans and empty
+// spans as equal
+func expectSpans(t *testing.T, got, want []Span) {
+	t.Helper()
+
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected spans %+v, got %+v", want, got)
+	}
+}
+
+func TestApply(t *testing.T) {
+
+	// the document starts with five characters of alice followed by five
+	// characters of bob
+	initial := []Span{{0, 5, "alice"}, {5, 10, "bob"}}
+
+	tests := []struct {
+		name   string
+		ranges []int // start, old size and new size of the replaced ranges
+		userID string
+		want   []Span
+	}{
+		{"insert inside a span", []int{2, 0, 3}, "carol",
+			[]Span{{0, 2, "alice"}, {2, 5, "carol"}, {5, 8, "alice"}, {8, 13, "bob"}}},
+		{"insert at a span boundary", []int{5, 0, 2}, "carol",
+			[]Span{{0, 5, "alice"}, {5, 7, "carol"}, {7, 12, "bob"}}},
+		{"insert of the same user at a span boundary", []int{5, 0, 2}, "alice",
+			[]Span{{0, 7, "alice"}, {7, 12, "bob"}}},
+		{"insert at the start of the document", []int{0, 0, 2}, "carol",
+			[]Span{{0, 2, "carol"}, {2, 7, "alice"}, {7, 12, "bob"}}},
+		{"delete inside a span", []int{1, 2, 0}, "carol",
+			[]Span{{0, 3, "alice"}, {3, 8, "bob"}}},
+		{"delete across spans", []int{3, 4, 0}, "carol",
+			[]Span{{0, 3, "alice"}, {3, 6, "bob"}}},
+		{"delete of a whole span", []int{0, 5, 0}, "carol",
+			[]Span{{0, 5, "bob"}}},
+		{"delete of the whole document", []int{0, 10, 0}, "carol", nil},
+		{"replace at span boundaries", []int{5, 5, 2}, "carol",
+			[]Span{{0, 5, "alice"}, {5, 7, "carol"}}},
+		{"replace across spans", []int{3, 4, 2}, "carol",
+			[]Span{{0, 3, "alice"}, {3, 5, "carol"}, {5, 8, "bob"}}},
+		{"multiple ranges", []int{1, 1, 0, 6, 0, 1}, "carol",
+			[]Span{{0, 4, "alice"}, {4, 5, "bob"}, {5, 6, "carol"}, {6, 10, "bob"}}},
+		{"step without changed ranges", nil, "carol", initial},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			tracker := NewTracker(4)
+			tracker.spans = append([]Span{}, initial...)
+
+			tracker.Apply(transform.NewStepMap(tt.ranges), tt.userID)
+
+			expectSpans(t, tracker.spans, tt.want)
+			if tracker.Version != 5 {
+				t.Errorf("expected version 5, got %d", tracker.Version)
+			}
+		})
+	}
+}
+
+func TestApplySequence(t *testing.T) {
+
+	tracker := NewTracker(0)
+
+	// content of earlier steps is mapped through all later steps
+	tracker.Apply(transform.NewStepMap([]int{0, 0, 5}), "alice")
+	tracker.Apply(transform.NewStepMap([]int{5, 0, 5}), "bob")
+	tracker.Apply(transform.NewStepMap([]int{2, 6, 0}), "carol")
+	tracker.Apply(transform.NewStepMap([]int{2, 0, 1}), "carol")
+
+	expectSpans(t, tracker.spans, []Span{{0, 2, "alice"}, {2, 3, "carol"}, {3, 5, "bob"}})
+	if tracker.Version != 4 {
+		t.Errorf("expected version 4, got %d", tracker.Version)
+	}
+}
+
+func TestSubtractRanges(t *testing.T) {
+
+	span := Span{From: 2, To: 8, UserID: "alice"}
+
+	tests := []struct {
+		name   string
+		ranges [][2]int
+		want   []Span
+	}{
+		{"no ranges", nil, []Span{{2, 8, "alice"}}},
+		{"range before the span", [][2]int{{0, 1}}, []Span{{2, 8, "alice"}}},
+		{"range after the span", [][2]int{{9, 10}}, []Span{{2, 8, "alice"}}},
+		{"range adjacent to the span", [][2]int{{0, 2}, {8, 10}}, []Span{{2, 8, "alice"}}},
+		{"range inside the span", [][2]int{{4, 6}}, []Span{{2, 4, "alice"}, {6, 8, "alice"}}},
+		{"range overlapping the start", [][2]int{{0, 4}}, []Span{{4, 8, "alice"}}},
+		{"range overlapping the end", [][2]int{{6, 10}}, []Span{{2, 6, "alice"}}},
+		{"range covering the span", [][2]int{{0, 10}}, nil},
+		{"empty range inside the span", [][2]int{{3, 3}},
+			[]Span{{2, 3, "alice"}, {3, 8, "alice"}}},
+		{"empty range at the start of the span", [][2]int{{2, 2}}, []Span{{2, 8, "alice"}}},
+		{"multiple ranges", [][2]int{{3, 4}, {5, 6}},
+			[]Span{{2, 3, "alice"}, {4, 5, "alice"}, {6, 8, "alice"}}},
+		{"multiple ranges covering the span", [][2]int{{0, 4}, {4, 10}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectSpans(t, subtractRanges(span, tt.ranges), tt.want)
+		})
+	}
+}
+
+func TestBlame(t *testing.T) {
+
+	tracker := NewTracker(0)
+	tracker.spans = []Span{{2, 5, "alice"}, {7, 10, "bob"}}
+
+	tests := []struct {
+		name     string
+		from, to int
+		want     []Span
+	}{
+		{"whole document", 0, 12,
+			[]Span{{0, 2, ""}, {2, 5, "alice"}, {5, 7, ""}, {7, 10, "bob"}, {10, 12, ""}}},
+		{"inside a span", 3, 4, []Span{{3, 4, "alice"}}},
+		{"exactly a span", 2, 5, []Span{{2, 5, "alice"}}},
+		{"across spans", 4, 8, []Span{{4, 5, "alice"}, {5, 7, ""}, {7, 8, "bob"}}},
+		{"between spans", 5, 7, []Span{{5, 7, ""}}},
+		{"after all spans", 10, 12, []Span{{10, 12, ""}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectSpans(t, tracker.Blame(tt.from, tt.to), tt.want)
+		})
+	}
+
+	// content of a new tracker is not attributed to anyone
+	expectSpans(t, NewTracker(0).Blame(0, 4), []Span{{0, 4, ""}})
+}
@@ -1,6 +1,8 @@
 package environment
 
 import (
+	"time"
+
 	"dkfbasel.ch/orca/pkg/database"
 	"github.com/kelseyhightower/envconfig"
 )
@@ -9,9 +11,7 @@ import (
 type Configuration struct {
 
 	// host to start the httpserver on
-	Websocket struct {
-		Host string `default:"0.0.0.0:80"`
-	}
+	Websocket WebsocketConfig
 
 	// redis server connection
 	Redis struct {
@@ -29,10 +29,52 @@ type Configuration struct {
 		Address string `default:"service.image"`
 	}
 
+	// keys to verify the signature of the session header. sessions can be
+	// signed with a shared hmac secret or an ed25519 key (base64 encoded)
+	Session struct {
+		Secret    string `default:""`
+		PublicKey string `default:"" split_words:"true"`
+	}
+
 	// database configuration for the postgres connection
 	Postgres database.Config `envconfig:"DB"`
 }
 
+// WebsocketConfig holds the configuration of the websocket server
+type WebsocketConfig struct {
+	Host string `default:"0.0.0.0:80"`
+
+	// time to report the service as not ready before shutting down, so that
+	// no new connections are routed to the service, and time to wait for
+	// rooms to close on shutdown
+	ShutdownDelay   time.Duration `default:"5s" split_words:"true"`
+	ShutdownTimeout time.Duration `default:"25s" split_words:"true"`
+
+	// number of messages that are queued for every client and the policy
+	// to apply if the queue is full (coalesce, resync or disconnect)
+	QueueSize      int    `default:"256" split_words:"true"`
+	OverflowPolicy string `default:"coalesce" split_words:"true"`
+
+	// rule to decide if the schema of a client is compatible with the
+	// schema of the room (strict, minor or superset)
+	SchemaCompatibility string `default:"strict" split_words:"true"`
+
+	// drop the state of rooms that were idle for the given time. the state
+	// is validated against the step log when the room is used again
+	RoomIdleTimeout time.Duration `default:"1h" split_words:"true"`
+
+	// limits of the messages a client may send (use a rate of zero to
+	// disable a limit). clients exceeding the limits more than the given
+	// number of violations within a minute are disconnected
+	ClientMessageRate    float64 `default:"20" split_words:"true"`
+	ClientMessageBurst   int     `default:"50" split_words:"true"`
+	ClientBytesPerMinute int     `default:"30000000" split_words:"true"`
+	RoomMessageRate      float64 `default:"100" split_words:"true"`
+	RoomMessageBurst     int     `default:"200" split_words:"true"`
+	MaxStepsPerBatch     int     `default:"1000" split_words:"true"`
+	RateLimitViolations  int     `default:"10" split_words:"true"`
+}
+
 // LoadConfiguration will load the basic application configuration from the
 // specified config file
 func LoadConfiguration(prefix string) (Configuration, error) {
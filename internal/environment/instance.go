@@ -0,0 +1,17 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// NewInstanceID returns a unique identifier for the running instance of the
+// service, used to distinguish messages of other instances
+func NewInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
@@ -1,10 +1,12 @@
 package environment
 
 import (
+	"dkfbasel.ch/orca/collaboration/src/internal/session"
 	"dkfbasel.ch/orca/collaboration/src/repository"
 	image "dkfbasel.ch/orca/image/src/domain"
 	process "dkfbasel.ch/orca/process/src/domain"
 	"github.com/go-redis/redis/v7"
+	"google.golang.org/grpc"
 )
 
 // Services is used to provide all necessary services
@@ -12,12 +14,26 @@ type Services struct {
 	// redis database to store transactions in memory
 	Redis *redis.Client
 
+	// step log of the documents that are currently edited
+	Steps repository.StepStore
+
+	// broker to distribute accepted steps between multiple instances
+	Broker repository.Broker
+
+	// verifier for the session information passed by the auth service
+	Sessions *session.Verifier
+
+	// unique identifier of the running instance
+	Instance string
+
 	// postgres database
 	Postgres *repository.DB
 
 	// process service to handle document
-	Process process.ProcessClient
+	Process     process.ProcessClient
+	ProcessConn *grpc.ClientConn
 
 	// image service to handle images
-	Image image.ImageClient
+	Image     image.ImageClient
+	ImageConn *grpc.ClientConn
 }
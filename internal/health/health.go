@@ -0,0 +1,200 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/pkg/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// Check is used to verify that a single dependency is reachable
+type Check func(ctx context.Context) error
+
+// status values reported for the service and its dependencies
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+	statusDraining    = "draining"
+)
+
+// Status is the reported status of a single dependency
+type Status struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the status of the service and all its dependencies
+type Report struct {
+	Status       string             `json:"status"`
+	Dependencies map[string]*Status `json:"dependencies,omitempty"`
+}
+
+// Checker will run checks for all dependencies of the service
+type Checker struct {
+	timeout time.Duration
+	checks  map[string]Check
+
+	// set when the service is shutting down. the value is read by the http
+	// handlers and must therefore be accessed atomically
+	draining int32
+}
+
+// NewChecker will initialize a checker for all dependencies in the given
+// services. Every check must finish within the given timeout
+func NewChecker(srv *environment.Services, timeout time.Duration) *Checker {
+
+	checker := Checker{
+		timeout: timeout,
+		checks:  make(map[string]Check),
+	}
+
+	if srv.Redis != nil {
+		checker.Add("redis", func(ctx context.Context) error {
+			return srv.Redis.WithContext(ctx).Ping().Err()
+		})
+	}
+
+	if srv.Postgres != nil {
+		checker.Add("postgres", srv.Postgres.Ping)
+	}
+
+	if srv.ProcessConn != nil {
+		checker.Add("process", grpcCheck(srv.ProcessConn))
+	}
+
+	if srv.ImageConn != nil {
+		checker.Add("image", grpcCheck(srv.ImageConn))
+	}
+
+	return &checker
+}
+
+// Add will add a check for the dependency with the given name
+func (c *Checker) Add(name string, check Check) {
+	c.checks[name] = check
+}
+
+// Drain will report the service as not ready from now on, so that no new
+// connections are routed to the service while it is shutting down
+func (c *Checker) Drain() {
+	atomic.StoreInt32(&c.draining, 1)
+}
+
+// Run will run all checks concurrently and report their status
+func (c *Checker) Run(ctx context.Context) *Report {
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{
+		Status:       statusOK,
+		Dependencies: make(map[string]*Status, len(c.checks)),
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := check(ctx)
+
+			status := Status{
+				Status:   statusOK,
+				Duration: time.Since(start).String(),
+			}
+			if err != nil {
+				status.Status = statusUnavailable
+				status.Error = err.Error()
+			}
+
+			mutex.Lock()
+			report.Dependencies[name] = &status
+			if err != nil {
+				report.Status = statusUnavailable
+			}
+			mutex.Unlock()
+		}(name, check)
+	}
+
+	wg.Wait()
+
+	return &report
+}
+
+// LivenessHandler will respond with status ok as long as the service itself
+// is running. The dependencies are not checked, as the service should not
+// be restarted if a dependency is not reachable
+func (c *Checker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, &Report{Status: statusOK})
+	})
+}
+
+// ReadinessHandler will report the status of all dependencies and respond
+// with status service unavailable if any dependency is not reachable or if
+// the service is shutting down
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if atomic.LoadInt32(&c.draining) == 1 {
+			writeReport(w, http.StatusServiceUnavailable, &Report{Status: statusDraining})
+			return
+		}
+
+		report := c.Run(r.Context())
+
+		code := http.StatusOK
+		if report.Status != statusOK {
+			code = http.StatusServiceUnavailable
+		}
+
+		writeReport(w, code, report)
+	})
+}
+
+// writeReport will send the given report as json response
+func writeReport(w http.ResponseWriter, code int, report *Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		logger.DebugError("could not encode health report", err)
+	}
+}
+
+// grpcCheck will return a check that waits until the given grpc connection
+// is ready
+func grpcCheck(conn *grpc.ClientConn) Check {
+	return func(ctx context.Context) error {
+		for {
+			state := conn.GetState()
+			switch state {
+			case connectivity.Ready:
+				return nil
+			case connectivity.Idle:
+				conn.Connect()
+			case connectivity.Shutdown:
+				return fmt.Errorf("connection is shut down")
+			}
+
+			if !conn.WaitForStateChange(ctx, state) {
+				return fmt.Errorf("connection %s", strings.ToLower(state.String()))
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+)
+
+// requestTestReport will request the given handler and return the status
+// code and the decoded report
+func requestTestReport(t *testing.T, handler http.Handler) (int, *Report) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var report Report
+	err := json.NewDecoder(recorder.Body).Decode(&report)
+	if err != nil {
+		t.Fatalf("could not decode report: %v", err)
+	}
+
+	return recorder.Code, &report
+}
+
+func TestLiveness(t *testing.T) {
+
+	// the dependencies are not checked for the liveness of the service
+	checked := false
+	checker := NewChecker(&environment.Services{}, time.Second)
+	checker.Add("redis", func(ctx context.Context) error {
+		checked = true
+		return errors.New("connection refused")
+	})
+
+	code, report := requestTestReport(t, checker.LivenessHandler())
+	if code != http.StatusOK || report.Status != statusOK || len(report.Dependencies) != 0 {
+		t.Errorf("expected running service, got %d %+v", code, report)
+	}
+	if checked {
+		t.Error("expected dependencies not to be checked")
+	}
+}
+
+func TestReadiness(t *testing.T) {
+
+	ok := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("connection refused") }
+	blocking := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		name        string
+		checks      map[string]Check
+		code        int
+		unavailable []string
+	}{
+		{"no dependencies", map[string]Check{}, http.StatusOK, nil},
+		{"reachable dependencies", map[string]Check{"redis": ok, "postgres": ok},
+			http.StatusOK, nil},
+		{"unreachable dependency", map[string]Check{"redis": ok, "postgres": failing},
+			http.StatusServiceUnavailable, []string{"postgres"}},
+		{"dependency exceeding the timeout", map[string]Check{"redis": blocking, "postgres": ok},
+			http.StatusServiceUnavailable, []string{"redis"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			checker := NewChecker(&environment.Services{}, time.Millisecond*50)
+			for name, check := range tt.checks {
+				checker.Add(name, check)
+			}
+
+			start := time.Now()
+			code, report := requestTestReport(t, checker.ReadinessHandler())
+			if code != tt.code {
+				t.Errorf("expected status %d, got %d", tt.code, code)
+			}
+			if time.Since(start) > time.Second {
+				t.Errorf("expected checks to finish within the timeout, took %s",
+					time.Since(start))
+			}
+
+			if len(report.Dependencies) != len(tt.checks) {
+				t.Fatalf("expected %d dependencies, got %+v", len(tt.checks), report.Dependencies)
+			}
+
+			unavailable := map[string]bool{}
+			for _, name := range tt.unavailable {
+				unavailable[name] = true
+			}
+
+			for name, status := range report.Dependencies {
+				if unavailable[name] != (status.Status == statusUnavailable) {
+					t.Errorf("unexpected status of %s: %+v", name, status)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessDraining(t *testing.T) {
+
+	checker := NewChecker(&environment.Services{}, time.Second)
+	checker.Add("redis", func(ctx context.Context) error { return nil })
+
+	code, report := requestTestReport(t, checker.ReadinessHandler())
+	if code != http.StatusOK || report.Status != statusOK {
+		t.Fatalf("expected ready service, got %d %s", code, report.Status)
+	}
+
+	// the service is not ready anymore as soon as it is shutting down, even
+	// though all dependencies are still reachable
+	checker.Drain()
+
+	code, report = requestTestReport(t, checker.ReadinessHandler())
+	if code != http.StatusServiceUnavailable || report.Status != statusDraining {
+		t.Errorf("expected draining service, got %d %s", code, report.Status)
+	}
+}
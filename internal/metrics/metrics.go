@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace of all metrics of the service
+const namespace = "collaboration"
+
+// Rooms is the number of document rooms that are currently open
+var Rooms = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "rooms",
+	Help:      "Number of document rooms that are currently open.",
+})
+
+// Clients is the number of clients registered in a document room
+var Clients = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "clients",
+	Help:      "Number of clients registered in a document room.",
+})
+
+// Messages is the time used by the rooms to handle client messages
+var Messages = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "message_duration_seconds",
+	Help:      "Time used to handle client messages by type.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"type"})
+
+// StepsAccepted is the number of steps that were accepted by the rooms
+var StepsAccepted = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "steps_accepted_total",
+	Help:      "Number of steps accepted and appended to the step log.",
+})
+
+// Errors is the number of error responses sent to clients by error code
+var Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "errors_total",
+	Help:      "Number of error responses sent to clients by error code.",
+}, []string{"code"})
+
+// Reloads is the number of reload responses sent to clients
+var Reloads = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "reloads_total",
+	Help:      "Number of prosemirror-reload responses sent to clients.",
+})
+
+// ClientQueueDepth is the number of queued messages of a client when a new
+// message is added to the queue
+var ClientQueueDepth = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "client_queue_depth",
+	Help:      "Number of queued outbound messages of a client when adding a message.",
+	Buckets:   []float64{0, 1, 2, 4, 8, 16, 32, 64, 128, 256, 512},
+})
+
+// ClientQueueOverflows is the number of full outbound queues by policy
+var ClientQueueOverflows = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "client_queue_overflows_total",
+	Help:      "Number of full outbound client queues by overflow policy.",
+}, []string{"policy"})
+
+// Repository is the latency of redis and postgres calls
+var Repository = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "repository_duration_seconds",
+	Help:      "Latency of redis and postgres calls by operation.",
+	Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"backend", "operation"})
+
+func init() {
+	prometheus.MustRegister(Rooms, Clients, Messages, StepsAccepted, Errors,
+		Reloads, ClientQueueDepth, ClientQueueOverflows, Repository)
+}
+
+// Handler returns the http handler to expose all metrics in the prometheus
+// text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRepository will start to measure the latency of a repository call.
+// The returned function must be called when the call is finished, i.e.
+// defer metrics.ObserveRepository("postgres", "fetch_permission")()
+func ObserveRepository(backend, operation string) func() {
+	start := time.Now()
+	return func() {
+		Repository.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	}
+}
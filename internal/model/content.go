@@ -0,0 +1,595 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ContentMatch represents a state of the finite automaton that is built
+// from the content expression of a node type. It is used to find out
+// whether further content matches the expression
+type ContentMatch struct {
+	// indicates if this state is a valid end of the node content
+	ValidEnd bool
+
+	next []matchEdge
+}
+
+// matchEdge is a transition of the content automaton
+type matchEdge struct {
+	nodeType *NodeType
+	next     *ContentMatch
+}
+
+// EmptyContentMatch is the content match of node types without content
+var EmptyContentMatch = &ContentMatch{ValidEnd: true}
+
+// MatchType will return the match state after the given node type or nil
+// if the type is not allowed at this position
+func (m *ContentMatch) MatchType(nodeType *NodeType) *ContentMatch {
+	for _, edge := range m.next {
+		if edge.nodeType == nodeType {
+			return edge.next
+		}
+	}
+	return nil
+}
+
+// MatchFragment will try to match the children of the fragment between the
+// given indices. Nil is returned if the children do not match
+func (m *ContentMatch) MatchFragment(fragment *Fragment, start, end int) *ContentMatch {
+	current := m
+	for i := start; current != nil && i < end; i++ {
+		current = current.MatchType(fragment.Child(i).Type)
+	}
+	return current
+}
+
+// InlineContent indicates if the match expects inline content
+func (m *ContentMatch) InlineContent() bool {
+	return len(m.next) != 0 && m.next[0].nodeType.IsInline()
+}
+
+// compatible indicates if the match shares a node type with the given match
+func (m *ContentMatch) compatible(other *ContentMatch) bool {
+	for _, edge := range m.next {
+		for _, otherEdge := range other.next {
+			if edge.nodeType == otherEdge.nodeType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowsType indicates if the given node type may occur anywhere in the
+// content described by the automaton
+func (m *ContentMatch) allowsType(nodeType *NodeType) bool {
+	seen := map[*ContentMatch]bool{m: true}
+	work := []*ContentMatch{m}
+	for i := 0; i < len(work); i++ {
+		for _, edge := range work[i].next {
+			if edge.nodeType == nodeType {
+				return true
+			}
+			if !seen[edge.next] {
+				seen[edge.next] = true
+				work = append(work, edge.next)
+			}
+		}
+	}
+	return false
+}
+
+// contentExpr is a parsed content expression
+type contentExpr struct {
+	kind  string // choice, seq, plus, star, opt, range or name
+	exprs []*contentExpr
+	expr  *contentExpr
+	min   int
+	max   int
+	value *NodeType
+}
+
+// tokenStream is used to read the tokens of a content expression
+type tokenStream struct {
+	source string
+	types  []*NodeType
+	tokens []string
+	pos    int
+	inline *bool
+}
+
+// parseContentMatch will parse the given content expression and build the
+// corresponding content automaton. The node types must be passed in the
+// order of their definition in the schema
+func parseContentMatch(source string, types []*NodeType) (*ContentMatch, error) {
+
+	stream := &tokenStream{source: source, types: types, tokens: tokenizeContent(source)}
+	if stream.next() == "" {
+		return EmptyContentMatch, nil
+	}
+
+	expr, err := parseExpr(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream.next() != "" {
+		return nil, stream.err("unexpected trailing text")
+	}
+
+	match := buildDFA(buildNFA(expr))
+
+	err = checkForDeadEnds(match, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	return match, nil
+}
+
+// tokenizeContent will split the content expression into names, numbers
+// and single punctuation characters
+func tokenizeContent(source string) []string {
+
+	var tokens []string
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		switch {
+		case unicode.IsSpace(runes[i]):
+			i++
+
+		case isWordRune(runes[i]):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+
+		default:
+			tokens = append(tokens, string(runes[i]))
+			i++
+		}
+	}
+
+	return tokens
+}
+
+// isWordRune indicates if the rune may be part of a name
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// next will return the current token or an empty string at the end
+func (s *tokenStream) next() string {
+	if s.pos >= len(s.tokens) {
+		return ""
+	}
+	return s.tokens[s.pos]
+}
+
+// eat will consume the current token if it corresponds to the given token
+func (s *tokenStream) eat(token string) bool {
+	if s.next() != token {
+		return false
+	}
+	s.pos++
+	return true
+}
+
+// err will return a syntax error for the content expression
+func (s *tokenStream) err(message string) error {
+	return fmt.Errorf("%s (in content expression '%s')", message, s.source)
+}
+
+func parseExpr(stream *tokenStream) (*contentExpr, error) {
+
+	var exprs []*contentExpr
+	for {
+		expr, err := parseExprSeq(stream)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if !stream.eat("|") {
+			break
+		}
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return &contentExpr{kind: "choice", exprs: exprs}, nil
+}
+
+func parseExprSeq(stream *tokenStream) (*contentExpr, error) {
+
+	var exprs []*contentExpr
+	for {
+		expr, err := parseExprSubscript(stream)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		next := stream.next()
+		if next == "" || next == ")" || next == "|" {
+			break
+		}
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return &contentExpr{kind: "seq", exprs: exprs}, nil
+}
+
+func parseExprSubscript(stream *tokenStream) (*contentExpr, error) {
+
+	expr, err := parseExprAtom(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case stream.eat("+"):
+			expr = &contentExpr{kind: "plus", expr: expr}
+		case stream.eat("*"):
+			expr = &contentExpr{kind: "star", expr: expr}
+		case stream.eat("?"):
+			expr = &contentExpr{kind: "opt", expr: expr}
+		case stream.eat("{"):
+			expr, err = parseExprRange(stream, expr)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+func parseNum(stream *tokenStream) (int, error) {
+	value, err := strconv.Atoi(stream.next())
+	if err != nil {
+		return 0, stream.err(fmt.Sprintf("expected number, got '%s'", stream.next()))
+	}
+	stream.pos++
+	return value, nil
+}
+
+func parseExprRange(stream *tokenStream, expr *contentExpr) (*contentExpr, error) {
+
+	min, err := parseNum(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	max := min
+	if stream.eat(",") {
+		if stream.next() != "}" {
+			max, err = parseNum(stream)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			max = -1
+		}
+	}
+
+	if !stream.eat("}") {
+		return nil, stream.err("unclosed braced range")
+	}
+
+	return &contentExpr{kind: "range", min: min, max: max, expr: expr}, nil
+}
+
+// resolveName will return the node type with the given name or all node
+// types of the group with the given name
+func resolveName(stream *tokenStream, name string) ([]*NodeType, error) {
+
+	var result []*NodeType
+	for _, nodeType := range stream.types {
+		if nodeType.Name == name {
+			return []*NodeType{nodeType}, nil
+		}
+	}
+
+	for _, nodeType := range stream.types {
+		if containsString(nodeType.Groups, name) {
+			result = append(result, nodeType)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, stream.err(fmt.Sprintf("no node type or group '%s' found", name))
+	}
+
+	return result, nil
+}
+
+func parseExprAtom(stream *tokenStream) (*contentExpr, error) {
+
+	if stream.eat("(") {
+		expr, err := parseExpr(stream)
+		if err != nil {
+			return nil, err
+		}
+		if !stream.eat(")") {
+			return nil, stream.err("missing closing paren")
+		}
+		return expr, nil
+	}
+
+	next := stream.next()
+	if next == "" || !isWordRune([]rune(next)[0]) {
+		return nil, stream.err(fmt.Sprintf("unexpected token '%s'", next))
+	}
+
+	types, err := resolveName(stream, next)
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := make([]*contentExpr, len(types))
+	for i, nodeType := range types {
+		inline := nodeType.IsInline()
+		if stream.inline == nil {
+			stream.inline = &inline
+		} else if *stream.inline != inline {
+			return nil, stream.err("mixing inline and block content")
+		}
+		exprs[i] = &contentExpr{kind: "name", value: nodeType}
+	}
+	stream.pos++
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return &contentExpr{kind: "choice", exprs: exprs}, nil
+}
+
+// nfaEdge is a transition of the non-deterministic automaton. Edges without
+// a node type can be followed without consuming any content
+type nfaEdge struct {
+	term *NodeType
+	to   int
+}
+
+// buildNFA will construct a non-deterministic automaton from the given
+// content expression. The last state is the accepting state
+func buildNFA(expr *contentExpr) [][]*nfaEdge {
+
+	nfa := [][]*nfaEdge{{}}
+
+	node := func() int {
+		nfa = append(nfa, []*nfaEdge{})
+		return len(nfa) - 1
+	}
+
+	edge := func(from int, to int, term *NodeType) *nfaEdge {
+		e := &nfaEdge{term: term, to: to}
+		nfa[from] = append(nfa[from], e)
+		return e
+	}
+
+	connect := func(edges []*nfaEdge, to int) {
+		for _, e := range edges {
+			e.to = to
+		}
+	}
+
+	var compile func(expr *contentExpr, from int) []*nfaEdge
+	compile = func(expr *contentExpr, from int) []*nfaEdge {
+		switch expr.kind {
+		case "choice":
+			var out []*nfaEdge
+			for _, sub := range expr.exprs {
+				out = append(out, compile(sub, from)...)
+			}
+			return out
+
+		case "seq":
+			for i := 0; ; i++ {
+				next := compile(expr.exprs[i], from)
+				if i == len(expr.exprs)-1 {
+					return next
+				}
+				from = node()
+				connect(next, from)
+			}
+
+		case "star":
+			loop := node()
+			edge(from, loop, nil)
+			connect(compile(expr.expr, loop), loop)
+			return []*nfaEdge{edge(loop, -1, nil)}
+
+		case "plus":
+			loop := node()
+			connect(compile(expr.expr, from), loop)
+			connect(compile(expr.expr, loop), loop)
+			return []*nfaEdge{edge(loop, -1, nil)}
+
+		case "opt":
+			return append([]*nfaEdge{edge(from, -1, nil)}, compile(expr.expr, from)...)
+
+		case "range":
+			current := from
+			for i := 0; i < expr.min; i++ {
+				next := node()
+				connect(compile(expr.expr, current), next)
+				current = next
+			}
+			if expr.max == -1 {
+				connect(compile(expr.expr, current), current)
+			} else {
+				for i := expr.min; i < expr.max; i++ {
+					next := node()
+					edge(current, next, nil)
+					connect(compile(expr.expr, current), next)
+					current = next
+				}
+			}
+			return []*nfaEdge{edge(current, -1, nil)}
+
+		default:
+			return []*nfaEdge{edge(from, -1, expr.value)}
+		}
+	}
+
+	end := compile(expr, 0)
+	connect(end, node())
+
+	return nfa
+}
+
+// nullFrom will return all states that can be reached from the given state
+// without consuming any content, sorted in descending order
+func nullFrom(nfa [][]*nfaEdge, start int) []int {
+
+	var result []int
+
+	var scan func(state int)
+	scan = func(state int) {
+		edges := nfa[state]
+		if len(edges) == 1 && edges[0].term == nil {
+			scan(edges[0].to)
+			return
+		}
+
+		result = append(result, state)
+		for _, e := range edges {
+			if e.term == nil && !containsInt(result, e.to) {
+				scan(e.to)
+			}
+		}
+	}
+	scan(start)
+
+	sort.Sort(sort.Reverse(sort.IntSlice(result)))
+	return result
+}
+
+// buildDFA will convert the given non-deterministic automaton into a
+// deterministic automaton of content matches
+func buildDFA(nfa [][]*nfaEdge) *ContentMatch {
+
+	labeled := make(map[string]*ContentMatch)
+
+	type transition struct {
+		term   *NodeType
+		states []int
+	}
+
+	var explore func(states []int) *ContentMatch
+	explore = func(states []int) *ContentMatch {
+
+		var out []*transition
+		for _, state := range states {
+			for _, e := range nfa[state] {
+				if e.term == nil {
+					continue
+				}
+
+				var set *transition
+				for _, t := range out {
+					if t.term == e.term {
+						set = t
+					}
+				}
+
+				for _, reachable := range nullFrom(nfa, e.to) {
+					if set == nil {
+						set = &transition{term: e.term}
+						out = append(out, set)
+					}
+					if !containsInt(set.states, reachable) {
+						set.states = append(set.states, reachable)
+					}
+				}
+			}
+		}
+
+		match := &ContentMatch{ValidEnd: containsInt(states, len(nfa)-1)}
+		labeled[stateKey(states)] = match
+
+		for _, t := range out {
+			sort.Sort(sort.Reverse(sort.IntSlice(t.states)))
+			next, ok := labeled[stateKey(t.states)]
+			if !ok {
+				next = explore(t.states)
+			}
+			match.next = append(match.next, matchEdge{nodeType: t.term, next: next})
+		}
+
+		return match
+	}
+
+	return explore(nullFrom(nfa, 0))
+}
+
+// checkForDeadEnds will make sure that every required position of the
+// content expression can be filled with a generatable node
+func checkForDeadEnds(match *ContentMatch, stream *tokenStream) error {
+
+	work := []*ContentMatch{match}
+	for i := 0; i < len(work); i++ {
+		state := work[i]
+		dead := !state.ValidEnd
+
+		var names []string
+		for _, edge := range state.next {
+			names = append(names, edge.nodeType.Name)
+			if dead && !(edge.nodeType.IsText() || edge.nodeType.hasRequiredAttrs()) {
+				dead = false
+			}
+			if !containsMatch(work, edge.next) {
+				work = append(work, edge.next)
+			}
+		}
+
+		if dead {
+			return stream.err(fmt.Sprintf("only non-generatable nodes (%s) in a required position",
+				strings.Join(names, ", ")))
+		}
+	}
+
+	return nil
+}
+
+// stateKey will return a unique key for the given set of states
+func stateKey(states []int) string {
+	parts := make([]string, len(states))
+	for i, state := range states {
+		parts[i] = strconv.Itoa(state)
+	}
+	return strings.Join(parts, ",")
+}
+
+// containsInt indicates if the given list contains the given value
+func containsInt(list []int, value int) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsMatch indicates if the given list contains the given match
+func containsMatch(list []*ContentMatch, match *ContentMatch) bool {
+	for _, entry := range list {
+		if entry == match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// testSchema will return a schema spec with the given content expression
+// for the document node
+func testSchema(docContent string) json.RawMessage {
+	spec := map[string]interface{}{
+		"nodes": map[string]interface{}{"content": []interface{}{
+			"doc", map[string]interface{}{"content": docContent},
+			"paragraph", map[string]interface{}{"content": "inline*", "group": "block"},
+			"heading", map[string]interface{}{"content": "text*", "group": "block", "marks": ""},
+			"figure", map[string]interface{}{"group": "block", "attrs": map[string]interface{}{
+				"src": map[string]interface{}{}}},
+			"text", map[string]interface{}{"group": "inline"},
+		}},
+		"marks": map[string]interface{}{"em": map[string]interface{}{}},
+	}
+
+	raw, _ := json.Marshal(spec)
+	return raw
+}
+
+func TestParseContentExpression(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"empty", "", true},
+		{"single node", "paragraph", true},
+		{"group", "block+", true},
+		{"sequence", "heading paragraph*", true},
+		{"choice", "(heading | paragraph)+", true},
+		{"range", "paragraph{2,3}", true},
+		{"open range", "paragraph{2,}", true},
+		{"optional", "heading? paragraph+", true},
+		{"unknown node type", "paragraph+ video", false},
+		{"mixed inline and block content", "(paragraph text)+", false},
+		{"unbalanced parenthesis", "(paragraph | heading", false},
+		{"invalid range", "paragraph{a}", false},
+		{"trailing text", "paragraph)", false},
+		{"required node with required attributes", "figure", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseSchema(testSchema(test.content))
+			if test.valid && err != nil {
+				t.Errorf("expression was rejected: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expression was accepted")
+			}
+		})
+	}
+}
+
+func TestCheckContent(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+		doc     string
+		valid   bool
+	}{
+		{"required node", "heading paragraph+",
+			`[{"type":"heading"},{"type":"paragraph"}]`, true},
+		{"repeated node", "heading paragraph+",
+			`[{"type":"heading"},{"type":"paragraph"},{"type":"paragraph"}]`, true},
+		{"missing node", "heading paragraph+",
+			`[{"type":"heading"}]`, false},
+		{"wrong order", "heading paragraph+",
+			`[{"type":"paragraph"},{"type":"heading"}]`, false},
+		{"range minimum", "paragraph{2,3}",
+			`[{"type":"paragraph"}]`, false},
+		{"range maximum", "paragraph{2,3}",
+			`[{"type":"paragraph"},{"type":"paragraph"},{"type":"paragraph"},{"type":"paragraph"}]`, false},
+		{"empty content", "block*", `[]`, true},
+		{"mark not allowed in heading", "heading",
+			`[{"type":"heading","content":[{"type":"text","text":"a","marks":[{"type":"em"}]}]}]`, false},
+		{"mark allowed in paragraph", "paragraph",
+			`[{"type":"paragraph","content":[{"type":"text","text":"a","marks":[{"type":"em"}]}]}]`, true},
+		{"block content in paragraph", "paragraph",
+			`[{"type":"paragraph","content":[{"type":"paragraph"}]}]`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, err := ParseSchema(testSchema(test.content))
+			if err != nil {
+				t.Fatalf("could not parse schema: %v", err)
+			}
+
+			var raw NodeJSON
+			err = json.Unmarshal([]byte(`{"type":"doc","content":`+test.doc+`}`), &raw)
+			if err != nil {
+				t.Fatalf("could not decode document: %v", err)
+			}
+
+			doc, err := NodeFromJSON(schema, raw)
+			if err == nil {
+				err = doc.Check()
+			}
+
+			if test.valid && err != nil {
+				t.Errorf("document was rejected: %v", err)
+			}
+
+			var schemaErr *SchemaError
+			if !test.valid && !errors.As(err, &schemaErr) {
+				t.Errorf("expected schema error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package model
+
+import "fmt"
+
+// Fragment represents the content of a node as a list of child nodes
+type Fragment struct {
+	content []*Node
+	size    int
+}
+
+// EmptyFragment is a fragment without any children
+var EmptyFragment = &Fragment{}
+
+// NewFragment will create a fragment from the given nodes. Note that
+// adjacent text nodes are not joined
+func NewFragment(nodes []*Node) *Fragment {
+	if len(nodes) == 0 {
+		return EmptyFragment
+	}
+	size := 0
+	for _, node := range nodes {
+		size += node.NodeSize()
+	}
+	return &Fragment{content: nodes, size: size}
+}
+
+// FragmentFromArray will create a fragment from the given nodes and join
+// adjacent text nodes with the same marks
+func FragmentFromArray(nodes []*Node) *Fragment {
+
+	if len(nodes) == 0 {
+		return EmptyFragment
+	}
+
+	var joined []*Node
+	size := 0
+
+	for i, node := range nodes {
+		size += node.NodeSize()
+		if i > 0 && node.IsText() && nodes[i-1].SameMarkup(node) {
+			if joined == nil {
+				joined = append([]*Node{}, nodes[:i]...)
+			}
+			last := joined[len(joined)-1]
+			joined[len(joined)-1] = last.withText(joinText(last.text, node.text))
+		} else if joined != nil {
+			joined = append(joined, node)
+		}
+	}
+
+	if joined == nil {
+		joined = nodes
+	}
+
+	return &Fragment{content: joined, size: size}
+}
+
+// FragmentFrom will create a fragment containing the single given node
+func FragmentFrom(node *Node) *Fragment {
+	if node == nil {
+		return EmptyFragment
+	}
+	return &Fragment{content: []*Node{node}, size: node.NodeSize()}
+}
+
+// Size returns the size of the fragment, i.e. the total size of its content
+func (f *Fragment) Size() int {
+	return f.size
+}
+
+// ChildCount returns the number of child nodes in the fragment
+func (f *Fragment) ChildCount() int {
+	return len(f.content)
+}
+
+// Child returns the child node at the given index
+func (f *Fragment) Child(index int) *Node {
+	return f.content[index]
+}
+
+// MaybeChild returns the child node at the given index or nil if the index
+// is out of range
+func (f *Fragment) MaybeChild(index int) *Node {
+	if index < 0 || index >= len(f.content) {
+		return nil
+	}
+	return f.content[index]
+}
+
+// FirstChild returns the first child of the fragment or nil
+func (f *Fragment) FirstChild() *Node {
+	return f.MaybeChild(0)
+}
+
+// LastChild returns the last child of the fragment or nil
+func (f *Fragment) LastChild() *Node {
+	return f.MaybeChild(len(f.content) - 1)
+}
+
+// Children returns the child nodes of the fragment
+func (f *Fragment) Children() []*Node {
+	return f.content
+}
+
+// NodesBetween will invoke the callback for all nodes between the given
+// positions, relative to the start of this fragment. Children of a node are
+// not visited if the callback returns false
+func (f *Fragment) NodesBetween(from, to int,
+	callback func(node *Node, pos int, parent *Node, index int) bool,
+	nodeStart int, parent *Node) {
+
+	pos := 0
+	for i := 0; pos < to && i < len(f.content); i++ {
+		child := f.content[i]
+		end := pos + child.NodeSize()
+		if end > from && callback(child, nodeStart+pos, parent, i) && child.Content.Size() > 0 {
+			start := pos + 1
+			child.Content.NodesBetween(maxInt(0, from-start),
+				minInt(child.Content.Size(), to-start), callback, nodeStart+start, child)
+		}
+		pos = end
+	}
+}
+
+// Cut will return a fragment containing only the content between the
+// given positions
+func (f *Fragment) Cut(from, to int) *Fragment {
+
+	if from == 0 && to == f.size {
+		return f
+	}
+
+	var result []*Node
+	size := 0
+
+	if to > from {
+		pos := 0
+		for i := 0; pos < to && i < len(f.content); i++ {
+			child := f.content[i]
+			end := pos + child.NodeSize()
+			if end > from {
+				if pos < from || end > to {
+					if child.IsText() {
+						child = child.cut(maxInt(0, from-pos), minInt(len(child.text), to-pos))
+					} else {
+						child = child.Cut(maxInt(0, from-pos-1), minInt(child.Content.Size(), to-pos-1))
+					}
+				}
+				result = append(result, child)
+				size += child.NodeSize()
+			}
+			pos = end
+		}
+	}
+
+	if len(result) == 0 {
+		return EmptyFragment
+	}
+
+	return &Fragment{content: result, size: size}
+}
+
+// Append will create a new fragment containing the content of this and the
+// given fragment. Adjacent text nodes are joined
+func (f *Fragment) Append(other *Fragment) *Fragment {
+
+	if other.size == 0 {
+		return f
+	}
+	if f.size == 0 {
+		return other
+	}
+
+	last := f.LastChild()
+	first := other.FirstChild()
+
+	content := make([]*Node, 0, len(f.content)+len(other.content))
+	content = append(content, f.content...)
+
+	i := 0
+	if last.IsText() && last.SameMarkup(first) {
+		content[len(content)-1] = last.withText(joinText(last.text, first.text))
+		i = 1
+	}
+	content = append(content, other.content[i:]...)
+
+	return &Fragment{content: content, size: f.size + other.size}
+}
+
+// ReplaceChild will create a new fragment with the child at the given index
+// replaced by the given node
+func (f *Fragment) ReplaceChild(index int, node *Node) *Fragment {
+
+	current := f.content[index]
+	if current == node {
+		return f
+	}
+
+	content := append([]*Node{}, f.content...)
+	content[index] = node
+
+	return &Fragment{content: content, size: f.size + node.NodeSize() - current.NodeSize()}
+}
+
+// Eq indicates if the fragment has the same content as the given fragment
+func (f *Fragment) Eq(other *Fragment) bool {
+	if len(f.content) != len(other.content) {
+		return false
+	}
+	for i := range f.content {
+		if !f.content[i].Eq(other.content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// findIndex will find the index and the start offset of the child that
+// contains the given position. If round is positive, positions at the end
+// of a child are rounded to the next child
+func (f *Fragment) findIndex(pos int, round int) (int, int, error) {
+
+	if pos == 0 {
+		return 0, pos, nil
+	}
+	if pos == f.size {
+		return len(f.content), pos, nil
+	}
+	if pos > f.size || pos < 0 {
+		return 0, 0, fmt.Errorf("position %d outside of fragment", pos)
+	}
+
+	curPos := 0
+	for i := 0; ; i++ {
+		cur := f.content[i]
+		end := curPos + cur.NodeSize()
+		if end >= pos {
+			if end == pos || round > 0 {
+				return i + 1, end, nil
+			}
+			return i, curPos, nil
+		}
+		curPos = end
+	}
+}
+
+// ToJSON will convert the fragment into its json representation
+func (f *Fragment) ToJSON() []NodeJSON {
+	if len(f.content) == 0 {
+		return nil
+	}
+	result := make([]NodeJSON, len(f.content))
+	for i, child := range f.content {
+		result[i] = child.ToJSON()
+	}
+	return result
+}
+
+// FragmentFromJSON will create a fragment from its json representation
+func FragmentFromJSON(schema *Schema, nodes []NodeJSON) (*Fragment, error) {
+	if len(nodes) == 0 {
+		return EmptyFragment, nil
+	}
+	content := make([]*Node, len(nodes))
+	for i := range nodes {
+		node, err := NodeFromJSON(schema, nodes[i])
+		if err != nil {
+			return nil, err
+		}
+		content[i] = node
+	}
+	return NewFragment(content), nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Mark is a piece of information that can be attached to a node, such as
+// emphasis, a link or a comment
+type Mark struct {
+	Type  *MarkType
+	Attrs map[string]interface{}
+}
+
+// MarkJSON is the json representation of a mark
+type MarkJSON struct {
+	Type  string                 `json:"type"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// MarkFromJSON will create a mark from its json representation
+func MarkFromJSON(schema *Schema, raw MarkJSON) (*Mark, error) {
+	markType := schema.MarkType(raw.Type)
+	if markType == nil {
+		return nil, newSchemaError("there is no mark type %s in this schema", raw.Type)
+	}
+	err := markType.checkAttrs(raw.Attrs)
+	if err != nil {
+		return nil, err
+	}
+	return markType.Create(raw.Attrs)
+}
+
+// ToJSON will convert the mark to its json representation
+func (m *Mark) ToJSON() MarkJSON {
+	result := MarkJSON{Type: m.Type.Name}
+	if len(m.Attrs) > 0 {
+		result.Attrs = m.Attrs
+	}
+	return result
+}
+
+// Eq indicates if the mark has the same type and attributes as the given mark
+func (m *Mark) Eq(other *Mark) bool {
+	if m == other {
+		return true
+	}
+	return m.Type == other.Type && reflect.DeepEqual(m.Attrs, other.Attrs)
+}
+
+// AddToSet will return a new set of marks containing this mark at the
+// correct position. Marks that are excluded by this mark are removed
+func (m *Mark) AddToSet(set []*Mark) []*Mark {
+
+	var result []*Mark
+	copied := false
+	placed := false
+
+	for i, other := range set {
+		if m.Eq(other) {
+			return set
+		}
+
+		if m.Type.Excludes(other.Type) {
+			if !copied {
+				result = append([]*Mark{}, set[:i]...)
+				copied = true
+			}
+		} else if other.Type.Excludes(m.Type) {
+			return set
+		} else {
+			if !placed && other.Type.Rank > m.Type.Rank {
+				if !copied {
+					result = append([]*Mark{}, set[:i]...)
+					copied = true
+				}
+				result = append(result, m)
+				placed = true
+			}
+			if copied {
+				result = append(result, other)
+			}
+		}
+	}
+
+	if !copied {
+		result = append([]*Mark{}, set...)
+	}
+	if !placed {
+		result = append(result, m)
+	}
+
+	return result
+}
+
+// RemoveFromSet will return a set of marks without this mark
+func (m *Mark) RemoveFromSet(set []*Mark) []*Mark {
+	for i, other := range set {
+		if m.Eq(other) {
+			result := append([]*Mark{}, set[:i]...)
+			return append(result, set[i+1:]...)
+		}
+	}
+	return set
+}
+
+// IsInSet indicates if the mark is part of the given set
+func (m *Mark) IsInSet(set []*Mark) bool {
+	for _, other := range set {
+		if m.Eq(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// SameMarkSet indicates if the two sets of marks are identical
+func SameMarkSet(a, b []*Mark) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Eq(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeMarks will sort the given marks according to their rank in the
+// schema
+func normalizeMarks(marks []*Mark) []*Mark {
+	if len(marks) == 0 {
+		return nil
+	}
+	sorted := append([]*Mark{}, marks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Type.Rank < sorted[j].Type.Rank
+	})
+	return sorted
+}
@@ -0,0 +1,377 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"unicode/utf16"
+)
+
+// Node is a single node of a prosemirror document. Nodes are immutable and
+// any modification will create a new node instead
+type Node struct {
+	Type    *NodeType
+	Attrs   map[string]interface{}
+	Content *Fragment
+	Marks   []*Mark
+
+	// text content of text nodes, encoded as utf16 since prosemirror
+	// positions are based on javascript string lengths
+	text []uint16
+}
+
+// NodeJSON is the json representation of a node
+type NodeJSON struct {
+	Type    string                 `json:"type"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+	Content []NodeJSON             `json:"content,omitempty"`
+	Marks   []MarkJSON             `json:"marks,omitempty"`
+	Text    *string                `json:"text,omitempty"`
+}
+
+// NodeFromJSON will create a node from its json representation
+func NodeFromJSON(schema *Schema, raw NodeJSON) (*Node, error) {
+
+	nodeType := schema.NodeType(raw.Type)
+	if nodeType == nil {
+		return nil, newSchemaError("unknown node type: %s", raw.Type)
+	}
+
+	var marks []*Mark
+	for _, rawMark := range raw.Marks {
+		mark, err := MarkFromJSON(schema, rawMark)
+		if err != nil {
+			return nil, err
+		}
+		marks = append(marks, mark)
+	}
+
+	if nodeType.IsText() {
+		if raw.Text == nil || *raw.Text == "" {
+			return nil, fmt.Errorf("empty text nodes are not allowed")
+		}
+		return &Node{Type: nodeType, Attrs: map[string]interface{}{},
+			Content: EmptyFragment, Marks: normalizeMarks(marks),
+			text: utf16.Encode([]rune(*raw.Text))}, nil
+	}
+
+	err := nodeType.checkAttrs(raw.Attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := FragmentFromJSON(schema, raw.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	return nodeType.Create(raw.Attrs, content, marks)
+}
+
+// Check will verify that the node and all its descendants conform to the
+// schema, i.e. that the content matches the content expressions and that
+// only allowed marks are used
+func (n *Node) Check() error {
+
+	err := n.Type.checkContent(n.Content)
+	if err != nil {
+		return err
+	}
+
+	err = checkMarkSet(n)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n.ChildCount(); i++ {
+		err = n.Child(i).Check()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkMarkSet will verify that the marks of the node form a valid set,
+// i.e. that they do not exclude each other
+func checkMarkSet(n *Node) error {
+
+	var set []*Mark
+	for _, mark := range n.Marks {
+		set = mark.AddToSet(set)
+	}
+
+	if !SameMarkSet(set, n.Marks) {
+		return newSchemaError("invalid collection of marks for node %s", n.Type.Name)
+	}
+
+	return nil
+}
+
+// ToJSON will convert the node into its json representation
+func (n *Node) ToJSON() NodeJSON {
+
+	result := NodeJSON{Type: n.Type.Name}
+
+	if len(n.Attrs) > 0 {
+		result.Attrs = n.Attrs
+	}
+
+	for _, mark := range n.Marks {
+		result.Marks = append(result.Marks, mark.ToJSON())
+	}
+
+	if n.IsText() {
+		text := n.Text()
+		result.Text = &text
+		return result
+	}
+
+	result.Content = n.Content.ToJSON()
+	return result
+}
+
+// IsText indicates if the node is a text node
+func (n *Node) IsText() bool {
+	return n.Type.IsText()
+}
+
+// IsLeaf indicates if the node may not have any content
+func (n *Node) IsLeaf() bool {
+	return n.Type.IsLeaf()
+}
+
+// IsAtom indicates if the node should be treated as a single unit
+func (n *Node) IsAtom() bool {
+	return n.Type.IsAtom()
+}
+
+// IsInline indicates if the node is an inline node
+func (n *Node) IsInline() bool {
+	return n.Type.IsInline()
+}
+
+// Text returns the text content of a text node
+func (n *Node) Text() string {
+	return string(utf16.Decode(n.text))
+}
+
+// NodeSize returns the size of the node, i.e. the number of positions it
+// occupies in the document
+func (n *Node) NodeSize() int {
+	if n.IsText() {
+		return len(n.text)
+	}
+	if n.IsLeaf() {
+		return 1
+	}
+	return 2 + n.Content.Size()
+}
+
+// ChildCount returns the number of children of the node
+func (n *Node) ChildCount() int {
+	return n.Content.ChildCount()
+}
+
+// Child returns the child at the given index
+func (n *Node) Child(index int) *Node {
+	return n.Content.Child(index)
+}
+
+// MaybeChild returns the child at the given index or nil if the index is
+// out of range
+func (n *Node) MaybeChild(index int) *Node {
+	return n.Content.MaybeChild(index)
+}
+
+// FirstChild returns the first child of the node or nil
+func (n *Node) FirstChild() *Node {
+	return n.Content.FirstChild()
+}
+
+// SameMarkup indicates if the node has the same type, attributes and marks
+// as the given node
+func (n *Node) SameMarkup(other *Node) bool {
+	return n.HasMarkup(other.Type, other.Attrs, other.Marks)
+}
+
+// HasMarkup indicates if the node has the given type, attributes and marks
+func (n *Node) HasMarkup(nodeType *NodeType, attrs map[string]interface{}, marks []*Mark) bool {
+	return n.Type == nodeType && reflect.DeepEqual(n.Attrs, attrs) && SameMarkSet(n.Marks, marks)
+}
+
+// Eq indicates if the node is identical to the given node
+func (n *Node) Eq(other *Node) bool {
+	if n == other {
+		return true
+	}
+	if n.IsText() {
+		return n.SameMarkup(other) && equalText(n.text, other.text)
+	}
+	return n.SameMarkup(other) && n.Content.Eq(other.Content)
+}
+
+// Copy will create a new node with the same markup but the given content
+func (n *Node) Copy(content *Fragment) *Node {
+	if content == n.Content {
+		return n
+	}
+	return &Node{Type: n.Type, Attrs: n.Attrs, Content: content, Marks: n.Marks}
+}
+
+// Mark will create a copy of the node with the given set of marks
+func (n *Node) Mark(marks []*Mark) *Node {
+	if SameMarkSet(marks, n.Marks) {
+		return n
+	}
+	return &Node{Type: n.Type, Attrs: n.Attrs, Content: n.Content, Marks: marks, text: n.text}
+}
+
+// Cut will create a copy of the node containing only the content between
+// the given positions
+func (n *Node) Cut(from, to int) *Node {
+	if n.IsText() {
+		return n.cut(from, to)
+	}
+	if from == 0 && to == n.Content.Size() {
+		return n
+	}
+	return n.Copy(n.Content.Cut(from, to))
+}
+
+// cut will cut the text of a text node at the given utf16 offsets
+func (n *Node) cut(from, to int) *Node {
+	if from == 0 && to == len(n.text) {
+		return n
+	}
+	return n.withText(n.text[from:to])
+}
+
+// withText will create a copy of the text node with the given text
+func (n *Node) withText(text []uint16) *Node {
+	return &Node{Type: n.Type, Attrs: n.Attrs, Content: EmptyFragment, Marks: n.Marks, text: text}
+}
+
+// Slice will cut out the part of the document between the given positions
+// and return it as slice
+func (n *Node) Slice(from, to int, includeParents bool) (*Slice, error) {
+
+	if from == to {
+		return EmptySlice, nil
+	}
+
+	rFrom, err := n.Resolve(from)
+	if err != nil {
+		return nil, err
+	}
+
+	rTo, err := n.Resolve(to)
+	if err != nil {
+		return nil, err
+	}
+
+	depth := 0
+	if !includeParents {
+		depth = rFrom.SharedDepth(to)
+	}
+
+	start := rFrom.Start(depth)
+	node := rFrom.Node(depth)
+	content := node.Content.Cut(rFrom.Pos-start, rTo.Pos-start)
+
+	return NewSlice(content, rFrom.Depth-depth, rTo.Depth-depth), nil
+}
+
+// Replace will replace the part of the document between the given
+// positions with the given slice
+func (n *Node) Replace(from, to int, slice *Slice) (*Node, error) {
+
+	if from > to {
+		return nil, newReplaceError("invalid replace range %d-%d", from, to)
+	}
+
+	rFrom, err := n.Resolve(from)
+	if err != nil {
+		return nil, err
+	}
+
+	rTo, err := n.Resolve(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return replace(rFrom, rTo, slice)
+}
+
+// NodeAt returns the node directly after the given position or nil
+func (n *Node) NodeAt(pos int) *Node {
+	node := n
+	for {
+		index, offset, err := node.Content.findIndex(pos, -1)
+		if err != nil {
+			return nil
+		}
+		node = node.MaybeChild(index)
+		if node == nil {
+			return nil
+		}
+		if offset == pos || node.IsText() {
+			return node
+		}
+		pos -= offset + 1
+	}
+}
+
+// NodesBetween will invoke the callback for all descendants between the
+// given positions
+func (n *Node) NodesBetween(from, to int,
+	callback func(node *Node, pos int, parent *Node, index int) bool) {
+	n.Content.NodesBetween(from, to, callback, 0, n)
+}
+
+// TextBetween returns the text content between the given positions.
+// Leaf nodes are represented by the given leaf text and block boundaries
+// by the given block separator
+func (n *Node) TextBetween(from, to int, blockSeparator, leafText string) string {
+
+	text := ""
+	separated := true
+
+	n.NodesBetween(from, to, func(node *Node, pos int, parent *Node, index int) bool {
+		if node.IsText() {
+			start := maxInt(from, pos) - pos
+			end := minInt(len(node.text), to-pos)
+			text += string(utf16.Decode(node.text[start:end]))
+			separated = blockSeparator == ""
+		} else if node.IsLeaf() {
+			text += leafText
+			separated = blockSeparator == ""
+		} else if !separated && !node.IsInline() {
+			text += blockSeparator
+			separated = true
+		}
+		return true
+	})
+
+	return text
+}
+
+// joinText will concatenate the given utf16 encoded texts
+func joinText(a, b []uint16) []uint16 {
+	result := make([]uint16, 0, len(a)+len(b))
+	result = append(result, a...)
+	return append(result, b...)
+}
+
+// equalText indicates if the given utf16 encoded texts are identical
+func equalText(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
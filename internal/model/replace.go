@@ -0,0 +1,295 @@
+package model
+
+import "fmt"
+
+// ReplaceError is returned if a replace operation is not possible
+type ReplaceError struct {
+	Message string
+}
+
+func (e *ReplaceError) Error() string {
+	return e.Message
+}
+
+// newReplaceError will create a new replace error with the given message
+func newReplaceError(format string, args ...interface{}) error {
+	return &ReplaceError{Message: fmt.Sprintf(format, args...)}
+}
+
+// replace will replace the content between the given resolved positions
+// with the given slice
+func replace(rFrom, rTo *ResolvedPos, slice *Slice) (*Node, error) {
+
+	if slice.OpenStart > rFrom.Depth {
+		return nil, newReplaceError("inserted content deeper than insertion position")
+	}
+
+	if rFrom.Depth-slice.OpenStart != rTo.Depth-slice.OpenEnd {
+		return nil, newReplaceError("inconsistent open depths")
+	}
+
+	return replaceOuter(rFrom, rTo, slice, 0)
+}
+
+func replaceOuter(rFrom, rTo *ResolvedPos, slice *Slice, depth int) (*Node, error) {
+
+	index := rFrom.Index(depth)
+	node := rFrom.Node(depth)
+
+	if index == rTo.Index(depth) && depth < rFrom.Depth-slice.OpenStart {
+		inner, err := replaceOuter(rFrom, rTo, slice, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return node.Copy(node.Content.ReplaceChild(index, inner)), nil
+	}
+
+	if slice.Content.Size() == 0 {
+		content, err := replaceTwoWay(rFrom, rTo, depth)
+		if err != nil {
+			return nil, err
+		}
+		return closeNode(node, content)
+	}
+
+	// handle the simple, flat case
+	if slice.OpenStart == 0 && slice.OpenEnd == 0 && rFrom.Depth == depth && rTo.Depth == depth {
+		parent := rFrom.Parent()
+		content := parent.Content
+		return closeNode(parent, content.Cut(0, rFrom.ParentOffset).Append(slice.Content).
+			Append(content.Cut(rTo.ParentOffset, content.Size())))
+	}
+
+	start, end, err := prepareSliceForReplace(slice, rFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := replaceThreeWay(rFrom, start, end, rTo, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	return closeNode(node, content)
+}
+
+// checkJoin will verify that the content of sub can be joined onto main
+func checkJoin(main, sub *Node) error {
+	if !sub.Type.compatibleContent(main.Type) {
+		return newReplaceError("cannot join %s onto %s", sub.Type.Name, main.Type.Name)
+	}
+	return nil
+}
+
+// joinable will return the node at the given depth of before, if it can be
+// joined with the node at the same depth of after
+func joinable(before, after *ResolvedPos, depth int) (*Node, error) {
+	node := before.Node(depth)
+	err := checkJoin(node, after.Node(depth))
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// addNode will add the child to the target and join adjacent text nodes
+func addNode(child *Node, target []*Node) []*Node {
+	last := len(target) - 1
+	if last >= 0 && child.IsText() && child.SameMarkup(target[last]) {
+		target[last] = child.withText(joinText(target[last].text, child.text))
+		return target
+	}
+	return append(target, child)
+}
+
+// addRange will add all nodes between the given positions at the given
+// depth to the target. Either start or end may be nil
+func addRange(start, end *ResolvedPos, depth int, target []*Node) []*Node {
+
+	var node *Node
+	if end != nil {
+		node = end.Node(depth)
+	} else {
+		node = start.Node(depth)
+	}
+
+	startIndex := 0
+	endIndex := node.ChildCount()
+	if end != nil {
+		endIndex = end.Index(depth)
+	}
+
+	if start != nil {
+		startIndex = start.Index(depth)
+		if start.Depth > depth {
+			startIndex++
+		} else if start.TextOffset() > 0 {
+			target = addNode(start.NodeAfter(), target)
+			startIndex++
+		}
+	}
+
+	for i := startIndex; i < endIndex; i++ {
+		target = addNode(node.Child(i), target)
+	}
+
+	if end != nil && end.Depth == depth && end.TextOffset() > 0 {
+		target = addNode(end.NodeBefore(), target)
+	}
+
+	return target
+}
+
+// closeNode will verify the content and create a copy of the node with it
+func closeNode(node *Node, content *Fragment) (*Node, error) {
+	err := node.Type.checkContent(content)
+	if err != nil {
+		return nil, newReplaceError(err.Error())
+	}
+	return node.Copy(content), nil
+}
+
+func replaceThreeWay(rFrom, start, end, rTo *ResolvedPos, depth int) (*Fragment, error) {
+
+	var openStart, openEnd *Node
+	var err error
+
+	if rFrom.Depth > depth {
+		openStart, err = joinable(rFrom, start, depth+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rTo.Depth > depth {
+		openEnd, err = joinable(end, rTo, depth+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	content := addRange(nil, rFrom, depth, nil)
+
+	if openStart != nil && openEnd != nil && start.Index(depth) == end.Index(depth) {
+		err := checkJoin(openStart, openEnd)
+		if err != nil {
+			return nil, err
+		}
+
+		inner, err := replaceThreeWay(rFrom, start, end, rTo, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		closed, err := closeNode(openStart, inner)
+		if err != nil {
+			return nil, err
+		}
+		content = addNode(closed, content)
+
+	} else {
+		if openStart != nil {
+			inner, err := replaceTwoWay(rFrom, start, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			closed, err := closeNode(openStart, inner)
+			if err != nil {
+				return nil, err
+			}
+			content = addNode(closed, content)
+		}
+
+		content = addRange(start, end, depth, content)
+
+		if openEnd != nil {
+			inner, err := replaceTwoWay(end, rTo, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			closed, err := closeNode(openEnd, inner)
+			if err != nil {
+				return nil, err
+			}
+			content = addNode(closed, content)
+		}
+	}
+
+	content = addRange(rTo, nil, depth, content)
+	return NewFragment(content), nil
+}
+
+func replaceTwoWay(rFrom, rTo *ResolvedPos, depth int) (*Fragment, error) {
+
+	content := addRange(nil, rFrom, depth, nil)
+
+	if rFrom.Depth > depth {
+		nodeType, err := joinable(rFrom, rTo, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		inner, err := replaceTwoWay(rFrom, rTo, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		closed, err := closeNode(nodeType, inner)
+		if err != nil {
+			return nil, err
+		}
+		content = addNode(closed, content)
+	}
+
+	content = addRange(rTo, nil, depth, content)
+	return NewFragment(content), nil
+}
+
+// prepareSliceForReplace will wrap the slice content in the ancestors of
+// the given position and resolve the start and end of the slice content
+func prepareSliceForReplace(slice *Slice, along *ResolvedPos) (*ResolvedPos, *ResolvedPos, error) {
+
+	extra := along.Depth - slice.OpenStart
+	parent := along.Node(extra)
+	node := parent.Copy(slice.Content)
+
+	for i := extra - 1; i >= 0; i-- {
+		node = along.Node(i).Copy(FragmentFrom(node))
+	}
+
+	start, err := node.Resolve(slice.OpenStart + extra)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	end, err := node.Resolve(node.Content.Size() - slice.OpenEnd - extra)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return start, end, nil
+}
+
+// canReplace indicates if replacing the children between the given indices
+// with the given fragment would result in valid content
+func (n *Node) canReplace(from, to int, replacement *Fragment) bool {
+
+	match := n.Type.ContentMatch.MatchFragment(n.Content, 0, from)
+	if match != nil {
+		match = match.MatchFragment(replacement, 0, replacement.ChildCount())
+	}
+	if match != nil {
+		match = match.MatchFragment(n.Content, to, n.ChildCount())
+	}
+	if match == nil || !match.ValidEnd {
+		return false
+	}
+
+	for i := 0; i < replacement.ChildCount(); i++ {
+		if !n.Type.AllowsMarks(replacement.Child(i).Marks) {
+			return false
+		}
+	}
+
+	return true
+}
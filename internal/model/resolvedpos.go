@@ -0,0 +1,175 @@
+package model
+
+import "fmt"
+
+// ResolvedPos holds information about a position in a document, such as
+// the ancestor nodes and the offsets within them
+type ResolvedPos struct {
+	Pos          int
+	Depth        int
+	ParentOffset int
+	path         []pathEntry
+}
+
+// pathEntry holds an ancestor of a resolved position with the index of the
+// position inside the ancestor and the start offset of the respective child
+type pathEntry struct {
+	node   *Node
+	index  int
+	offset int
+}
+
+// Resolve will resolve the given position in the document
+func (n *Node) Resolve(pos int) (*ResolvedPos, error) {
+
+	if pos < 0 || pos > n.Content.Size() {
+		return nil, fmt.Errorf("position %d out of range", pos)
+	}
+
+	var path []pathEntry
+	start := 0
+	parentOffset := pos
+
+	for node := n; ; {
+		index, offset, err := node.Content.findIndex(parentOffset, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		rem := parentOffset - offset
+		path = append(path, pathEntry{node: node, index: index, offset: start + offset})
+		if rem == 0 {
+			break
+		}
+
+		node = node.Child(index)
+		if node.IsText() {
+			break
+		}
+
+		parentOffset = rem - 1
+		start += offset + 1
+	}
+
+	return &ResolvedPos{Pos: pos, Depth: len(path) - 1, ParentOffset: parentOffset, path: path}, nil
+}
+
+// resolveDepth will convert negative depths to depths relative to the
+// depth of the position
+func (r *ResolvedPos) resolveDepth(depth int) int {
+	if depth < 0 {
+		return r.Depth + depth
+	}
+	return depth
+}
+
+// Node returns the ancestor node at the given depth
+func (r *ResolvedPos) Node(depth int) *Node {
+	return r.path[r.resolveDepth(depth)].node
+}
+
+// Parent returns the direct parent node of the position
+func (r *ResolvedPos) Parent() *Node {
+	return r.Node(r.Depth)
+}
+
+// Doc returns the root node in which the position was resolved
+func (r *ResolvedPos) Doc() *Node {
+	return r.Node(0)
+}
+
+// Index returns the index into the ancestor at the given depth
+func (r *ResolvedPos) Index(depth int) int {
+	return r.path[r.resolveDepth(depth)].index
+}
+
+// IndexAfter returns the index pointing after the position into the
+// ancestor at the given depth
+func (r *ResolvedPos) IndexAfter(depth int) int {
+	depth = r.resolveDepth(depth)
+	if depth == r.Depth && r.TextOffset() == 0 {
+		return r.Index(depth)
+	}
+	return r.Index(depth) + 1
+}
+
+// Start returns the absolute position at which the ancestor at the given
+// depth starts
+func (r *ResolvedPos) Start(depth int) int {
+	depth = r.resolveDepth(depth)
+	if depth == 0 {
+		return 0
+	}
+	return r.path[depth-1].offset + 1
+}
+
+// End returns the absolute position at which the ancestor at the given
+// depth ends
+func (r *ResolvedPos) End(depth int) int {
+	depth = r.resolveDepth(depth)
+	return r.Start(depth) + r.Node(depth).Content.Size()
+}
+
+// Before returns the absolute position directly before the wrapping node
+// at the given depth
+func (r *ResolvedPos) Before(depth int) int {
+	depth = r.resolveDepth(depth)
+	if depth == r.Depth+1 {
+		return r.Pos
+	}
+	return r.path[depth-1].offset
+}
+
+// After returns the absolute position directly after the wrapping node at
+// the given depth
+func (r *ResolvedPos) After(depth int) int {
+	depth = r.resolveDepth(depth)
+	if depth == r.Depth+1 {
+		return r.Pos
+	}
+	return r.path[depth-1].offset + r.path[depth].node.NodeSize()
+}
+
+// TextOffset returns the offset of the position into a text node
+func (r *ResolvedPos) TextOffset() int {
+	return r.Pos - r.path[len(r.path)-1].offset
+}
+
+// NodeAfter returns the node directly after the position
+func (r *ResolvedPos) NodeAfter() *Node {
+	parent := r.Parent()
+	index := r.Index(r.Depth)
+	if index == parent.ChildCount() {
+		return nil
+	}
+	dOff := r.TextOffset()
+	child := parent.Child(index)
+	if dOff > 0 {
+		return child.cut(dOff, len(child.text))
+	}
+	return child
+}
+
+// NodeBefore returns the node directly before the position
+func (r *ResolvedPos) NodeBefore() *Node {
+	index := r.Index(r.Depth)
+	dOff := r.TextOffset()
+	if dOff > 0 {
+		return r.Parent().Child(index).cut(0, dOff)
+	}
+	if index == 0 {
+		return nil
+	}
+	return r.Parent().Child(index - 1)
+}
+
+// SharedDepth returns the depth up to which this position and the given
+// position share the same parent nodes
+func (r *ResolvedPos) SharedDepth(pos int) int {
+	for depth := r.Depth; depth > 0; depth-- {
+		if r.Start(depth) <= pos && r.End(depth) >= pos {
+			return depth
+		}
+	}
+	return 0
+}
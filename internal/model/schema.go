@@ -0,0 +1,581 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SchemaError is returned if content does not conform to the schema, i.e.
+// it was most likely created by an editor using a different schema
+type SchemaError struct {
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	return e.Message
+}
+
+// newSchemaError will create a new schema error with the given message
+func newSchemaError(format string, args ...interface{}) error {
+	return &SchemaError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Schema describes the node and mark types that may occur in a document.
+// It is parsed from the schema spec that the editor sends on initialization
+type Schema struct {
+	Nodes     map[string]*NodeType
+	Marks     map[string]*MarkType
+	TopNode   *NodeType
+	nodeOrder []*NodeType
+	markOrder []*MarkType
+}
+
+// Attribute describes a node or mark attribute and its default value
+type Attribute struct {
+	HasDefault bool
+	Default    interface{}
+}
+
+// NodeType holds the specification of a single node type of the schema
+type NodeType struct {
+	Name    string
+	Schema  *Schema
+	Groups  []string
+	Attrs   map[string]*Attribute
+	Content string // content expression of the node
+	Inline  bool
+	Atom    bool
+
+	// automaton describing the allowed content of the node
+	ContentMatch *ContentMatch
+
+	// marks allowed in the node, nil if all marks are allowed
+	markSet []*MarkType
+}
+
+// MarkType holds the specification of a single mark type of the schema
+type MarkType struct {
+	Name   string
+	Schema *Schema
+	Rank   int
+	Attrs  map[string]*Attribute
+
+	// marks that may not be combined with this mark
+	excluded []*MarkType
+}
+
+// nodeSpec corresponds to the json encoded node specification of prosemirror
+type nodeSpec struct {
+	Content string                     `json:"content"`
+	Marks   *string                    `json:"marks"`
+	Group   string                     `json:"group"`
+	Inline  bool                       `json:"inline"`
+	Atom    bool                       `json:"atom"`
+	Attrs   map[string]json.RawMessage `json:"attrs"`
+}
+
+// markSpec corresponds to the json encoded mark specification of prosemirror
+type markSpec struct {
+	Excludes *string                    `json:"excludes"`
+	Group    string                     `json:"group"`
+	Attrs    map[string]json.RawMessage `json:"attrs"`
+}
+
+// schemaSpec corresponds to the json encoded schema specification
+type schemaSpec struct {
+	Nodes   json.RawMessage `json:"nodes"`
+	Marks   json.RawMessage `json:"marks"`
+	TopNode string          `json:"topNode"`
+}
+
+// namedSpec is a single entry of an ordered spec map
+type namedSpec struct {
+	Name string
+	Spec json.RawMessage
+}
+
+// ParseSchema will parse the given json encoded prosemirror schema spec.
+// Nodes and marks can either be passed as plain objects or as serialized
+// ordered maps (i.e. {"content": [name, spec, name, spec, ...]})
+func ParseSchema(raw json.RawMessage) (*Schema, error) {
+
+	var spec schemaSpec
+	err := json.Unmarshal(raw, &spec)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse schema spec: %w", err)
+	}
+
+	nodes, err := decodeOrderedSpec(spec.Nodes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse node specs: %w", err)
+	}
+
+	marks, err := decodeOrderedSpec(spec.Marks)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse mark specs: %w", err)
+	}
+
+	schema := Schema{}
+	schema.Nodes = make(map[string]*NodeType)
+	schema.Marks = make(map[string]*MarkType)
+
+	// parse all mark types first, as node types reference them
+	markSpecs := make(map[string]markSpec)
+	for i, entry := range marks {
+		var ms markSpec
+		err := json.Unmarshal(entry.Spec, &ms)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse mark spec %s: %w", entry.Name, err)
+		}
+
+		attrs, err := parseAttributes(ms.Attrs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attributes on mark %s: %w", entry.Name, err)
+		}
+
+		markType := &MarkType{Name: entry.Name, Schema: &schema, Rank: i, Attrs: attrs}
+		schema.Marks[entry.Name] = markType
+		schema.markOrder = append(schema.markOrder, markType)
+		markSpecs[entry.Name] = ms
+	}
+
+	// resolve the marks excluded by each mark type. marks exclude
+	// themselves if nothing else is specified
+	for _, markType := range schema.markOrder {
+		ms := markSpecs[markType.Name]
+		if ms.Excludes == nil {
+			markType.excluded = []*MarkType{markType}
+			continue
+		}
+
+		markType.excluded, err = schema.gatherMarks(*ms.Excludes, markSpecs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range nodes {
+		var ns nodeSpec
+		err := json.Unmarshal(entry.Spec, &ns)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse node spec %s: %w", entry.Name, err)
+		}
+
+		attrs, err := parseAttributes(ns.Attrs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attributes on node %s: %w", entry.Name, err)
+		}
+
+		nodeType := &NodeType{
+			Name:    entry.Name,
+			Schema:  &schema,
+			Groups:  strings.Fields(ns.Group),
+			Attrs:   attrs,
+			Content: ns.Content,
+			Inline:  ns.Inline || entry.Name == "text",
+			Atom:    ns.Atom,
+		}
+
+		if ns.Marks != nil && *ns.Marks != "_" {
+			nodeType.markSet, err = schema.gatherMarks(*ns.Marks, markSpecs)
+			if err != nil {
+				return nil, err
+			}
+			if nodeType.markSet == nil {
+				nodeType.markSet = []*MarkType{}
+			}
+		}
+
+		schema.Nodes[entry.Name] = nodeType
+		schema.nodeOrder = append(schema.nodeOrder, nodeType)
+	}
+
+	if len(schema.nodeOrder) == 0 {
+		return nil, fmt.Errorf("schema does not define any nodes")
+	}
+
+	topNode := spec.TopNode
+	if topNode == "" {
+		topNode = "doc"
+	}
+
+	var ok bool
+	schema.TopNode, ok = schema.Nodes[topNode]
+	if !ok {
+		return nil, fmt.Errorf("schema is missing its top node type %s", topNode)
+	}
+
+	if _, ok := schema.Nodes["text"]; !ok {
+		return nil, fmt.Errorf("every schema needs a 'text' type")
+	}
+
+	// content expressions can only be parsed once all node types are known,
+	// as they may reference node types defined later on
+	matches := make(map[string]*ContentMatch)
+	for _, nodeType := range schema.nodeOrder {
+		match, ok := matches[nodeType.Content]
+		if !ok {
+			match, err = parseContentMatch(nodeType.Content, schema.nodeOrder)
+			if err != nil {
+				return nil, fmt.Errorf("invalid content of node %s: %w", nodeType.Name, err)
+			}
+			matches[nodeType.Content] = match
+		}
+		nodeType.ContentMatch = match
+	}
+
+	return &schema, nil
+}
+
+// gatherMarks will resolve the given space separated list of mark names
+// and mark groups to the respective mark types
+func (s *Schema) gatherMarks(list string, specs map[string]markSpec) ([]*MarkType, error) {
+
+	var found []*MarkType
+
+	for _, name := range strings.Fields(list) {
+
+		if markType, ok := s.Marks[name]; ok {
+			found = append(found, markType)
+			continue
+		}
+
+		matched := false
+		for _, markType := range s.markOrder {
+			if name == "_" || containsString(strings.Fields(specs[markType.Name].Group), name) {
+				found = append(found, markType)
+				matched = true
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("unknown mark type: '%s'", name)
+		}
+	}
+
+	return found, nil
+}
+
+// NodeType returns the node type with the given name or nil if the type
+// is not part of the schema
+func (s *Schema) NodeType(name string) *NodeType {
+	return s.Nodes[name]
+}
+
+// MarkType returns the mark type with the given name or nil if the type
+// is not part of the schema
+func (s *Schema) MarkType(name string) *MarkType {
+	return s.Marks[name]
+}
+
+// Includes indicates if the schema defines all node and mark types of the
+// given schema with the same attributes, i.e. if all documents of the
+// given schema can be represented in this schema
+func (s *Schema) Includes(other *Schema) bool {
+
+	for name, nodeType := range other.Nodes {
+		own, ok := s.Nodes[name]
+		if !ok || !sameAttributes(own.Attrs, nodeType.Attrs) {
+			return false
+		}
+	}
+
+	for name, markType := range other.Marks {
+		own, ok := s.Marks[name]
+		if !ok || !sameAttributes(own.Attrs, markType.Attrs) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsText indicates if the node type is the text type
+func (t *NodeType) IsText() bool {
+	return t.Name == "text"
+}
+
+// IsLeaf indicates if the node type may not have any content
+func (t *NodeType) IsLeaf() bool {
+	return t.Content == ""
+}
+
+// IsAtom indicates if the node type should be treated as a single unit
+func (t *NodeType) IsAtom() bool {
+	return t.IsLeaf() || t.Atom
+}
+
+// IsInline indicates if the node type is an inline type
+func (t *NodeType) IsInline() bool {
+	return t.Inline || containsString(t.Groups, "inline")
+}
+
+// AllowsMarkType indicates if the given mark type is allowed in the
+// content of this node type
+func (t *NodeType) AllowsMarkType(markType *MarkType) bool {
+	if t.markSet == nil {
+		return true
+	}
+	for _, allowed := range t.markSet {
+		if allowed == markType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsMarks indicates if all of the given marks are allowed in the
+// content of this node type
+func (t *NodeType) AllowsMarks(marks []*Mark) bool {
+	for _, mark := range marks {
+		if !t.AllowsMarkType(mark.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasRequiredAttrs indicates if the node type has attributes without a
+// default value
+func (t *NodeType) hasRequiredAttrs() bool {
+	for _, attr := range t.Attrs {
+		if !attr.HasDefault {
+			return true
+		}
+	}
+	return false
+}
+
+// compatibleContent indicates if the content of the given node type can be
+// joined with the content of this node type
+func (t *NodeType) compatibleContent(other *NodeType) bool {
+	return t == other || t.ContentMatch.compatible(other.ContentMatch)
+}
+
+// ValidContent indicates if the given fragment is valid content for the
+// node type
+func (t *NodeType) ValidContent(content *Fragment) bool {
+
+	match := t.ContentMatch.MatchFragment(content, 0, content.ChildCount())
+	if match == nil || !match.ValidEnd {
+		return false
+	}
+
+	for i := 0; i < content.ChildCount(); i++ {
+		if !t.AllowsMarks(content.Child(i).Marks) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkContent will verify that the given fragment is valid content for
+// the node type
+func (t *NodeType) checkContent(content *Fragment) error {
+	if !t.ValidContent(content) {
+		return newSchemaError("invalid content for node %s", t.Name)
+	}
+	return nil
+}
+
+// checkAttrs will verify that only attributes defined in the schema are
+// given for the node type
+func (t *NodeType) checkAttrs(given map[string]interface{}) error {
+	return checkAttrs(t.Attrs, given, "node", t.Name)
+}
+
+// computeAttrs will return the attributes of the node type with the
+// defaults applied for all attributes that are not set
+func (t *NodeType) computeAttrs(given map[string]interface{}) (map[string]interface{}, error) {
+	return computeAttrs(t.Attrs, given)
+}
+
+// Create will create a new node of this type. Note that content and marks
+// will be used as is
+func (t *NodeType) Create(attrs map[string]interface{}, content *Fragment,
+	marks []*Mark) (*Node, error) {
+
+	if t.IsText() {
+		return nil, fmt.Errorf("node type text can not be created directly")
+	}
+
+	computed, err := t.computeAttrs(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	if content == nil {
+		content = EmptyFragment
+	}
+
+	return &Node{Type: t, Attrs: computed, Content: content, Marks: normalizeMarks(marks)}, nil
+}
+
+// Excludes indicates if the given mark type is excluded by this mark type
+func (t *MarkType) Excludes(other *MarkType) bool {
+	for _, excluded := range t.excluded {
+		if excluded == other {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAttrs will verify that only attributes defined in the schema are
+// given for the mark type
+func (t *MarkType) checkAttrs(given map[string]interface{}) error {
+	return checkAttrs(t.Attrs, given, "mark", t.Name)
+}
+
+// Create will create a new mark of this type with the given attributes
+func (t *MarkType) Create(attrs map[string]interface{}) (*Mark, error) {
+	computed, err := computeAttrs(t.Attrs, attrs)
+	if err != nil {
+		return nil, err
+	}
+	return &Mark{Type: t, Attrs: computed}, nil
+}
+
+// parseAttributes will parse the attribute specification of a node or mark
+func parseAttributes(specs map[string]json.RawMessage) (map[string]*Attribute, error) {
+
+	attrs := make(map[string]*Attribute, len(specs))
+
+	for name, raw := range specs {
+		var spec map[string]interface{}
+		err := json.Unmarshal(raw, &spec)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse attribute %s: %w", name, err)
+		}
+
+		attr := Attribute{}
+		attr.Default, attr.HasDefault = spec["default"]
+		attrs[name] = &attr
+	}
+
+	return attrs, nil
+}
+
+// computeAttrs will build the attributes according to the given
+// specification and return an error if a required attribute is missing
+func computeAttrs(specs map[string]*Attribute, given map[string]interface{}) (map[string]interface{}, error) {
+
+	attrs := make(map[string]interface{}, len(specs))
+
+	for name, spec := range specs {
+		value, ok := given[name]
+		if !ok {
+			if !spec.HasDefault {
+				return nil, newSchemaError("no value supplied for attribute %s", name)
+			}
+			value = spec.Default
+		}
+		attrs[name] = value
+	}
+
+	return attrs, nil
+}
+
+// sameAttributes indicates if both specifications define the same
+// attributes. Default values are not compared
+func sameAttributes(a, b map[string]*Attribute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAttrs will return an error if an attribute is given that is not
+// part of the specification
+func checkAttrs(specs map[string]*Attribute, given map[string]interface{}, kind, name string) error {
+	for attr := range given {
+		if _, ok := specs[attr]; !ok {
+			return newSchemaError("unsupported attribute %s for %s of type %s", attr, kind, name)
+		}
+	}
+	return nil
+}
+
+// decodeOrderedSpec will decode the given json object or serialized ordered
+// map into a list of specs, preserving the order of definition
+func decodeOrderedSpec(raw json.RawMessage) ([]namedSpec, error) {
+
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	// handle serialized ordered maps from prosemirror
+	var ordered struct {
+		Content []json.RawMessage `json:"content"`
+	}
+	err := json.Unmarshal(raw, &ordered)
+	if err == nil && ordered.Content != nil {
+
+		if len(ordered.Content)%2 != 0 {
+			return nil, fmt.Errorf("ordered map must contain name and spec pairs")
+		}
+
+		specs := make([]namedSpec, 0, len(ordered.Content)/2)
+		for i := 0; i < len(ordered.Content); i += 2 {
+			var name string
+			err := json.Unmarshal(ordered.Content[i], &name)
+			if err != nil {
+				return nil, fmt.Errorf("ordered map contains an invalid name: %w", err)
+			}
+			specs = append(specs, namedSpec{Name: name, Spec: ordered.Content[i+1]})
+		}
+		return specs, nil
+	}
+
+	// use a token based decoder to preserve the order of the object keys
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("spec must be an object")
+	}
+
+	var specs []namedSpec
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		name, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("spec contains an invalid key")
+		}
+
+		var spec json.RawMessage
+		err = decoder.Decode(&spec)
+		if err != nil {
+			return nil, err
+		}
+
+		specs = append(specs, namedSpec{Name: name, Spec: spec})
+	}
+
+	return specs, nil
+}
+
+// containsString indicates if the given list contains the given value
+func containsString(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package model
+
+// Slice represents a piece cut out of a larger document. It stores the
+// content and the depth on each side to which nodes are open
+type Slice struct {
+	Content   *Fragment
+	OpenStart int
+	OpenEnd   int
+}
+
+// SliceJSON is the json representation of a slice
+type SliceJSON struct {
+	Content   []NodeJSON `json:"content,omitempty"`
+	OpenStart int        `json:"openStart,omitempty"`
+	OpenEnd   int        `json:"openEnd,omitempty"`
+}
+
+// EmptySlice is a slice without any content
+var EmptySlice = &Slice{Content: EmptyFragment}
+
+// NewSlice will create a new slice with the given content and open depths
+func NewSlice(content *Fragment, openStart, openEnd int) *Slice {
+	return &Slice{Content: content, OpenStart: openStart, OpenEnd: openEnd}
+}
+
+// SliceFromJSON will create a slice from its json representation
+func SliceFromJSON(schema *Schema, raw *SliceJSON) (*Slice, error) {
+	if raw == nil {
+		return EmptySlice, nil
+	}
+	content, err := FragmentFromJSON(schema, raw.Content)
+	if err != nil {
+		return nil, err
+	}
+	return NewSlice(content, raw.OpenStart, raw.OpenEnd), nil
+}
+
+// Check will verify that the content of the slice conforms to the schema,
+// i.e. that all nodes are allowed in their parent and only carry allowed
+// marks. Note that the content of the nodes is not required to be complete,
+// as nodes may be open or filled when the slice is inserted. Whether the
+// result is valid is verified when the slice is inserted into a document
+func (s *Slice) Check() error {
+	return checkFragment(s.Content, nil)
+}
+
+// checkFragment will check all nodes of the given fragment
+func checkFragment(content *Fragment, parent *Node) error {
+
+	for i := 0; i < content.ChildCount(); i++ {
+		child := content.Child(i)
+
+		if parent != nil {
+			if !parent.Type.ContentMatch.allowsType(child.Type) {
+				return newSchemaError("node %s is not allowed in node %s",
+					child.Type.Name, parent.Type.Name)
+			}
+			if !parent.Type.AllowsMarks(child.Marks) {
+				return newSchemaError("invalid marks on node %s in node %s",
+					child.Type.Name, parent.Type.Name)
+			}
+		}
+
+		err := checkMarkSet(child)
+		if err != nil {
+			return err
+		}
+
+		err = checkFragment(child.Content, child)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ToJSON will convert the slice into its json representation
+func (s *Slice) ToJSON() *SliceJSON {
+	if s.Content.Size() == 0 {
+		return nil
+	}
+	return &SliceJSON{Content: s.Content.ToJSON(), OpenStart: s.OpenStart, OpenEnd: s.OpenEnd}
+}
+
+// Size returns the size the slice will occupy when inserted into a document
+func (s *Slice) Size() int {
+	return s.Content.Size() - s.OpenStart - s.OpenEnd
+}
+
+// Eq indicates if the slice is identical to the given slice
+func (s *Slice) Eq(other *Slice) bool {
+	return s.Content.Eq(other.Content) && s.OpenStart == other.OpenStart &&
+		s.OpenEnd == other.OpenEnd
+}
+
+// InsertAt will insert the given fragment at the given position of the
+// slice. Nil is returned if the fragment does not fit
+func (s *Slice) InsertAt(pos int, fragment *Fragment) *Slice {
+	content := insertInto(s.Content, pos+s.OpenStart, fragment, nil)
+	if content == nil {
+		return nil
+	}
+	return NewSlice(content, s.OpenStart, s.OpenEnd)
+}
+
+// insertInto will insert the given fragment into the content at the given
+// distance
+func insertInto(content *Fragment, dist int, insert *Fragment, parent *Node) *Fragment {
+
+	index, offset, err := content.findIndex(dist, -1)
+	if err != nil {
+		return nil
+	}
+
+	child := content.MaybeChild(index)
+	if offset == dist || child.IsText() {
+		if parent != nil && !parent.canReplace(index, index, insert) {
+			return nil
+		}
+		return content.Cut(0, dist).Append(insert).Append(content.Cut(dist, content.Size()))
+	}
+
+	inner := insertInto(child.Content, dist-offset-1, insert, child)
+	if inner == nil {
+		return nil
+	}
+	return content.ReplaceChild(index, child.Copy(inner))
+}
@@ -8,8 +8,9 @@ import (
 	"google.golang.org/grpc"
 )
 
-// NewImageClient initializes a grpc connection to the process service
-func NewImageClient(address string) (image.ImageClient, error) {
+// NewImageClient initializes a grpc connection to the image service
+// The connection is returned as well to check the state of the connection
+func NewImageClient(address string) (image.ImageClient, *grpc.ClientConn, error) {
 
 	conn, err := grpc.Dial(address,
 		grpc.WithInsecure(),
@@ -19,9 +20,9 @@ func NewImageClient(address string) (image.ImageClient, error) {
 		)))
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	c := image.NewImageClient(conn)
-	return c, nil
+	return c, conn, nil
 }
@@ -9,7 +9,8 @@ import (
 )
 
 // NewProcessClient initializes a grpc connection to the process service
-func NewProcessClient(address string) (process.ProcessClient, error) {
+// The connection is returned as well to check the state of the connection
+func NewProcessClient(address string) (process.ProcessClient, *grpc.ClientConn, error) {
 
 	conn, err := grpc.Dial(address,
 		grpc.WithInsecure(),
@@ -19,9 +20,9 @@ func NewProcessClient(address string) (process.ProcessClient, error) {
 		)))
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	c := process.NewProcessClient(conn)
-	return c, nil
+	return c, conn, nil
 }
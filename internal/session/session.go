@@ -1,34 +1,139 @@
 package session
 
 import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
-type sessionInfo struct {
+// errors returned when verifying session information
+var (
+	ErrNoSession        = errors.New("no session information provided")
+	ErrUnsigned         = errors.New("session information is not signed")
+	ErrInvalidSignature = errors.New("session signature is invalid")
+	ErrExpired          = errors.New("session is expired")
+)
+
+// Info holds the session information of an authenticated user
+type Info struct {
 	UserID      string   `json:"user_id"`
 	Memberships []string `json:"memberships"`
+	Expires     int64    `json:"expires"`
 	Signed      bool     `json:"signed"`
 }
 
-// Parse will parse the given session information
-func Parse(encoded string) (*sessionInfo, error) {
+// Verifier is used to verify the signature of session information passed
+// by the auth service. Sessions can either be signed with a shared hmac
+// secret or with an ed25519 key
+type Verifier struct {
+	secret    []byte
+	publicKey ed25519.PublicKey
+}
+
+// NewVerifier will initialize a new verifier with the given hmac secret
+// and the base64 encoded ed25519 public key. At least one of them must
+// be provided
+func NewVerifier(secret, publicKey string) (*Verifier, error) {
+
+	verifier := Verifier{}
+
+	if secret != "" {
+		verifier.secret = []byte(secret)
+	}
+
+	if publicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode session public key: %w", err)
+		}
+
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("session public key has an invalid size: %d", len(key))
+		}
+
+		verifier.publicKey = ed25519.PublicKey(key)
+	}
+
+	if verifier.secret == nil && verifier.publicKey == nil {
+		return nil, fmt.Errorf("no key to verify session signatures configured")
+	}
+
+	return &verifier, nil
+}
+
+// Parse will verify and parse the given session information. The session
+// must be passed as base64 encoded json, followed by a dot and the base64
+// encoded signature of the encoded json
+func (v *Verifier) Parse(encoded string) (*Info, error) {
 
 	if encoded == "" {
-		return nil, fmt.Errorf("no session information provided")
+		return nil, ErrNoSession
+	}
+
+	separator := strings.LastIndex(encoded, ".")
+	if separator == -1 {
+		return nil, ErrUnsigned
 	}
 
-	jsonSession, err := base64.StdEncoding.DecodeString(encoded)
+	payload := encoded[:separator]
+
+	signature, err := base64.StdEncoding.DecodeString(encoded[separator+1:])
+	if err != nil || len(signature) == 0 {
+		return nil, ErrInvalidSignature
+	}
+
+	if !v.verify([]byte(payload), signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	jsonSession, err := base64.StdEncoding.DecodeString(payload)
 	if err != nil {
 		return nil, err
 	}
 
-	var session sessionInfo
+	var session Info
 	err = json.Unmarshal(jsonSession, &session)
 	if err != nil {
 		return nil, err
 	}
 
+	// sessions without expiration are not accepted
+	if session.Expires == 0 || time.Now().Unix() >= session.Expires {
+		return nil, ErrExpired
+	}
+
+	if session.UserID == "" {
+		return nil, fmt.Errorf("session information contains no user")
+	}
+
+	session.Signed = true
+
 	return &session, nil
 }
+
+// verify will check the signature of the given payload with all
+// configured keys
+func (v *Verifier) verify(payload, signature []byte) bool {
+
+	if v.secret != nil {
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write(payload) // nolint:errcheck
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return true
+		}
+	}
+
+	if v.publicKey != nil && len(signature) == ed25519.SignatureSize {
+		if ed25519.Verify(v.publicKey, payload, signature) {
+			return true
+		}
+	}
+
+	return false
+}
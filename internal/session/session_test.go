@@ -0,0 +1,172 @@
+package session
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// secret of the hmac signatures used by all tests
+const testSecret = "secret"
+
+// key of the ed25519 signatures used by all tests
+var testKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+
+// testPayload will return the base64 encoded json of the given session
+func testPayload(t *testing.T, session Info) string {
+	t.Helper()
+
+	raw, err := json.Marshal(&session)
+	if err != nil {
+		t.Fatalf("could not encode session: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// signHMAC will append the hmac signature of the given payload
+func signHMAC(payload, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload)) // nolint:errcheck
+	return payload + "." + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signEd25519 will append the ed25519 signature of the given payload
+func signEd25519(payload string, key ed25519.PrivateKey) string {
+	signature := ed25519.Sign(key, []byte(payload))
+	return payload + "." + base64.StdEncoding.EncodeToString(signature)
+}
+
+func TestNewVerifier(t *testing.T) {
+
+	publicKey := base64.StdEncoding.EncodeToString(testKey.Public().(ed25519.PublicKey))
+
+	tests := []struct {
+		name      string
+		secret    string
+		publicKey string
+		valid     bool
+	}{
+		{"hmac secret", testSecret, "", true},
+		{"public key", "", publicKey, true},
+		{"hmac secret and public key", testSecret, publicKey, true},
+		{"no key", "", "", false},
+		{"malformed public key", "", "not base64!", false},
+		{"public key of invalid size", "", base64.StdEncoding.EncodeToString([]byte("short")), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := NewVerifier(tt.secret, tt.publicKey)
+			if tt.valid && (err != nil || verifier == nil) {
+				t.Errorf("expected verifier, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("expected error, got verifier")
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+
+	publicKey := base64.StdEncoding.EncodeToString(testKey.Public().(ed25519.PublicKey))
+	_, otherKey, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{2}, 64)))
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	expires := time.Now().Add(time.Hour).Unix()
+	valid := testPayload(t, Info{UserID: "user", Memberships: []string{"group"}, Expires: expires})
+	tampered := testPayload(t, Info{UserID: "admin", Memberships: []string{"group"}, Expires: expires})
+
+	signed := signHMAC(valid, testSecret)
+	signature := signed[len(valid)+1:]
+
+	tests := []struct {
+		name      string
+		secret    string
+		publicKey string
+		encoded   string
+		err       error
+	}{
+		{"valid hmac signature", testSecret, "", signed, nil},
+		{"valid ed25519 signature", "", publicKey, signEd25519(valid, testKey), nil},
+		{"valid signature with both keys", testSecret, publicKey, signEd25519(valid, testKey), nil},
+		{"tampered payload", testSecret, "", tampered + "." + signature, ErrInvalidSignature},
+		{"tampered signature", testSecret, "", valid + "." +
+			base64.StdEncoding.EncodeToString([]byte("signature")), ErrInvalidSignature},
+		{"wrong hmac secret", testSecret, "", signHMAC(valid, "other"), ErrInvalidSignature},
+		{"wrong ed25519 key", "", publicKey, signEd25519(valid, otherKey), ErrInvalidSignature},
+		{"hmac signature without secret", "", publicKey, signed, ErrInvalidSignature},
+		{"ed25519 signature without public key", testSecret, "",
+			signEd25519(valid, testKey), ErrInvalidSignature},
+		{"missing expiration", testSecret, "",
+			signHMAC(testPayload(t, Info{UserID: "user"}), testSecret), ErrExpired},
+		{"expired session", testSecret, "", signHMAC(testPayload(t, Info{UserID: "user",
+			Expires: time.Now().Add(-time.Minute).Unix()}), testSecret), ErrExpired},
+		{"no session", testSecret, "", "", ErrNoSession},
+		{"missing separator", testSecret, "", valid, ErrUnsigned},
+		{"empty signature", testSecret, "", valid + ".", ErrInvalidSignature},
+		{"malformed signature", testSecret, "", valid + ".not base64!", ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			verifier, err := NewVerifier(tt.secret, tt.publicKey)
+			if err != nil {
+				t.Fatalf("could not initialize verifier: %v", err)
+			}
+
+			session, err := verifier.Parse(tt.encoded)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			if tt.err != nil {
+				if session != nil {
+					t.Errorf("expected no session, got %+v", session)
+				}
+				return
+			}
+
+			if session.UserID != "user" || len(session.Memberships) != 1 ||
+				session.Expires != expires || !session.Signed {
+				t.Errorf("unexpected session: %+v", session)
+			}
+		})
+	}
+}
+
+func TestParseInvalidContent(t *testing.T) {
+
+	verifier, err := NewVerifier(testSecret, "")
+	if err != nil {
+		t.Fatalf("could not initialize verifier: %v", err)
+	}
+
+	// the content is only parsed if the signature is valid
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"malformed payload", "not base64!"},
+		{"payload without json", base64.StdEncoding.EncodeToString([]byte("session"))},
+		{"session without user", testPayload(t, Info{Expires: time.Now().Add(time.Hour).Unix()})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := verifier.Parse(signHMAC(tt.payload, testSecret))
+			if err == nil || session != nil {
+				t.Errorf("expected error, got %+v", session)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+)
+
+// AttrStep updates a single attribute of the node at the given position
+type AttrStep struct {
+	Pos   int
+	Attr  string
+	Value interface{}
+}
+
+// attrStepJSON is the json representation of an attribute step
+type attrStepJSON struct {
+	Pos   *int        `json:"pos"`
+	Attr  string      `json:"attr"`
+	Value interface{} `json:"value"`
+}
+
+func decodeAttrStep(schema *model.Schema, raw json.RawMessage) (Step, error) {
+
+	var stp attrStepJSON
+	err := json.Unmarshal(raw, &stp)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse attr step: %w", err)
+	}
+
+	if stp.Pos == nil || stp.Attr == "" {
+		return nil, fmt.Errorf("invalid input for attr step")
+	}
+
+	return &AttrStep{Pos: *stp.Pos, Attr: stp.Attr, Value: stp.Value}, nil
+}
+
+// Apply will set the attribute on the node at the position of the step
+func (s *AttrStep) Apply(doc *model.Node) (*model.Node, error) {
+
+	node := doc.NodeAt(s.Pos)
+	if node == nil {
+		return nil, fail("no node at attribute step's position")
+	}
+
+	if _, ok := node.Type.Attrs[s.Attr]; !ok {
+		return nil, fail("unsupported attribute %s for node %s", s.Attr, node.Type.Name)
+	}
+
+	attrs := make(map[string]interface{}, len(node.Attrs)+1)
+	for name, value := range node.Attrs {
+		attrs[name] = value
+	}
+	attrs[s.Attr] = s.Value
+
+	updated, err := node.Type.Create(attrs, nil, node.Marks)
+	if err != nil {
+		return nil, fail("%s", err.Error())
+	}
+
+	openDepth := 1
+	if node.IsLeaf() {
+		openDepth = 0
+	}
+
+	return fromReplace(doc, s.Pos, s.Pos+1,
+		model.NewSlice(model.FragmentFrom(updated), 0, openDepth))
+}
+
+// GetMap returns an empty step map, as attributes do not change positions
+func (s *AttrStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
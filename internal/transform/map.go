@@ -0,0 +1,135 @@
+package transform
+
+// StepMap describes the deleted and inserted ranges of a step. Each range
+// is stored as start, old size and new size
+type StepMap struct {
+	ranges   []int
+	inverted bool
+}
+
+// EmptyStepMap is a step map that does not change any positions
+var EmptyStepMap = &StepMap{}
+
+// NewStepMap will create a new step map with the given ranges
+func NewStepMap(ranges []int) *StepMap {
+	if len(ranges) == 0 {
+		return EmptyStepMap
+	}
+	return &StepMap{ranges: ranges}
+}
+
+// Map will map the given position through the step map. The association
+// defines on which side the position should be placed if content is
+// inserted at the position (-1 left, 1 right)
+func (m *StepMap) Map(pos int, assoc int) int {
+	result, _ := m.MapResult(pos, assoc)
+	return result
+}
+
+// MapResult will map the given position through the step map and
+// additionally indicate if the content around the position was deleted
+func (m *StepMap) MapResult(pos int, assoc int) (int, bool) {
+
+	diff := 0
+	oldIndex, newIndex := 1, 2
+	if m.inverted {
+		oldIndex, newIndex = 2, 1
+	}
+
+	for i := 0; i < len(m.ranges); i += 3 {
+		start := m.ranges[i]
+		if m.inverted {
+			start -= diff
+		}
+		if start > pos {
+			break
+		}
+
+		oldSize := m.ranges[i+oldIndex]
+		newSize := m.ranges[i+newIndex]
+		end := start + oldSize
+
+		if pos <= end {
+			side := assoc
+			if oldSize > 0 {
+				if pos == start {
+					side = -1
+				} else if pos == end {
+					side = 1
+				}
+			}
+
+			result := start + diff
+			if side >= 0 {
+				result += newSize
+			}
+
+			// the content on the associated side of the position is
+			// considered deleted if the position is inside a replaced range
+			sideEdge := end
+			if assoc < 0 {
+				sideEdge = start
+			}
+
+			return result, oldSize > 0 && pos != sideEdge
+		}
+
+		diff += newSize - oldSize
+	}
+
+	return pos + diff, false
+}
+
+// ForEach will invoke the callback for every changed range with the old
+// and new start and end positions
+func (m *StepMap) ForEach(callback func(oldStart, oldEnd, newStart, newEnd int)) {
+
+	oldIndex, newIndex := 1, 2
+	if m.inverted {
+		oldIndex, newIndex = 2, 1
+	}
+
+	diff := 0
+	for i := 0; i < len(m.ranges); i += 3 {
+		start := m.ranges[i]
+		oldStart := start
+		if m.inverted {
+			oldStart = start - diff
+		}
+		newStart := start + diff
+		if m.inverted {
+			newStart = start
+		}
+
+		oldSize := m.ranges[i+oldIndex]
+		newSize := m.ranges[i+newIndex]
+
+		callback(oldStart, oldStart+oldSize, newStart, newStart+newSize)
+		diff += newSize - oldSize
+	}
+}
+
+// Invert will create a step map that maps positions in the opposite
+// direction
+func (m *StepMap) Invert() *StepMap {
+	return &StepMap{ranges: m.ranges, inverted: !m.inverted}
+}
+
+// Mapping is a sequence of step maps that can be used to map positions
+// through multiple steps
+type Mapping struct {
+	Maps []*StepMap
+}
+
+// AppendMap will add the given step map to the mapping
+func (m *Mapping) AppendMap(stepMap *StepMap) {
+	m.Maps = append(m.Maps, stepMap)
+}
+
+// Map will map the given position through all step maps of the mapping
+func (m *Mapping) Map(pos int, assoc int) int {
+	for _, stepMap := range m.Maps {
+		pos = stepMap.Map(pos, assoc)
+	}
+	return pos
+}
@@ -0,0 +1,137 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+)
+
+// AddMarkStep adds a mark to all inline content between two positions
+type AddMarkStep struct {
+	From int
+	To   int
+	Mark *model.Mark
+}
+
+// RemoveMarkStep removes a mark from all inline content between two
+// positions
+type RemoveMarkStep struct {
+	From int
+	To   int
+	Mark *model.Mark
+}
+
+// markStepJSON is the json representation of mark steps
+type markStepJSON struct {
+	From *int            `json:"from"`
+	To   *int            `json:"to"`
+	Mark *model.MarkJSON `json:"mark"`
+}
+
+// decodeMarkStep will parse the positions and the mark of a mark step
+func decodeMarkStep(schema *model.Schema, raw json.RawMessage) (int, int, *model.Mark, error) {
+
+	var stp markStepJSON
+	err := json.Unmarshal(raw, &stp)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("could not parse mark step: %w", err)
+	}
+
+	if stp.From == nil || stp.To == nil || stp.Mark == nil {
+		return 0, 0, nil, fmt.Errorf("invalid input for mark step")
+	}
+
+	mark, err := model.MarkFromJSON(schema, *stp.Mark)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return *stp.From, *stp.To, mark, nil
+}
+
+func decodeAddMarkStep(schema *model.Schema, raw json.RawMessage) (Step, error) {
+	from, to, mark, err := decodeMarkStep(schema, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &AddMarkStep{From: from, To: to, Mark: mark}, nil
+}
+
+func decodeRemoveMarkStep(schema *model.Schema, raw json.RawMessage) (Step, error) {
+	from, to, mark, err := decodeMarkStep(schema, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoveMarkStep{From: from, To: to, Mark: mark}, nil
+}
+
+// Apply will add the mark to the content of the given document
+func (s *AddMarkStep) Apply(doc *model.Node) (*model.Node, error) {
+	return applyMarkStep(doc, s.From, s.To, func(node, parent *model.Node) *model.Node {
+		if !node.IsAtom() || !parent.Type.AllowsMarkType(s.Mark.Type) {
+			return node
+		}
+		return node.Mark(s.Mark.AddToSet(node.Marks))
+	})
+}
+
+// GetMap returns an empty step map, as marks do not change positions
+func (s *AddMarkStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// Apply will remove the mark from the content of the given document
+func (s *RemoveMarkStep) Apply(doc *model.Node) (*model.Node, error) {
+	return applyMarkStep(doc, s.From, s.To, func(node, parent *model.Node) *model.Node {
+		return node.Mark(s.Mark.RemoveFromSet(node.Marks))
+	})
+}
+
+// GetMap returns an empty step map, as marks do not change positions
+func (s *RemoveMarkStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// applyMarkStep will replace the content between the given positions with
+// a copy where the given function is applied to all inline nodes
+func applyMarkStep(doc *model.Node, from, to int,
+	update func(node, parent *model.Node) *model.Node) (*model.Node, error) {
+
+	oldSlice, err := doc.Slice(from, to, false)
+	if err != nil {
+		return nil, fail("%s", err.Error())
+	}
+
+	rFrom, err := doc.Resolve(from)
+	if err != nil {
+		return nil, fail("%s", err.Error())
+	}
+
+	parent := rFrom.Node(rFrom.SharedDepth(to))
+	content := mapFragment(oldSlice.Content, update, parent)
+	slice := model.NewSlice(content, oldSlice.OpenStart, oldSlice.OpenEnd)
+
+	return fromReplace(doc, from, to, slice)
+}
+
+// mapFragment will apply the given function to all inline nodes of the
+// fragment
+func mapFragment(fragment *model.Fragment, update func(node, parent *model.Node) *model.Node,
+	parent *model.Node) *model.Fragment {
+
+	mapped := make([]*model.Node, 0, fragment.ChildCount())
+
+	for i := 0; i < fragment.ChildCount(); i++ {
+		child := fragment.Child(i)
+		if child.Content.Size() > 0 {
+			child = child.Copy(mapFragment(child.Content, update, child))
+		}
+		if child.IsInline() {
+			child = update(child, parent)
+		}
+		mapped = append(mapped, child)
+	}
+
+	return model.FragmentFromArray(mapped)
+}
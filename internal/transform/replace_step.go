@@ -0,0 +1,178 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+)
+
+// ReplaceStep replaces the content between two positions with a slice
+type ReplaceStep struct {
+	From      int
+	To        int
+	Slice     *model.Slice
+	Structure bool
+}
+
+// ReplaceAroundStep replaces the content between two positions with a
+// slice, while preserving the content in the given gap
+type ReplaceAroundStep struct {
+	From      int
+	To        int
+	GapFrom   int
+	GapTo     int
+	Slice     *model.Slice
+	Insert    int
+	Structure bool
+}
+
+// replaceStepJSON is the json representation of replace steps
+type replaceStepJSON struct {
+	From      *int             `json:"from"`
+	To        *int             `json:"to"`
+	GapFrom   *int             `json:"gapFrom"`
+	GapTo     *int             `json:"gapTo"`
+	Insert    *int             `json:"insert"`
+	Slice     *model.SliceJSON `json:"slice"`
+	Structure bool             `json:"structure"`
+}
+
+func decodeReplaceStep(schema *model.Schema, raw json.RawMessage) (Step, error) {
+
+	var stp replaceStepJSON
+	err := json.Unmarshal(raw, &stp)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse replace step: %w", err)
+	}
+
+	if stp.From == nil || stp.To == nil {
+		return nil, fmt.Errorf("invalid input for replace step")
+	}
+
+	slice, err := model.SliceFromJSON(schema, stp.Slice)
+	if err != nil {
+		return nil, err
+	}
+
+	// reject content that is not valid in the schema, even if the step
+	// would apply to the document
+	err = slice.Check()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplaceStep{From: *stp.From, To: *stp.To, Slice: slice,
+		Structure: stp.Structure}, nil
+}
+
+func decodeReplaceAroundStep(schema *model.Schema, raw json.RawMessage) (Step, error) {
+
+	var stp replaceStepJSON
+	err := json.Unmarshal(raw, &stp)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse replace around step: %w", err)
+	}
+
+	if stp.From == nil || stp.To == nil || stp.GapFrom == nil ||
+		stp.GapTo == nil || stp.Insert == nil {
+		return nil, fmt.Errorf("invalid input for replace around step")
+	}
+
+	slice, err := model.SliceFromJSON(schema, stp.Slice)
+	if err != nil {
+		return nil, err
+	}
+
+	// reject content that is not valid in the schema, even if the step
+	// would apply to the document
+	err = slice.Check()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplaceAroundStep{From: *stp.From, To: *stp.To, GapFrom: *stp.GapFrom,
+		GapTo: *stp.GapTo, Slice: slice, Insert: *stp.Insert,
+		Structure: stp.Structure}, nil
+}
+
+// Apply will apply the replace step to the given document
+func (s *ReplaceStep) Apply(doc *model.Node) (*model.Node, error) {
+	if s.Structure && contentBetween(doc, s.From, s.To) {
+		return nil, fail("structure replace would overwrite content")
+	}
+	return fromReplace(doc, s.From, s.To, s.Slice)
+}
+
+// GetMap returns the step map of the replace step
+func (s *ReplaceStep) GetMap() *StepMap {
+	return NewStepMap([]int{s.From, s.To - s.From, s.Slice.Size()})
+}
+
+// Apply will apply the replace around step to the given document
+func (s *ReplaceAroundStep) Apply(doc *model.Node) (*model.Node, error) {
+
+	if s.From > s.GapFrom || s.GapFrom > s.GapTo || s.GapTo > s.To {
+		return nil, fail("invalid gap %d-%d for range %d-%d", s.GapFrom, s.GapTo, s.From, s.To)
+	}
+
+	if s.Structure && (contentBetween(doc, s.From, s.GapFrom) ||
+		contentBetween(doc, s.GapTo, s.To)) {
+		return nil, fail("structure gap-replace would overwrite content")
+	}
+
+	gap, err := doc.Slice(s.GapFrom, s.GapTo, false)
+	if err != nil {
+		return nil, fail("%s", err.Error())
+	}
+
+	if gap.OpenStart > 0 || gap.OpenEnd > 0 {
+		return nil, fail("gap is not a flat range")
+	}
+
+	inserted := s.Slice.InsertAt(s.Insert, gap.Content)
+	if inserted == nil {
+		return nil, fail("content does not fit in gap")
+	}
+
+	return fromReplace(doc, s.From, s.To, inserted)
+}
+
+// GetMap returns the step map of the replace around step
+func (s *ReplaceAroundStep) GetMap() *StepMap {
+	return NewStepMap([]int{
+		s.From, s.GapFrom - s.From, s.Insert,
+		s.GapTo, s.To - s.GapTo, s.Slice.Size() - s.Insert,
+	})
+}
+
+// contentBetween indicates if there is any content between the given
+// positions that is not just the closing and opening of nodes
+func contentBetween(doc *model.Node, from, to int) bool {
+
+	rFrom, err := doc.Resolve(from)
+	if err != nil {
+		return true
+	}
+
+	dist := to - from
+	depth := rFrom.Depth
+
+	for dist > 0 && depth > 0 && rFrom.IndexAfter(depth) == rFrom.Node(depth).ChildCount() {
+		depth--
+		dist--
+	}
+
+	if dist > 0 {
+		next := rFrom.Node(depth).MaybeChild(rFrom.IndexAfter(depth))
+		for dist > 0 {
+			if next == nil || next.IsLeaf() {
+				return true
+			}
+			next = next.FirstChild()
+			dist--
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,157 @@
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+)
+
+// Step is a single atomic change to a document
+type Step interface {
+	// Apply will apply the step to the given document and return the
+	// resulting document. An error is returned if the step does not apply
+	Apply(doc *model.Node) (*model.Node, error)
+
+	// GetMap returns the step map describing how positions are changed
+	GetMap() *StepMap
+}
+
+// StepDecoder is used to create a step from its json representation
+type StepDecoder func(schema *model.Schema, raw json.RawMessage) (Step, error)
+
+// ErrUnknownStepType is returned when decoding a step type that is not
+// registered
+var ErrUnknownStepType = errors.New("unknown step type")
+
+// stepDecoders holds the decoders for all known step types
+var stepDecoders = map[string]StepDecoder{
+	"replace":       decodeReplaceStep,
+	"replaceAround": decodeReplaceAroundStep,
+	"addMark":       decodeAddMarkStep,
+	"removeMark":    decodeRemoveMarkStep,
+	"attr":          decodeAttrStep,
+}
+
+// RegisterStep will register a decoder for a custom step type. Note that
+// registration is not safe for concurrent use and should happen on init
+func RegisterStep(stepType string, decoder StepDecoder) {
+	stepDecoders[stepType] = decoder
+}
+
+// StepFromJSON will create a step from its json representation
+func StepFromJSON(schema *model.Schema, raw json.RawMessage) (Step, error) {
+
+	var header struct {
+		StepType string `json:"stepType"`
+	}
+	err := json.Unmarshal(raw, &header)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse step: %w", err)
+	}
+
+	return DecodeStep(schema, header.StepType, raw)
+}
+
+// DecodeStep will create a step of the given type from its json
+// representation. The step type is not read from the json again
+func DecodeStep(schema *model.Schema, stepType string, raw json.RawMessage) (Step, error) {
+
+	decoder, ok := stepDecoders[stepType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStepType, stepType)
+	}
+
+	return decoder(schema, raw)
+}
+
+// StepError is returned if a step can not be applied to a document
+type StepError struct {
+	Message string
+}
+
+func (e *StepError) Error() string {
+	return e.Message
+}
+
+// fail will create a new step error with the given message
+func fail(format string, args ...interface{}) error {
+	return &StepError{Message: fmt.Sprintf(format, args...)}
+}
+
+// fromReplace will apply the given replacement to the document and convert
+// replace errors into step errors
+func fromReplace(doc *model.Node, from, to int, slice *model.Slice) (*model.Node, error) {
+	result, err := doc.Replace(from, to, slice)
+	if err != nil {
+		return nil, fail("%s", err.Error())
+	}
+
+	err = checkInserted(result, from, from+slice.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// checkInserted will verify that all nodes that were inserted as a whole
+// between the given positions conform to the schema. The replace does only
+// check the content of the nodes at the boundaries of the slice
+func checkInserted(doc *model.Node, from, to int) error {
+
+	var err error
+	doc.NodesBetween(from, to, func(node *model.Node, pos int, parent *model.Node, index int) bool {
+		if err != nil {
+			return false
+		}
+		if pos >= from && pos+node.NodeSize() <= to {
+			err = node.Check()
+			return false
+		}
+		return true
+	})
+
+	return err
+}
+
+// NoopStep is used for custom steps that do not change the document, such
+// as steps that only carry information for other clients
+type NoopStep struct {
+	StepType string
+}
+
+// Apply will return the document unchanged
+func (s *NoopStep) Apply(doc *model.Node) (*model.Node, error) {
+	return doc, nil
+}
+
+// GetMap returns an empty step map
+func (s *NoopStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// UnknownStep is used for steps of a type that is not registered. The step
+// can not be applied, as its effect on the document is not known
+type UnknownStep struct {
+	StepType string
+}
+
+// Apply will always return an error wrapping ErrUnknownStepType
+func (s *UnknownStep) Apply(doc *model.Node) (*model.Node, error) {
+	return nil, fmt.Errorf("%w: %s", ErrUnknownStepType, s.StepType)
+}
+
+// GetMap returns an empty step map, as the changed positions are not known
+func (s *UnknownStep) GetMap() *StepMap {
+	return EmptyStepMap
+}
+
+// NoopStepDecoder returns a decoder that creates steps which do not change
+// the document
+func NoopStepDecoder(stepType string) StepDecoder {
+	return func(schema *model.Schema, raw json.RawMessage) (Step, error) {
+		return &NoopStep{StepType: stepType}, nil
+	}
+}
@@ -0,0 +1,351 @@
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+)
+
+const testSchema = `{
+	"nodes": {"content": [
+		"doc", {"content": "block+"},
+		"paragraph", {"content": "inline*", "group": "block"},
+		"heading", {"content": "inline*", "group": "block", "attrs": {"level": {"default": 1}}},
+		"blockquote", {"content": "block+", "group": "block"},
+		"text", {"group": "inline"},
+		"image", {"inline": true, "group": "inline", "attrs": {"src": {}}}
+	]},
+	"marks": {"content": [
+		"em", {},
+		"strong", {},
+		"comment", {"attrs": {"id": {}}, "excludes": ""}
+	]}
+}`
+
+// the test document contains a heading at 0-7 and a paragraph at 7-23. the
+// emoji counts as two positions, as positions are based on utf-16
+const testDocument = `{"type":"doc","content":[
+	{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"title"}]},
+	{"type":"paragraph","content":[{"type":"text","text":"hello 😀 world"}]}
+]}`
+
+// testDoc will parse the test schema and the test document
+func testDoc(t *testing.T) (*model.Schema, *model.Node) {
+	t.Helper()
+
+	schema, err := model.ParseSchema(json.RawMessage(testSchema))
+	if err != nil {
+		t.Fatalf("could not parse schema: %v", err)
+	}
+
+	var raw model.NodeJSON
+	err = json.Unmarshal([]byte(testDocument), &raw)
+	if err != nil {
+		t.Fatalf("could not decode document: %v", err)
+	}
+
+	doc, err := model.NodeFromJSON(schema, raw)
+	if err != nil {
+		t.Fatalf("could not parse document: %v", err)
+	}
+
+	return schema, doc
+}
+
+// compactJSON will remove all insignificant whitespace from the given json
+func compactJSON(t *testing.T, value string) string {
+	t.Helper()
+
+	var doc model.NodeJSON
+	err := json.Unmarshal([]byte(value), &doc)
+	if err != nil {
+		t.Fatalf("could not decode expected document: %v", err)
+	}
+
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("could not encode expected document: %v", err)
+	}
+
+	return string(encoded)
+}
+
+func TestApplySteps(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		steps []string
+		want  string
+	}{
+		{
+			name:  "insert text",
+			steps: []string{`{"stepType":"replace","from":8,"to":8,"slice":{"content":[{"type":"text","text":"A"}]}}`},
+			want: `{"type":"doc","content":[
+				{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"title"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"Ahello 😀 world"}]}]}`,
+		},
+		{
+			name:  "insert text after surrogate pair",
+			steps: []string{`{"stepType":"replace","from":16,"to":16,"slice":{"content":[{"type":"text","text":"X"}]}}`},
+			want: `{"type":"doc","content":[
+				{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"title"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"hello 😀X world"}]}]}`,
+		},
+		{
+			name:  "delete text",
+			steps: []string{`{"stepType":"replace","from":8,"to":14}`},
+			want: `{"type":"doc","content":[
+				{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"title"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"😀 world"}]}]}`,
+		},
+		{
+			name:  "join blocks",
+			steps: []string{`{"stepType":"replace","from":5,"to":9}`},
+			want: `{"type":"doc","content":[
+				{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"titlello 😀 world"}]}]}`,
+		},
+		{
+			name:  "split paragraph",
+			steps: []string{`{"stepType":"replace","from":14,"to":14,"slice":{"content":[{"type":"paragraph"},{"type":"paragraph"}],"openStart":1,"openEnd":1}}`},
+			want: `{"type":"doc","content":[
+				{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"title"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"hello "}]},
+				{"type":"paragraph","content":[{"type":"text","text":"😀 world"}]}]}`,
+		},
+		{
+			name:  "wrap in blockquote",
+			steps: []string{`{"stepType":"replaceAround","from":7,"to":23,"gapFrom":7,"gapTo":23,"insert":1,"slice":{"content":[{"type":"blockquote"}]},"structure":true}`},
+			want: `{"type":"doc","content":[
+				{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"title"}]},
+				{"type":"blockquote","content":[{"type":"paragraph","content":[{"type":"text","text":"hello 😀 world"}]}]}]}`,
+		},
+		{
+			name: "wrap and lift again",
+			steps: []string{
+				`{"stepType":"replaceAround","from":7,"to":23,"gapFrom":7,"gapTo":23,"insert":1,"slice":{"content":[{"type":"blockquote"}]},"structure":true}`,
+				`{"stepType":"replaceAround","from":7,"to":25,"gapFrom":8,"gapTo":24,"insert":0,"slice":{"content":[]},"structure":true}`,
+			},
+			want: testDocument,
+		},
+		{
+			name:  "add mark",
+			steps: []string{`{"stepType":"addMark","from":8,"to":13,"mark":{"type":"em"}}`},
+			want: `{"type":"doc","content":[
+				{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"title"}]},
+				{"type":"paragraph","content":[{"type":"text","marks":[{"type":"em"}],"text":"hello"},{"type":"text","text":" 😀 world"}]}]}`,
+		},
+		{
+			name: "add overlapping marks",
+			steps: []string{
+				`{"stepType":"addMark","from":8,"to":13,"mark":{"type":"em"}}`,
+				`{"stepType":"addMark","from":10,"to":16,"mark":{"type":"strong"}}`,
+			},
+			want: `{"type":"doc","content":[
+				{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"title"}]},
+				{"type":"paragraph","content":[
+					{"type":"text","marks":[{"type":"em"}],"text":"he"},
+					{"type":"text","marks":[{"type":"em"},{"type":"strong"}],"text":"llo"},
+					{"type":"text","marks":[{"type":"strong"}],"text":" 😀"},
+					{"type":"text","text":" world"}]}]}`,
+		},
+		{
+			name: "remove mark",
+			steps: []string{
+				`{"stepType":"addMark","from":8,"to":13,"mark":{"type":"comment","attrs":{"id":"c1"}}}`,
+				`{"stepType":"removeMark","from":8,"to":13,"mark":{"type":"comment","attrs":{"id":"c1"}}}`,
+			},
+			want: testDocument,
+		},
+		{
+			name:  "set attribute",
+			steps: []string{`{"stepType":"attr","pos":0,"attr":"level","value":2}`},
+			want: `{"type":"doc","content":[
+				{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"title"}]},
+				{"type":"paragraph","content":[{"type":"text","text":"hello 😀 world"}]}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, doc := testDoc(t)
+
+			for _, raw := range test.steps {
+				step, err := StepFromJSON(schema, json.RawMessage(raw))
+				if err != nil {
+					t.Fatalf("could not decode step %s: %v", raw, err)
+				}
+
+				doc, err = step.Apply(doc)
+				if err != nil {
+					t.Fatalf("could not apply step %s: %v", raw, err)
+				}
+			}
+
+			err := doc.Check()
+			if err != nil {
+				t.Fatalf("resulting document is invalid: %v", err)
+			}
+
+			got, err := json.Marshal(doc.ToJSON())
+			if err != nil {
+				t.Fatalf("could not encode document: %v", err)
+			}
+
+			if want := compactJSON(t, test.want); string(got) != want {
+				t.Errorf("unexpected document\n got: %s\nwant: %s", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyInvalidSteps(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		step   string
+		schema bool // the step must be rejected with a schema error
+	}{
+		{"replace after end", `{"stepType":"replace","from":1,"to":900}`, false},
+		{"replace before start", `{"stepType":"replace","from":-1,"to":2}`, false},
+		{"replace reversed range", `{"stepType":"replace","from":10,"to":8}`, false},
+		{"replace around outside of document", `{"stepType":"replaceAround","from":7,"to":90,"gapFrom":7,"gapTo":90,"insert":1,"slice":{"content":[{"type":"blockquote"}]},"structure":true}`, false},
+		{"replace around overwriting content", `{"stepType":"replaceAround","from":0,"to":23,"gapFrom":7,"gapTo":23,"insert":1,"slice":{"content":[{"type":"blockquote"}]},"structure":true}`, false},
+		{"add mark after end", `{"stepType":"addMark","from":8,"to":900,"mark":{"type":"em"}}`, false},
+		{"remove mark before start", `{"stepType":"removeMark","from":-4,"to":8,"mark":{"type":"em"}}`, false},
+		{"attribute outside of document", `{"stepType":"attr","pos":900,"attr":"level","value":2}`, false},
+		{"unsupported attribute", `{"stepType":"attr","pos":7,"attr":"level","value":2}`, false},
+		{"paragraph inside of paragraph", `{"stepType":"replace","from":8,"to":8,"slice":{"content":[{"type":"paragraph"}]}}`, false},
+		{"text directly in document", `{"stepType":"replace","from":7,"to":7,"slice":{"content":[{"type":"text","text":"a"}]}}`, false},
+		{"empty document", `{"stepType":"replace","from":0,"to":23}`, false},
+		{"replace around with reversed gap", `{"stepType":"replaceAround","from":7,"to":23,"gapFrom":23,"gapTo":7,"insert":1,"slice":{"content":[{"type":"blockquote"}]},"structure":true}`, false},
+		{"empty blockquote", `{"stepType":"replace","from":7,"to":7,"slice":{"content":[{"type":"blockquote"}]}}`, true},
+		{"nested empty blockquote", `{"stepType":"replace","from":7,"to":7,"slice":{"content":[{"type":"blockquote","content":[{"type":"blockquote"}]}]}}`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, doc := testDoc(t)
+
+			step, err := StepFromJSON(schema, json.RawMessage(test.step))
+			if err != nil {
+				t.Fatalf("could not decode step: %v", err)
+			}
+
+			_, err = step.Apply(doc)
+			if err == nil {
+				t.Fatal("step was applied")
+			}
+
+			var stepErr *StepError
+			var schemaErr *model.SchemaError
+			if test.schema && !errors.As(err, &schemaErr) {
+				t.Errorf("expected schema error, got %T: %v", err, err)
+			}
+			if !test.schema && !errors.As(err, &stepErr) {
+				t.Errorf("expected step error, got %T: %v", err, err)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalidSteps(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		step   string
+		schema bool // the step must be rejected with a schema error
+	}{
+		{"unknown node type", `{"stepType":"replace","from":8,"to":8,"slice":{"content":[{"type":"video"}]}}`, true},
+		{"unknown mark type", `{"stepType":"replace","from":8,"to":8,"slice":{"content":[{"type":"text","text":"a","marks":[{"type":"underline"}]}]}}`, true},
+		{"unknown node attribute", `{"stepType":"replace","from":7,"to":7,"slice":{"content":[{"type":"paragraph","attrs":{"align":"left"}}]}}`, true},
+		{"missing required attribute", `{"stepType":"replace","from":8,"to":8,"slice":{"content":[{"type":"image"}]}}`, true},
+		{"duplicate marks", `{"stepType":"replace","from":8,"to":8,"slice":{"content":[{"type":"text","text":"a","marks":[{"type":"em"},{"type":"em"}]}]}}`, true},
+		{"invalid content in slice", `{"stepType":"replace","from":8,"to":8,"slice":{"content":[{"type":"paragraph","content":[{"type":"paragraph"}]}],"openStart":1}}`, true},
+		{"unknown mark in mark step", `{"stepType":"addMark","from":8,"to":10,"mark":{"type":"underline"}}`, true},
+		{"missing positions", `{"stepType":"replace","slice":{"content":[]}}`, false},
+		{"missing gap", `{"stepType":"replaceAround","from":0,"to":7,"insert":0}`, false},
+		{"missing attribute name", `{"stepType":"attr","pos":0,"value":2}`, false},
+		{"invalid json", `{"stepType":"replace","from":`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, _ := testDoc(t)
+
+			_, err := StepFromJSON(schema, json.RawMessage(test.step))
+			if err == nil {
+				t.Fatal("step was decoded")
+			}
+
+			var schemaErr *model.SchemaError
+			if test.schema && !errors.As(err, &schemaErr) {
+				t.Errorf("expected schema error, got %T: %v", err, err)
+			}
+		})
+	}
+}
+
+func TestDecodeUnknownStepType(t *testing.T) {
+
+	schema, _ := testDoc(t)
+
+	_, err := StepFromJSON(schema, json.RawMessage(`{"stepType":"setNodeMarkup","pos":0}`))
+	if !errors.Is(err, ErrUnknownStepType) {
+		t.Errorf("expected unknown step type, got %v", err)
+	}
+}
+
+func TestUnknownStep(t *testing.T) {
+
+	_, doc := testDoc(t)
+
+	step := &UnknownStep{StepType: "addNodeMark"}
+	if _, err := step.Apply(doc); !errors.Is(err, ErrUnknownStepType) {
+		t.Errorf("expected unknown step type, got %v", err)
+	}
+
+	if step.GetMap() != EmptyStepMap {
+		t.Error("expected empty step map")
+	}
+}
+
+func TestStepMap(t *testing.T) {
+
+	insert := `{"stepType":"replace","from":8,"to":8,"slice":{"content":[{"type":"text","text":"abc"}]}}`
+	remove := `{"stepType":"replace","from":8,"to":14}`
+	mark := `{"stepType":"addMark","from":8,"to":13,"mark":{"type":"em"}}`
+
+	tests := []struct {
+		name  string
+		step  string
+		pos   int
+		assoc int
+		want  int
+	}{
+		{"before deletion", remove, 7, 1, 7},
+		{"inside of deletion", remove, 10, 1, 8},
+		{"after deletion", remove, 20, 1, 14},
+		{"insertion with right association", insert, 8, 1, 11},
+		{"insertion with left association", insert, 8, -1, 8},
+		{"after insertion", insert, 16, 1, 19},
+		{"mark steps do not move positions", mark, 10, 1, 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, _ := testDoc(t)
+
+			step, err := StepFromJSON(schema, json.RawMessage(test.step))
+			if err != nil {
+				t.Fatalf("could not decode step: %v", err)
+			}
+
+			got := step.GetMap().Map(test.pos, test.assoc)
+			if got != test.want {
+				t.Errorf("mapped %d to %d, want %d", test.pos, got, test.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"dkfbasel.ch/orca/collaboration/src/internal/environment"
 	"dkfbasel.ch/orca/collaboration/src/internal/rpc"
+	"dkfbasel.ch/orca/collaboration/src/internal/session"
 	"dkfbasel.ch/orca/collaboration/src/repository"
 	"dkfbasel.ch/orca/collaboration/src/websocket"
 	"dkfbasel.ch/orca/pkg/logger"
@@ -26,6 +32,12 @@ func main() {
 	// initialize service struct
 	srv := environment.Services{}
 
+	// verify the signature of all session headers
+	srv.Sessions, err = session.NewVerifier(config.Session.Secret, config.Session.PublicKey)
+	if err != nil {
+		logger.FatalError("startup aborted. could not initialize session verification", err)
+	}
+
 	// establish a connection to the redis instance
 	srv.Redis, err = repository.NewRedisClient(config.Redis.Host, config.Redis.Password)
 	if err != nil {
@@ -33,6 +45,13 @@ func main() {
 	}
 	defer srv.Redis.Close()
 
+	// keep the step log of all documents in redis
+	srv.Steps = repository.NewRedisStepStore(srv.Redis)
+
+	// distribute accepted steps to other instances through redis
+	srv.Broker = repository.NewRedisBroker(srv.Redis)
+	srv.Instance = environment.NewInstanceID()
+
 	// establish a new postgres connection
 	srv.Postgres, err = repository.NewPostgresClient(config.Postgres)
 	if err != nil {
@@ -41,13 +60,13 @@ func main() {
 	defer srv.Postgres.Close()
 
 	// initialize connection to the process service
-	srv.Process, err = rpc.NewProcessClient(config.Process.Address)
+	srv.Process, srv.ProcessConn, err = rpc.NewProcessClient(config.Process.Address)
 	if err != nil {
 		logger.FatalError("startup aborted. could not initialize process service", err)
 	}
 
 	// initialize connection to the image service
-	srv.Image, err = rpc.NewImageClient(config.Image.Address)
+	srv.Image, srv.ImageConn, err = rpc.NewImageClient(config.Image.Address)
 	if err != nil {
 		logger.FatalError("startup aborted. could not initialize image service", err)
 	}
@@ -60,9 +79,36 @@ func main() {
 	defer listener.Close()
 
 	// define the websocket server
-	wsServer := websocket.NewServer(&srv)
+	wsServer := websocket.NewServer(&srv, config.Websocket)
 	defer wsServer.Close() // nolint:errcheck
 
+	// shut down the server gracefully when the service is terminated
+	shutdown := make(chan bool)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		<-signals
+
+		// report the service as not ready before the server stops accepting
+		// connections, to give the load balancer time to route new
+		// connections to other instances
+		logger.Info("draining server")
+		wsServer.Drain()
+		time.Sleep(config.Websocket.ShutdownDelay)
+
+		logger.Info("shutting down server")
+
+		ctx, cancel := context.WithTimeout(context.Background(),
+			config.Websocket.ShutdownTimeout)
+		defer cancel()
+
+		err := wsServer.Shutdown(ctx)
+		if err != nil {
+			logger.DebugError("server was not shut down gracefully", err)
+		}
+		close(shutdown)
+	}()
+
 	logger.Info("starting server")
 
 	// start the websocket server
@@ -71,4 +117,8 @@ func main() {
 		logger.FatalError("failed to listen and serve", err)
 	}
 
+	// wait until all rooms are closed before closing the redis and
+	// postgres connections
+	<-shutdown
+
 }
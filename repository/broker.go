@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"sync"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+)
+
+// Broker is used to distribute messages about a document between multiple
+// instances of the service
+type Broker interface {
+	// Publish will send the given payload to all subscribers of the document
+	Publish(documentID string, payload []byte) error
+
+	// Subscribe will subscribe to all messages published for the document
+	Subscribe(documentID string) (Subscription, error)
+}
+
+// Subscription receives the messages published for a single document
+type Subscription interface {
+	// Messages returns the channel on which published messages are received.
+	// The channel is closed when the subscription is closed
+	Messages() <-chan []byte
+
+	// Close will terminate the subscription
+	Close() error
+}
+
+// brokerChannel returns the name of the channel used for the given document
+func brokerChannel(documentID string) string {
+	return documentID + "-broadcast"
+}
+
+// RedisBroker distributes messages using redis pub/sub
+type RedisBroker struct {
+	Client *redis.Client
+}
+
+// NewRedisBroker will initialize a broker using the given redis client
+func NewRedisBroker(client *redis.Client) *RedisBroker {
+	return &RedisBroker{Client: client}
+}
+
+// Publish will publish the payload on the channel of the document
+func (b *RedisBroker) Publish(documentID string, payload []byte) error {
+	err := b.Client.Publish(brokerChannel(documentID), payload).Err()
+	if err != nil {
+		return errors.Wrap(err, "could not publish message")
+	}
+	return nil
+}
+
+// Subscribe will subscribe to the channel of the document
+func (b *RedisBroker) Subscribe(documentID string) (Subscription, error) {
+
+	pubsub := b.Client.Subscribe(brokerChannel(documentID))
+
+	// wait for the subscription to be confirmed, to make sure that no
+	// messages published after this call are lost
+	_, err := pubsub.Receive()
+	if err != nil {
+		pubsub.Close() // nolint:errcheck
+		return nil, errors.Wrap(err, "could not subscribe to document channel")
+	}
+
+	subscription := &redisSubscription{
+		pubsub:   pubsub,
+		messages: make(chan []byte),
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		defer close(subscription.messages)
+		for message := range pubsub.Channel() {
+			select {
+			case subscription.messages <- []byte(message.Payload):
+			case <-subscription.done:
+				return
+			}
+		}
+	}()
+
+	return subscription, nil
+}
+
+// redisSubscription is a subscription to a redis pub/sub channel
+type redisSubscription struct {
+	pubsub   *redis.PubSub
+	messages chan []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (s *redisSubscription) Messages() <-chan []byte {
+	return s.messages
+}
+
+func (s *redisSubscription) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	return s.pubsub.Close()
+}
+
+// MemoryBroker distributes messages within the running process. It is
+// mainly intended for tests and single instance setups without redis
+type MemoryBroker struct {
+	mutex         sync.Mutex
+	subscriptions map[string]map[*memorySubscription]bool
+}
+
+// NewMemoryBroker will initialize a new in-memory broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subscriptions: make(map[string]map[*memorySubscription]bool)}
+}
+
+// Publish will send the payload to all subscribers of the document
+func (b *MemoryBroker) Publish(documentID string, payload []byte) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for subscription := range b.subscriptions[documentID] {
+		// do not block the publisher on slow subscribers
+		select {
+		case subscription.messages <- payload:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Subscribe will subscribe to all messages of the document
+func (b *MemoryBroker) Subscribe(documentID string) (Subscription, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subscription := &memorySubscription{
+		broker:     b,
+		documentID: documentID,
+		messages:   make(chan []byte, 50),
+	}
+
+	if b.subscriptions[documentID] == nil {
+		b.subscriptions[documentID] = make(map[*memorySubscription]bool)
+	}
+	b.subscriptions[documentID][subscription] = true
+
+	return subscription, nil
+}
+
+// memorySubscription is a subscription of the in-memory broker
+type memorySubscription struct {
+	broker     *MemoryBroker
+	documentID string
+	messages   chan []byte
+	once       sync.Once
+}
+
+func (s *memorySubscription) Messages() <-chan []byte {
+	return s.messages
+}
+
+func (s *memorySubscription) Close() error {
+	s.once.Do(func() {
+		s.broker.mutex.Lock()
+		defer s.broker.mutex.Unlock()
+
+		delete(s.broker.subscriptions[s.documentID], s)
+		if len(s.broker.subscriptions[s.documentID]) == 0 {
+			delete(s.broker.subscriptions, s.documentID)
+		}
+		close(s.messages)
+	})
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"strings"
 
 	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/pkg/database"
 	"github.com/pkg/errors"
 )
 
@@ -12,8 +14,10 @@ import (
 // assign it to the given process
 func (db *DB) SaveComment(comment *domain.CommentAdd) error {
 
-	// do not handle prelimiary comments
-	if strings.HasPrefix(comment.ID, "preliminary") {
+	defer metrics.ObserveRepository("postgres", "save_comment")()
+
+	// preliminary comments must be saved with SavePreliminaryComment
+	if IsPreliminaryComment(comment.ID) {
 		return nil
 	}
 
@@ -29,11 +33,60 @@ func (db *DB) SaveComment(comment *domain.CommentAdd) error {
 	return nil
 }
 
+// IsPreliminaryComment indicates if the given comment id is a preliminary id
+// assigned by a client, that must be resolved into the id of the server
+func IsPreliminaryComment(id string) bool {
+	return strings.HasPrefix(id, "preliminary")
+}
+
+// SavePreliminaryComment will add the given comment with a preliminary id
+// to the database. The comment is stored with a new id assigned by the
+// database, that is returned. The preliminary id is kept to resolve later
+// references to the comment
+func (db *DB) SavePreliminaryComment(comment *domain.CommentAdd) (string, error) {
+
+	defer metrics.ObserveRepository("postgres", "save_preliminary_comment")()
+
+	stmt := `[SQL-STATEMENT]`
+
+	var id string
+	err := db.Session.Get(&id, stmt, comment.ID, comment.AuthorID, comment.Message,
+		comment.Origin, comment.DocumentVersionID)
+	if err != nil {
+		return "", errors.Wrap(err, "could not add preliminary comment")
+	}
+
+	return id, nil
+}
+
+// FetchPreliminaryCommentID will return the id assigned to the comment with
+// the given preliminary id. An empty id is returned if the comment is not
+// known
+func (db *DB) FetchPreliminaryCommentID(documentID, preliminaryID string) (string, error) {
+
+	defer metrics.ObserveRepository("postgres", "fetch_preliminary_comment_id")()
+
+	stmt := `[SQL-STATEMENT]`
+
+	var id string
+	err := db.Session.Get(&id, stmt, documentID, preliminaryID)
+	if err != nil {
+		if database.NotNoResultsError(database.NewError(err)) {
+			return "", errors.Wrap(err, "could not fetch preliminary comment id")
+		}
+		return "", nil
+	}
+
+	return id, nil
+}
+
 // DeleteComment will flag the given comment as archived
 func (db *DB) DeleteComment(comment *domain.CommentDelete) error {
 
-	// do not handle prelimiary comments
-	if strings.HasPrefix(comment.ID, "preliminary") {
+	defer metrics.ObserveRepository("postgres", "delete_comment")()
+
+	// do not handle preliminary comments, that could not be resolved
+	if IsPreliminaryComment(comment.ID) {
 		return nil
 	}
 
@@ -47,11 +100,43 @@ func (db *DB) DeleteComment(comment *domain.CommentDelete) error {
 	return nil
 }
 
+// ErrNotCommentAuthor is returned if a user tries to delete a comment of
+// another user without the necessary permissions
+var ErrNotCommentAuthor = errors.New("users may only delete their own comments")
+
+// DeleteOwnComment will flag the given comment as archived, if it was
+// written by the given user
+func (db *DB) DeleteOwnComment(comment *domain.CommentDelete) error {
+
+	defer metrics.ObserveRepository("postgres", "delete_own_comment")()
+
+	// do not handle preliminary comments, that could not be resolved
+	if IsPreliminaryComment(comment.ID) {
+		return nil
+	}
+
+	stmt := `[SQL-STATEMENT]`
+
+	result, err := db.Session.Exec(stmt, comment.ID, comment.UserID, comment.Timestamp)
+	if err != nil {
+		return errors.Wrap(err, "could not remove process comment")
+	}
+
+	rowCount, _ := result.RowsAffected()
+	if rowCount != 1 {
+		return ErrNotCommentAuthor
+	}
+
+	return nil
+}
+
 // SetCommentDone will flag the given comment as done
 func (db *DB) SetCommentDone(comment *domain.CommentDone) error {
 
-	// do not handle prelimiary comments
-	if strings.HasPrefix(comment.ID, "preliminary") {
+	defer metrics.ObserveRepository("postgres", "set_comment_done")()
+
+	// do not handle preliminary comments, that could not be resolved
+	if IsPreliminaryComment(comment.ID) {
 		return nil
 	}
 
@@ -68,6 +153,8 @@ func (db *DB) SetCommentDone(comment *domain.CommentDone) error {
 // SaveCommentReply will add the given comment reply
 func (db *DB) SaveCommentReply(reply *domain.CommentReply) error {
 
+	defer metrics.ObserveRepository("postgres", "save_comment_reply")()
+
 	stmt := `[SQL-STATEMENT]`
 
 	_, err := db.Session.Exec(stmt, reply.ReplyID, reply.CommentID, reply.AuthorID, reply.Message)
@@ -81,6 +168,8 @@ func (db *DB) SaveCommentReply(reply *domain.CommentReply) error {
 // DeleteCommentReply will flag the given comment as archived
 func (db *DB) DeleteCommentReply(reply *domain.CommentDeleteReply) error {
 
+	defer metrics.ObserveRepository("postgres", "delete_comment_reply")()
+
 	stmt := `[SQL-STATEMENT]`
 
 	result, err := db.Session.Exec(stmt, reply.ReplyID, reply.CommentID,
@@ -96,3 +185,72 @@ func (db *DB) DeleteCommentReply(reply *domain.CommentDeleteReply) error {
 
 	return nil
 }
+
+// FetchComments will return all comments of the given document with their
+// replies, including comments that are done or archived
+func (db *DB) FetchComments(documentID string) ([]domain.CommentThread, error) {
+
+	defer metrics.ObserveRepository("postgres", "fetch_comments")()
+
+	stmt := `[SQL-STATEMENT]`
+
+	comments := []domain.CommentThread{}
+	err := db.Session.Select(&comments, stmt, documentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch comments")
+	}
+
+	stmt = `[SQL-STATEMENT]`
+
+	replies := []domain.CommentThreadReply{}
+	err = db.Session.Select(&replies, stmt, documentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch comment replies")
+	}
+
+	// assign the replies to their comments, replies are ordered by
+	// their creation
+	index := make(map[string]int, len(comments))
+	for i := range comments {
+		comments[i].Replies = []domain.CommentThreadReply{}
+		index[comments[i].ID] = i
+	}
+
+	for _, reply := range replies {
+		i, ok := index[reply.CommentID]
+		if !ok {
+			continue
+		}
+		comments[i].Replies = append(comments[i].Replies, reply)
+	}
+
+	return comments, nil
+}
+
+// FetchComment will return the given comment of the document with all its
+// replies. Nil is returned if the comment does not exist
+func (db *DB) FetchComment(documentID, commentID string) (*domain.CommentThread, error) {
+
+	defer metrics.ObserveRepository("postgres", "fetch_comment")()
+
+	stmt := `[SQL-STATEMENT]`
+
+	var comment domain.CommentThread
+	err := db.Session.Get(&comment, stmt, documentID, commentID)
+	if err != nil {
+		if database.NotNoResultsError(database.NewError(err)) {
+			return nil, errors.Wrap(err, "could not fetch comment")
+		}
+		return nil, nil
+	}
+
+	stmt = `[SQL-STATEMENT]`
+
+	comment.Replies = []domain.CommentThreadReply{}
+	err = db.Session.Select(&comment.Replies, stmt, commentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch comment replies")
+	}
+
+	return &comment, nil
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/pkg/database"
+	"github.com/pkg/errors"
+)
+
+// SaveStepHistory will append the given batch of steps to the persisted
+// step history of the document. The steps are based on the given version,
+// i.e. the first step results in the base version plus one. The batch is
+// written as one multi-row insert
+func (db *DB) SaveStepHistory(documentID string, baseVersion int64,
+	steps []StoredStep) error {
+
+	defer metrics.ObserveRepository("postgres", "save_step_history")()
+
+	if len(steps) == 0 {
+		return nil
+	}
+
+	history := make([]domain.DocumentStep, len(steps))
+	for i, step := range steps {
+		history[i] = domain.DocumentStep{
+			DocumentID: documentID,
+			Version:    baseVersion + int64(i) + 1,
+			Step:       step.Step,
+			ClientID:   step.ClientID,
+			UserID:     step.UserID,
+		}
+	}
+
+	// all steps of the batch are inserted with a single statement to keep
+	// the time spent in the room goroutine short
+	stmt := `[SQL-STATEMENT]`
+
+	_, err := db.Session.NamedExec(stmt, history)
+	if err != nil {
+		return errors.Wrap(err, "could not save step history")
+	}
+
+	return nil
+}
+
+// FetchStepHistory will return the persisted steps to get from the given
+// version to the given version of the document. ErrStepsUnavailable is
+// returned if not all steps are part of the history
+func (db *DB) FetchStepHistory(documentID string, from, to int64) ([]StoredStep, error) {
+
+	defer metrics.ObserveRepository("postgres", "fetch_step_history")()
+
+	if to <= from {
+		return []StoredStep{}, nil
+	}
+
+	stmt := `[SQL-STATEMENT]`
+
+	var history []domain.DocumentStep
+	err := db.Session.Select(&history, stmt, documentID, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch step history")
+	}
+
+	// the history might have gaps, i.e. if it could not be written for
+	// some steps or if the step log was reset
+	if int64(len(history)) != to-from {
+		return nil, ErrStepsUnavailable
+	}
+
+	steps := make([]StoredStep, len(history))
+	for i, step := range history {
+		if step.Version != from+int64(i)+1 {
+			return nil, ErrStepsUnavailable
+		}
+		steps[i] = StoredStep{Step: step.Step, ClientID: step.ClientID, UserID: step.UserID}
+	}
+
+	return steps, nil
+}
+
+// FetchStepHistoryVersion will return the latest version of the document
+// in the persisted step history. ErrNoStepLog is returned if there is no
+// history for the document
+func (db *DB) FetchStepHistoryVersion(documentID string) (int64, error) {
+
+	defer metrics.ObserveRepository("postgres", "fetch_step_history_version")()
+
+	stmt := `[SQL-STATEMENT]`
+
+	var version int64
+	err := db.Session.Get(&version, stmt, documentID)
+	if err != nil {
+		if database.NotNoResultsError(database.NewError(err)) {
+			return 0, errors.Wrap(err, "could not fetch step history version")
+		}
+		return 0, ErrNoStepLog
+	}
+
+	return version, nil
+}
@@ -1,10 +1,15 @@
 package repository
 
-import "dkfbasel.ch/orca/pkg/database"
+import (
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/pkg/database"
+)
 
 // SaveLink will save the given link information
 func (db *DB) SaveLink(blockID, linkType, linkID, url, title string) error {
 
+	defer metrics.ObserveRepository("postgres", "save_link")()
+
 	// avoid duplicate links
 	stmt := `[SQL-STATEMENT]`
 
@@ -28,6 +33,8 @@ func (db *DB) SaveLink(blockID, linkType, linkID, url, title string) error {
 // preserve the links to allow users to access links from restored document versions
 func (db *DB) DeleteLink(blockID, linkID, url string) error {
 
+	defer metrics.ObserveRepository("postgres", "delete_link")()
+
 	stmt := `[SQL-STATEMENT]`
 
 	_, err := db.Session.Exec(stmt, blockID, linkID, url)
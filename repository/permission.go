@@ -4,12 +4,18 @@ import (
 	"errors"
 
 	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
 	"dkfbasel.ch/orca/pkg/database"
 	"dkfbasel.ch/orca/pkg/logger"
+	"github.com/jmoiron/sqlx"
 )
 
-// FetchPermission will check for permissions for the given user on the document
-func (db *DB) FetchPermission(documentVersionId, userID string) (domain.Permission, error) {
+// FetchPermission will check for permissions for the given user on the document.
+// The memberships of the user are taken from the verified session information
+func (db *DB) FetchPermission(documentVersionId, userID string,
+	memberships []string) (domain.Permission, error) {
+
+	defer metrics.ObserveRepository("postgres", "fetch_permission")()
 
 	if documentVersionId == "" || userID == "" {
 		return domain.None, errors.New("missing parameters")
@@ -56,6 +62,20 @@ func (db *DB) FetchPermission(documentVersionId, userID string) (domain.Permissi
 		return domain.Edit, nil
 	}
 
+	// check if one of the memberships of the user grants manage permissions
+	// on the folder of the document
+	if len(memberships) > 0 {
+		var hasGroupPermissions bool
+		stmt, args, err := sqlx.In(`[SQL-STATEMENT]`, documentVersionId, memberships)
+		if err == nil {
+			err = db.Session.Get(&hasGroupPermissions, db.Session.Rebind(stmt), args...)
+		}
+
+		if err == nil && hasGroupPermissions {
+			return domain.Edit, nil
+		}
+	}
+
 	// check if user is a contributor of the given document
 	var isContributor bool
 	stmt = `[SQL-STATEMENT]`
@@ -68,5 +88,31 @@ func (db *DB) FetchPermission(documentVersionId, userID string) (domain.Permissi
 		return domain.Edit, nil
 	}
 
+	// check if user is a reviewer of the given document, reviewers may
+	// only add and resolve comments
+	var isReviewer bool
+	stmt = `[SQL-STATEMENT]`
+	err = db.Session.Get(&isReviewer, stmt, documentVersionId, userID)
+	if database.NotNoResultsError(database.NewError(err)) {
+		return domain.None, err
+	}
+
+	if isReviewer {
+		return domain.Comment, nil
+	}
+
+	// check if user is an approver or auditor of the given document, who
+	// may only follow the changes of the document
+	var isViewer bool
+	stmt = `[SQL-STATEMENT]`
+	err = db.Session.Get(&isViewer, stmt, documentVersionId, userID)
+	if database.NotNoResultsError(database.NewError(err)) {
+		return domain.None, err
+	}
+
+	if isViewer {
+		return domain.View, nil
+	}
+
 	return domain.None, nil
 }
@@ -1,9 +1,12 @@
 package repository
 
 import (
+	"context"
+
 	"dkfbasel.ch/orca/pkg/database"
 	"dkfbasel.ch/orca/pkg/logger"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 )
 
 // DB will implement the method to satisfy the sampleDBInterface
@@ -17,6 +20,7 @@ func NewPostgresClient(config database.Config) (*DB, error) {
 	session, err := database.NewSession(config)
 	if err != nil {
 		logger.Info("could not open database session")
+		return nil, errors.Wrap(err, "could not open database session")
 	}
 
 	db := DB{}
@@ -25,6 +29,11 @@ func NewPostgresClient(config database.Config) (*DB, error) {
 	return &db, nil
 }
 
+// Ping will check if the database is reachable
+func (db *DB) Ping(ctx context.Context) error {
+	return db.Session.PingContext(ctx)
+}
+
 // Close will terminate the database connections
 func (db *DB) Close() error {
 	return db.Session.Close()
@@ -1,7 +1,13 @@
 package repository
 
 import (
+	"context"
+	"strings"
+	"time"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
 	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
 )
 
 // NewRedisClient will initialize an connection to the redis server
@@ -13,6 +19,50 @@ func NewRedisClient(host, password string) (*redis.Client, error) {
 		DB:       0, // use default database
 	})
 
+	// measure the latency of all redis commands
+	client.AddHook(metricsHook{})
+
+	// make sure that the redis server is reachable
+	err := client.Ping().Err()
+	if err != nil {
+		client.Close() // nolint:errcheck
+		return nil, errors.Wrap(err, "could not reach redis server")
+	}
+
 	return client, nil
 
 }
+
+// context key to keep the start time of redis commands
+type metricsStartKey struct{}
+
+// metricsHook will observe the latency of all redis commands and pipelines
+type metricsHook struct{}
+
+func (metricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, metricsStartKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeRedis(ctx, cmd.Name())
+	return nil
+}
+
+func (metricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, metricsStartKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	observeRedis(ctx, "pipeline")
+	return nil
+}
+
+// observeRedis will record the time since the start of the redis command
+func observeRedis(ctx context.Context, operation string) {
+	start, ok := ctx.Value(metricsStartKey{}).(time.Time)
+	if !ok {
+		return
+	}
+	metrics.Repository.WithLabelValues("redis", strings.ToLower(operation)).
+		Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/pkg/database"
+	"github.com/pkg/errors"
+)
+
+// SaveSnapshot will persist the given document content with its version
+func (db *DB) SaveSnapshot(snapshot *domain.DocumentSnapshot) error {
+
+	defer metrics.ObserveRepository("postgres", "save_snapshot")()
+
+	stmt := `[SQL-STATEMENT]`
+
+	_, err := db.Session.Exec(stmt, snapshot.DocumentID, snapshot.Version,
+		[]byte(snapshot.Content))
+	if err != nil {
+		return errors.Wrap(err, "could not save document snapshot")
+	}
+
+	return nil
+}
+
+// FetchSnapshot will return the most recent snapshot of the given document.
+// Nil is returned if no snapshot exists yet
+func (db *DB) FetchSnapshot(documentID string) (*domain.DocumentSnapshot, error) {
+
+	defer metrics.ObserveRepository("postgres", "fetch_snapshot")()
+
+	stmt := `[SQL-STATEMENT]`
+
+	var snapshot domain.DocumentSnapshot
+	err := db.Session.Get(&snapshot, stmt, documentID)
+	if err != nil {
+		if database.NotNoResultsError(database.NewError(err)) {
+			return nil, errors.Wrap(err, "could not fetch document snapshot")
+		}
+		return nil, nil
+	}
+
+	return &snapshot, nil
+}
+
+// FetchSnapshotAt will return the most recent snapshot of the given document
+// that is not newer than the given version. Nil is returned if no such
+// snapshot exists
+func (db *DB) FetchSnapshotAt(documentID string, version int64) (*domain.DocumentSnapshot, error) {
+
+	defer metrics.ObserveRepository("postgres", "fetch_snapshot_at")()
+
+	stmt := `[SQL-STATEMENT]`
+
+	var snapshot domain.DocumentSnapshot
+	err := db.Session.Get(&snapshot, stmt, documentID, version)
+	if err != nil {
+		if database.NotNoResultsError(database.NewError(err)) {
+			return nil, errors.Wrap(err, "could not fetch document snapshot")
+		}
+		return nil, nil
+	}
+
+	return &snapshot, nil
+}
+
+// ListSnapshots will return the versions and creation times of all snapshots
+// of the given document, starting with the most recent one. The content of
+// the snapshots is not fetched
+func (db *DB) ListSnapshots(documentID string) ([]domain.DocumentSnapshot, error) {
+
+	defer metrics.ObserveRepository("postgres", "list_snapshots")()
+
+	stmt := `[SQL-STATEMENT]`
+
+	snapshots := []domain.DocumentSnapshot{}
+	err := db.Session.Select(&snapshots, stmt, documentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list document snapshots")
+	}
+
+	return snapshots, nil
+}
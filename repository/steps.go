@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrNoStepLog is returned if no step log exists for the given document
+var ErrNoStepLog = errors.New("no step log for document")
+
+// ErrStepsUnavailable is returned if the requested steps are not part of
+// the step log anymore
+var ErrStepsUnavailable = errors.New("steps not available in step log")
+
+// ErrVersionConflict is returned if steps are appended to a step log whose
+// version does not correspond to the base version of the steps
+var ErrVersionConflict = errors.New("version conflict in step log")
+
+// StoredStep is a single step of the step log with information about the
+// client and the user that created it
+type StoredStep struct {
+	Step     json.RawMessage
+	ClientID int
+	UserID   string
+}
+
+// StepStore is used to keep the step history of documents that are
+// currently edited. The version of a document is the starting version
+// of the step log plus the number of steps in the log
+type StepStore interface {
+	// StartingVersion returns the document version at which the step log
+	// of the given document starts
+	StartingVersion(documentID string) (int64, error)
+
+	// Version returns the current version of the given document, i.e. the
+	// starting version plus the number of steps in the log
+	Version(documentID string) (int64, error)
+
+	// Append will atomically add the given batch of steps to the step log
+	// and refresh the expiration of the log. The steps are only added if
+	// the current version of the log matches the given base version.
+	// The new version of the document is returned
+	Append(documentID string, baseVersion int64, steps []StoredStep,
+		expiration time.Duration) (int64, error)
+
+	// Range returns all steps from the given version up to the current
+	// version of the document
+	Range(documentID string, from int64) ([]StoredStep, error)
+
+	// Reset will remove all steps and start a new step log at the given
+	// version
+	Reset(documentID string, version int64) error
+
+	// Expire will remove the step log after the given duration of
+	// inactivity
+	Expire(documentID string, expiration time.Duration) error
+}
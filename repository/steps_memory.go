@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStepStore keeps the step log of documents in memory. It is mainly
+// intended for tests and single instance setups without redis
+type MemoryStepStore struct {
+	mutex sync.Mutex
+	logs  map[string]*memoryStepLog
+}
+
+// memoryStepLog holds the step log of a single document
+type memoryStepLog struct {
+	startingVersion int64
+	steps           []StoredStep
+	expires         time.Time
+}
+
+// NewMemoryStepStore will initialize an empty in-memory step store
+func NewMemoryStepStore() *MemoryStepStore {
+	return &MemoryStepStore{logs: make(map[string]*memoryStepLog)}
+}
+
+// log returns the step log of the given document. Expired logs are removed.
+// Note that the mutex must be held by the caller
+func (s *MemoryStepStore) log(documentID string) *memoryStepLog {
+
+	log, ok := s.logs[documentID]
+	if !ok {
+		return nil
+	}
+
+	if !log.expires.IsZero() && time.Now().After(log.expires) {
+		delete(s.logs, documentID)
+		return nil
+	}
+
+	return log
+}
+
+// StartingVersion returns the version at which the step log starts
+func (s *MemoryStepStore) StartingVersion(documentID string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	log := s.log(documentID)
+	if log == nil {
+		return 0, ErrNoStepLog
+	}
+
+	return log.startingVersion, nil
+}
+
+// Version returns the current version of the document
+func (s *MemoryStepStore) Version(documentID string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	log := s.log(documentID)
+	if log == nil {
+		return 0, ErrNoStepLog
+	}
+
+	return log.startingVersion + int64(len(log.steps)), nil
+}
+
+// Append will add the given steps to the step log if the version of the
+// log matches the given base version
+func (s *MemoryStepStore) Append(documentID string, baseVersion int64, steps []StoredStep,
+	expiration time.Duration) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	log := s.log(documentID)
+	if log == nil {
+		return 0, ErrNoStepLog
+	}
+
+	if log.startingVersion+int64(len(log.steps)) != baseVersion {
+		return 0, ErrVersionConflict
+	}
+
+	log.steps = append(log.steps, steps...)
+	log.expires = time.Now().Add(expiration)
+
+	return log.startingVersion + int64(len(log.steps)), nil
+}
+
+// Range returns all steps from the given version onwards
+func (s *MemoryStepStore) Range(documentID string, from int64) ([]StoredStep, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	log := s.log(documentID)
+	if log == nil {
+		return nil, ErrNoStepLog
+	}
+
+	start := from - log.startingVersion
+	if start < 0 {
+		return nil, ErrStepsUnavailable
+	}
+
+	if start >= int64(len(log.steps)) {
+		return []StoredStep{}, nil
+	}
+
+	result := make([]StoredStep, len(log.steps)-int(start))
+	copy(result, log.steps[start:])
+	return result, nil
+}
+
+// Reset will remove all steps and set the starting version
+func (s *MemoryStepStore) Reset(documentID string, version int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.logs[documentID] = &memoryStepLog{startingVersion: version}
+	return nil
+}
+
+// Expire will remove the step log after the given duration
+func (s *MemoryStepStore) Expire(documentID string, expiration time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	log := s.log(documentID)
+	if log == nil {
+		return nil
+	}
+
+	log.expires = time.Now().Add(expiration)
+	return nil
+}
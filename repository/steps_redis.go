@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+)
+
+// RedisStepStore keeps the step log of documents in three parallel redis
+// lists (steps, clientids and userids) and the starting version in a
+// separate key
+type RedisStepStore struct {
+	Client *redis.Client
+}
+
+// NewRedisStepStore will initialize a step store using the given redis client
+func NewRedisStepStore(client *redis.Client) *RedisStepStore {
+	return &RedisStepStore{Client: client}
+}
+
+// keys used to store the step log of a document
+func stepsKey(documentID string) string           { return documentID + "-steps" }
+func clientIDsKey(documentID string) string       { return documentID + "-clientids" }
+func userIDsKey(documentID string) string         { return documentID + "-userids" }
+func startingVersionKey(documentID string) string { return documentID + "-starting-version" }
+
+// StartingVersion returns the version at which the step log starts
+func (s *RedisStepStore) StartingVersion(documentID string) (int64, error) {
+
+	version, err := s.Client.Get(startingVersionKey(documentID)).Int64()
+	if err == redis.Nil {
+		return 0, ErrNoStepLog
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "could not fetch starting version")
+	}
+
+	return version, nil
+}
+
+// Version returns the current version of the document
+func (s *RedisStepStore) Version(documentID string) (int64, error) {
+
+	startingVersion, err := s.StartingVersion(documentID)
+	if err != nil {
+		return 0, err
+	}
+
+	stepCount, err := s.Client.LLen(stepsKey(documentID)).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not fetch step count")
+	}
+
+	return startingVersion + stepCount, nil
+}
+
+// appendScript will append a batch of steps to the step log if the version
+// of the log corresponds to the base version of the batch. All keys of the
+// step log are expired after the given number of milliseconds.
+// KEYS: steps, clientids, userids, starting-version
+// ARGV: base version, expiration, step count, steps..., clientids..., userids...
+// Returns {status, version} with status 1 on success, 0 on a version
+// conflict and -1 if the step log does not exist
+var appendScript = redis.NewScript(`
+local start = redis.call('GET', KEYS[4])
+if not start then
+	return {-1, 0}
+end
+
+local version = tonumber(start) + redis.call('LLEN', KEYS[1])
+if version ~= tonumber(ARGV[1]) then
+	return {0, version}
+end
+
+local count = tonumber(ARGV[3])
+for i = 1, count do
+	redis.call('RPUSH', KEYS[1], ARGV[3 + i])
+	redis.call('RPUSH', KEYS[2], ARGV[3 + count + i])
+	redis.call('RPUSH', KEYS[3], ARGV[3 + 2 * count + i])
+end
+
+for i = 1, 4 do
+	redis.call('PEXPIRE', KEYS[i], ARGV[2])
+end
+
+return {1, version + count}
+`)
+
+// Append will atomically add the given steps to the step log if the version
+// of the log matches the given base version
+func (s *RedisStepStore) Append(documentID string, baseVersion int64, steps []StoredStep,
+	expiration time.Duration) (int64, error) {
+
+	keys := []string{
+		stepsKey(documentID),
+		clientIDsKey(documentID),
+		userIDsKey(documentID),
+		startingVersionKey(documentID),
+	}
+
+	args := make([]interface{}, 0, 3+3*len(steps))
+	args = append(args, baseVersion, expiration.Milliseconds(), len(steps))
+
+	for i := range steps {
+		args = append(args, string(steps[i].Step))
+	}
+	for i := range steps {
+		args = append(args, steps[i].ClientID)
+	}
+	for i := range steps {
+		args = append(args, steps[i].UserID)
+	}
+
+	result, err := appendScript.Run(s.Client, keys, args...).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not store steps")
+	}
+
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return 0, errors.New("unexpected result when storing steps")
+	}
+
+	status, _ := values[0].(int64)
+	version, _ := values[1].(int64)
+
+	switch status {
+	case 1:
+		return version, nil
+	case 0:
+		return 0, ErrVersionConflict
+	default:
+		return 0, ErrNoStepLog
+	}
+}
+
+// Range returns all steps from the given version onwards
+func (s *RedisStepStore) Range(documentID string, from int64) ([]StoredStep, error) {
+
+	startingVersion, err := s.StartingVersion(documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	start := from - startingVersion
+	if start < 0 {
+		return nil, ErrStepsUnavailable
+	}
+
+	var stepsCmd, clientIDsCmd, userIDsCmd *redis.StringSliceCmd
+
+	// read all lists in a transaction, so that a concurrent append is
+	// either seen completely or not at all
+	_, err = s.Client.TxPipelined(func(pipe redis.Pipeliner) error {
+		stepsCmd = pipe.LRange(stepsKey(documentID), start, -1)
+		clientIDsCmd = pipe.LRange(clientIDsKey(documentID), start, -1)
+		userIDsCmd = pipe.LRange(userIDsKey(documentID), start, -1)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch steps")
+	}
+
+	steps := stepsCmd.Val()
+	clientIDs := clientIDsCmd.Val()
+	userIDs := userIDsCmd.Val()
+
+	if len(clientIDs) != len(steps) || len(userIDs) != len(steps) {
+		return nil, errors.New("step log is inconsistent")
+	}
+
+	result := make([]StoredStep, len(steps))
+	for i := range steps {
+		clientID, err := strconv.Atoi(clientIDs[i])
+		if err != nil {
+			return nil, errors.Wrap(err, "could not convert client id to integer")
+		}
+
+		result[i] = StoredStep{
+			Step:     []byte(steps[i]),
+			ClientID: clientID,
+			UserID:   userIDs[i],
+		}
+	}
+
+	return result, nil
+}
+
+// Reset will remove all steps and set the starting version
+func (s *RedisStepStore) Reset(documentID string, version int64) error {
+
+	_, err := s.Client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(startingVersionKey(documentID), version, 0)
+		pipe.Del(stepsKey(documentID), clientIDsKey(documentID), userIDsKey(documentID))
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not reset step log")
+	}
+
+	return nil
+}
+
+// Expire will set the expiration time on all keys of the step log
+func (s *RedisStepStore) Expire(documentID string, expiration time.Duration) error {
+
+	_, err := s.Client.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.Expire(stepsKey(documentID), expiration)
+		pipe.Expire(clientIDsKey(documentID), expiration)
+		pipe.Expire(userIDsKey(documentID), expiration)
+		pipe.Expire(startingVersionKey(documentID), expiration)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not set expiration time on step log")
+	}
+
+	return nil
+}
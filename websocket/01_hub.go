@@ -1,43 +1,112 @@
 package websocket
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
 	"dkfbasel.ch/orca/pkg/logger"
+	"nhooyr.io/websocket"
 )
 
 // Hub for socket handling
 type WebsocketHub struct {
-	Srv        *environment.Services     // external services
-	Rooms      map[string]*WebsocketRoom // room for documents
-	Register   chan *Registration        // register a new client
-	Unregister chan *Registration        // deregister a client
+	Srv        *environment.Services       // external services
+	Config     environment.WebsocketConfig // server configuration
+	Overflow   OverflowPolicy              // policy for full client queues
+	Rooms      map[string]*WebsocketRoom   // room for documents
+	Register   chan *Registration          // register a new client
+	Unregister chan *Registration          // deregister a client
+	Shutdown   chan *ShutdownRequest       // close all rooms
+	closed     bool                        // do not accept new clients
+
+	// all connected clients, including clients that did not join a room
+	// yet. the clients are added and removed by the connection handlers
+	// and must therefore be accessed with the mutex
+	clients      map[*WebsocketClient]bool
+	clientsMutex sync.Mutex
+	stopped      bool // do not accept new connections
+
+	// wait until the messages of all clients were written
+	connections sync.WaitGroup
+}
+
+// ShutdownRequest is used to close all rooms of the hub. Done is closed
+// when all rooms are closed or the context is done
+type ShutdownRequest struct {
+	Context context.Context
+	Done    chan bool
 }
 
 // newHub will create a new websocket hub to handle various document rooms
 // as well as registration and unregistration of clients
-func newHub(srv *environment.Services) *WebsocketHub {
+func newHub(srv *environment.Services, config environment.WebsocketConfig) *WebsocketHub {
+
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+
+	// make sure that all rooms use a known schema compatibility rule
+	config.SchemaCompatibility = string(parseSchemaCompatibility(config.SchemaCompatibility))
 
 	hub := WebsocketHub{}
+	hub.Config = config
+	hub.Overflow = parseOverflowPolicy(config.OverflowPolicy)
 	hub.Rooms = make(map[string]*WebsocketRoom)
+	hub.clients = make(map[*WebsocketClient]bool)
 	hub.Register = make(chan *Registration)
 	hub.Unregister = make(chan *Registration)
+	hub.Shutdown = make(chan *ShutdownRequest)
 	hub.Srv = srv
 
+	// check regularly for rooms that were idle for too long
+	var idleTicker <-chan time.Time
+	if config.RoomIdleTimeout > 0 {
+		ticker := time.NewTicker(config.RoomIdleTimeout / 4)
+		idleTicker = ticker.C
+	}
+
 	go func() {
 		for {
 			select {
 			// register a client in the hub
 			case registration := <-hub.Register:
 
+				// do not accept any clients when the server is shutting down
+				if hub.closed {
+					err := registration.Client.Conn.Close(websocket.StatusGoingAway, "server shutdown")
+					if err != nil {
+						logger.Debug("could not close connection", logger.Err(err))
+					}
+					close(registration.Done)
+					continue
+				}
+
 				room, ok := hub.Rooms[registration.Client.DocumentID]
 				if !ok {
-					room = newWebsocketRoom(srv, registration.Client.DocumentID)
+					room = newWebsocketRoom(srv, hub.Config,
+						registration.Client.DocumentID)
 					hub.Rooms[registration.Client.DocumentID] = room
+					metrics.Rooms.Inc()
 				}
 
-				room.Register <- registration
+				// the client is not assigned to a room if the room handler
+				// returned unexpectedly
+				select {
+				case room.Register <- registration:
+				case <-room.Done:
+					hub.removeRoom(registration.Client.DocumentID)
+					close(registration.Done)
+				}
 
 			case registration := <-hub.Unregister:
+				// ignore clients that never joined a room
+				if registration.Client.DocumentID == "" {
+					continue
+				}
+
 				room, ok := hub.Rooms[registration.Client.DocumentID]
 				if !ok {
 					continue
@@ -47,8 +116,13 @@ func newHub(srv *environment.Services) *WebsocketHub {
 				// whole room at a later point
 				documentID := registration.Client.DocumentID
 
-				room.Unregister <- registration
-				<-registration.Done
+				select {
+				case room.Unregister <- registration:
+					<-registration.Done
+				case <-room.Done:
+					hub.removeRoom(documentID)
+					continue
+				}
 
 				// note: no registration can occur until this case is
 				// finished, therefore we do not need to lock our rooms
@@ -56,8 +130,43 @@ func newHub(srv *environment.Services) *WebsocketHub {
 				// remove the room if there are no more clients
 				if len(room.Clients) == 0 {
 					logger.Debug("remove room", logger.String("room", documentID))
-					delete(hub.Rooms, documentID)
+					hub.removeRoom(documentID)
+
+					// stop the room handler
+					close(room.Stop)
+				}
+
+			case request := <-hub.Shutdown:
+				hub.closed = true
+
+				// inform all rooms at once and wait for them to finish
+				rooms := make(map[string]chan bool, len(hub.Rooms))
+				for documentID, room := range hub.Rooms {
+					done := make(chan bool)
+					room.Shutdown <- done
+					rooms[documentID] = done
+				}
+
+				for documentID, done := range rooms {
+					select {
+					case <-done:
+					case <-request.Context.Done():
+						logger.Debug("room was not closed in time",
+							logger.String("room", documentID))
+					}
+
+					hub.removeRoom(documentID)
 				}
+
+				// inform all clients, including the clients that did not
+				// join a room yet, and close their connections
+				hub.closeClients()
+
+				close(request.Done)
+
+			// suspend all rooms that were idle for too long
+			case <-idleTicker:
+				hub.suspendIdleRooms()
 			}
 		}
 	}()
@@ -65,3 +174,70 @@ func newHub(srv *environment.Services) *WebsocketHub {
 	return &hub
 
 }
+
+// removeRoom will remove the room with the given id from the hub. note that
+// the room handler will close the subscription of the room when it returns
+func (hub *WebsocketHub) removeRoom(documentID string) {
+
+	if _, ok := hub.Rooms[documentID]; !ok {
+		return
+	}
+
+	delete(hub.Rooms, documentID)
+	metrics.Rooms.Dec()
+}
+
+// connect will add the client to the connected clients of the hub. False is
+// returned if the server is shutting down
+func (hub *WebsocketHub) connect(client *WebsocketClient) bool {
+	hub.clientsMutex.Lock()
+	defer hub.clientsMutex.Unlock()
+
+	if hub.stopped {
+		return false
+	}
+
+	hub.clients[client] = true
+	hub.connections.Add(1)
+	return true
+}
+
+// disconnect will remove the client from the connected clients of the hub,
+// after all messages were written to the client
+func (hub *WebsocketHub) disconnect(client *WebsocketClient) {
+	hub.clientsMutex.Lock()
+	defer hub.clientsMutex.Unlock()
+
+	delete(hub.clients, client)
+	hub.connections.Done()
+}
+
+// closeClients will inform all connected clients about the shutdown and
+// close their connections after all queued messages were sent
+func (hub *WebsocketHub) closeClients() {
+	hub.clientsMutex.Lock()
+	defer hub.clientsMutex.Unlock()
+
+	hub.stopped = true
+
+	for client := range hub.clients {
+		sendServerShutdown(client)
+	}
+}
+
+// suspendIdleRooms will inform all rooms that were idle for longer than
+// the configured timeout to drop their state
+func (hub *WebsocketHub) suspendIdleRooms() {
+	for documentID, room := range hub.Rooms {
+		if room.idle() < hub.Config.RoomIdleTimeout {
+			continue
+		}
+
+		select {
+		case room.Suspend <- true:
+			logger.Debug("suspend idle room", logger.String("room", documentID))
+		default:
+			// the room was already informed
+		}
+	}
+}
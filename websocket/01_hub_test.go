@@ -0,0 +1,262 @@
+package websocket
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/session"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"go.uber.org/goleak"
+	"nhooyr.io/websocket"
+)
+
+// secret used to sign the session information of the tests
+const testSessionSecret = "secret"
+
+// the hub goroutine is running for the whole lifetime of the process
+var ignoreHub = goleak.IgnoreTopFunction(
+	"dkfbasel.ch/orca/collaboration/src/websocket.newHub.func1")
+
+// newTestServer will start a websocket server using in memory services.
+// Permission queries are granted for the given values
+func newTestServer(t *testing.T, granted ...string) (*Server, string) {
+	t.Helper()
+
+	srv := newTestServices(t, repository.NewMemoryStepStore(),
+		repository.NewMemoryBroker(), "a", granted...)
+
+	sessions, err := session.NewVerifier(testSessionSecret, "")
+	if err != nil {
+		t.Fatalf("could not initialize session verifier: %v", err)
+	}
+	srv.Sessions = sessions
+
+	server := NewServer(srv, environment.WebsocketConfig{
+		ShutdownTimeout: time.Second * 5,
+	})
+
+	httpServer := httptest.NewServer(server.Handler)
+	t.Cleanup(httpServer.Close)
+
+	return server, "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+// dialTestServer will open a websocket connection for the given user
+func dialTestServer(t *testing.T, url, userID string) *websocket.Conn {
+	t.Helper()
+
+	info, err := json.Marshal(&session.Info{
+		UserID:  userID,
+		Expires: time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("could not encode session: %v", err)
+	}
+
+	payload := base64.StdEncoding.EncodeToString(info)
+	mac := hmac.New(sha256.New, []byte(testSessionSecret))
+	mac.Write([]byte(payload)) // nolint:errcheck
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Session": []string{payload + "." + signature}},
+	})
+	if err != nil {
+		t.Fatalf("could not connect to server: %v", err)
+	}
+
+	return conn
+}
+
+// initTestConnection will initialize the test document on the given
+// connection and wait for the response of the given type. Clients joining
+// a room are informed about the presence of all users in the room
+func initTestConnection(t *testing.T, conn *websocket.Conn,
+	messageType MessageType) json.RawMessage {
+	t.Helper()
+
+	payload, err := json.Marshal(&ProsemirrorInitMessage{
+		DocumentID:     testDocumentID,
+		DocumentSchema: json.RawMessage(testSchema),
+		Document:       json.RawMessage(testDocument),
+	})
+	if err != nil {
+		t.Fatalf("could not encode init message: %v", err)
+	}
+
+	message, err := json.Marshal(&Message{
+		Type:    MessageTypeProsemirrorInit,
+		Payload: payload,
+	})
+	if err != nil {
+		t.Fatalf("could not encode message: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	err = conn.Write(ctx, websocket.MessageText, message)
+	if err != nil {
+		t.Fatalf("could not send init message: %v", err)
+	}
+
+	return readTestConnection(t, conn, messageType)
+}
+
+// readTestConnection will read from the connection until a message of the
+// given type is received and return its payload
+func readTestConnection(t *testing.T, conn *websocket.Conn,
+	messageType MessageType) json.RawMessage {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	for {
+		_, raw, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("expected %s, could not read message: %v", messageType, err)
+		}
+
+		var response struct {
+			Type    MessageType     `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		err = json.Unmarshal(raw, &response)
+		if err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+
+		if response.Type == messageType {
+			return response.Payload
+		}
+	}
+}
+
+// expectConnectionClosed will read from the connection until it is closed
+// by the server
+func expectConnectionClosed(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	for {
+		_, _, err := conn.Read(ctx)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			t.Fatal("connection was not closed by the server")
+		}
+		return
+	}
+}
+
+func TestClientDisconnectLeak(t *testing.T) {
+
+	_, url := newTestServer(t, testDocumentID, "editor", "other")
+
+	// keep the room open with another client
+	other := dialTestServer(t, url, "other")
+	defer other.Close(websocket.StatusNormalClosure, "")
+	initTestConnection(t, other, MessageTypeProsemirrorPresence)
+
+	ignoreRoom := goleak.IgnoreCurrent()
+
+	editor := dialTestServer(t, url, "editor")
+	initTestConnection(t, editor, MessageTypeProsemirrorPresence)
+
+	err := editor.Close(websocket.StatusNormalClosure, "")
+	if err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+
+	// all goroutines of the connection must exit
+	goleak.VerifyNone(t, ignoreRoom)
+}
+
+func TestPermissionDeniedLeak(t *testing.T) {
+
+	_, url := newTestServer(t)
+
+	ignoreServer := goleak.IgnoreCurrent()
+
+	conn := dialTestServer(t, url, "editor")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	var response ProsemirrorErrorResponse
+	payload := initTestConnection(t, conn, MessageTypeProsemirrorError)
+	decodeTestPayload(t, payload, &response.Payload)
+	if response.Payload.Code != ErrorCodePermissionDenied {
+		t.Errorf("expected permission denied, got %s", response.Payload.Code)
+	}
+
+	// the connection is closed without creating a room
+	expectConnectionClosed(t, conn)
+	goleak.VerifyNone(t, ignoreServer)
+}
+
+func TestLastClientLeavingLeak(t *testing.T) {
+
+	_, url := newTestServer(t, testDocumentID, "editor")
+
+	ignoreServer := goleak.IgnoreCurrent()
+
+	editor := dialTestServer(t, url, "editor")
+	initTestConnection(t, editor, MessageTypeProsemirrorPresence)
+
+	err := editor.Close(websocket.StatusNormalClosure, "")
+	if err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+
+	// the room handler and the subscription of the room must exit
+	goleak.VerifyNone(t, ignoreServer)
+}
+
+func TestHubShutdownLeak(t *testing.T) {
+
+	// only the hub itself may be kept after the test server was closed. the
+	// check is registered first to run after all other cleanups
+	ignoreTest := goleak.IgnoreCurrent()
+	t.Cleanup(func() {
+		goleak.VerifyNone(t, ignoreTest, ignoreHub)
+	})
+
+	server, url := newTestServer(t, testDocumentID, "editor")
+
+	editor := dialTestServer(t, url, "editor")
+	defer editor.Close(websocket.StatusNormalClosure, "")
+	initTestConnection(t, editor, MessageTypeProsemirrorPresence)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	// the shutdown waits until the connections of all clients are closed
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(ctx)
+	}()
+
+	// the clients are informed before the connection is closed
+	readTestConnection(t, editor, MessageTypeServerShutdown)
+	expectConnectionClosed(t, editor)
+
+	err := <-shutdown
+	if err != nil {
+		t.Fatalf("could not shut down server: %v", err)
+	}
+}
@@ -2,9 +2,15 @@ package websocket
 
 import (
 	"encoding/json"
+	"sync/atomic"
 	"time"
 
+	"dkfbasel.ch/orca/collaboration/src/internal/attribution"
 	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"dkfbasel.ch/orca/pkg/logger"
+	"golang.org/x/time/rate"
 )
 
 // expire all rooms, that did not receive any action during the given time
@@ -16,22 +22,67 @@ type WebsocketRoom struct {
 	Register   chan *Registration // register a new client
 	Unregister chan *Registration // deregister a client
 
+	Presence       map[*WebsocketClient]*Presence // selections of all clients
+	RemotePresence map[string][]*Presence         // selections on other instances
+
+	PresenceChanged bool             // selections of our clients changed since the last update
+	PresenceUpdate  <-chan time.Time // send the changed selections, nil if nothing changed
+
 	Notify    chan NotifyMessage // send message to all clients except the sender
 	Broadcast chan []byte        // broadcast messages to all clients
 
 	Handler chan Message // handle incoming messages
 
+	Shutdown chan chan bool // close the room when the server is shut down
+	Stop     chan bool      // close the room when the last client left
+	Done     chan bool      // closed when the room handler returned
+
+	Remote       chan []byte              // messages from other instances
+	Restore      chan *versionRestore     // document versions to restore
+	Attributed   chan *attributionRebuild // attributions rebuilt outside of the room
+	Subscription repository.Subscription  // subscription to other instances
+
 	DocumentID      string          // unique id of the respective editor content
 	DocumentSchema  json.RawMessage // schema of the respective document
 	DocumentVersion int64           // current document version on the server
+
+	SchemaHash    string // hash of the schema of the respective document
+	SchemaVersion string // version of the schema sent by the client (optional)
+
+	Schema   *model.Schema // parsed schema of the respective document
+	Document *model.Node   // current document content on the server
+
+	SnapshotVersion int64 // version of the last persisted snapshot
+
+	Attribution   *attribution.Tracker // authors of the document content
+	BlameRequests []Message            // blame requests waiting for a rebuilt attribution
+	CommentIDs    map[string]string    // server ids of preliminary comments
+
+	Config  environment.WebsocketConfig // server configuration
+	Limiter *rate.Limiter               // limit of messages handled by the room
+
+	Suspend   chan bool // drop the state of the room, as it is idle
+	Suspended bool      // state must be validated before handling messages
+
+	Failed bool // the step log could not be reset, i.e. the room must be closed
+
+	// time of the last handled message in unix nanoseconds. the value is
+	// read by the hub and must therefore be accessed atomically
+	lastActivity int64
 }
 
 // newWebsocketRoom will initialize a new websocket room with corresponding
 // handlers
-func newWebsocketRoom(srv *environment.Services, id string) *WebsocketRoom {
+func newWebsocketRoom(srv *environment.Services, config environment.WebsocketConfig,
+	id string) *WebsocketRoom {
 
 	room := WebsocketRoom{}
+	room.Config = config
+	room.Limiter = newRoomLimiter(config)
 	room.Clients = make(map[*WebsocketClient]bool)
+	room.Presence = make(map[*WebsocketClient]*Presence)
+	room.RemotePresence = make(map[string][]*Presence)
+	room.CommentIDs = make(map[string]string)
 	room.Register = make(chan *Registration)
 	room.Unregister = make(chan *Registration)
 
@@ -39,18 +90,89 @@ func newWebsocketRoom(srv *environment.Services, id string) *WebsocketRoom {
 	// notify channel of our room while still handling an incoming message
 	room.Notify = make(chan NotifyMessage, 50)
 
-	// we need a buffer on the broadcast, to be able to send a message on the
-	// broadcast channel of our room while still handling an incoming message
+	// we need a buffer on the broadcast, to not block senders outside of the
+	// room while the room is handling an incoming message. the room itself
+	// must use broadcastRoom, as it can not read while sending
 	room.Broadcast = make(chan []byte, 50)
 
 	// handler for incoming messages
 	room.Handler = make(chan Message)
 
+	// we need a buffer on the shutdown, to be able to inform all rooms
+	// without waiting for each room to finish its current message
+	room.Shutdown = make(chan chan bool, 1)
+	room.Stop = make(chan bool)
+	room.Done = make(chan bool)
+
 	room.DocumentID = id
 	room.DocumentVersion = -1
+	room.SnapshotVersion = -1
+	room.touch()
+
+	// we need a buffer on the suspend, to be able to inform the room without
+	// waiting for the room to finish its current message
+	room.Suspend = make(chan bool, 1)
+
+	// document versions and attributions are built outside of the room and
+	// handed back to the room
+	room.Restore = make(chan *versionRestore)
+	room.Attributed = make(chan *attributionRebuild)
+
+	// subscribe to steps accepted by other instances of the service. note
+	// that the subscription must be active before the room version is
+	// fetched from the step log to avoid missing any steps
+	room.Remote = make(chan []byte)
+	subscribeRoom(srv, &room)
 
 	// handle registration
 	go handleRoom(srv, &room)
 
 	return &room
 }
+
+// subscribeRoom will subscribe the room to the steps accepted by other
+// instances of the service
+func subscribeRoom(srv *environment.Services, room *WebsocketRoom) {
+
+	subscription, err := srv.Broker.Subscribe(room.DocumentID)
+	if err != nil {
+		logger.DebugError("could not subscribe to other instances", err,
+			logger.String("documentid", room.DocumentID))
+		return
+	}
+
+	room.Subscription = subscription
+	go func() {
+		for payload := range subscription.Messages() {
+			select {
+			case room.Remote <- payload:
+			case <-room.Done:
+				return
+			}
+		}
+	}()
+}
+
+// unsubscribeRoom will stop receiving messages from other instances
+func unsubscribeRoom(room *WebsocketRoom) {
+
+	if room.Subscription == nil {
+		return
+	}
+
+	err := room.Subscription.Close()
+	if err != nil {
+		logger.DebugError("could not close room subscription", err)
+	}
+	room.Subscription = nil
+}
+
+// touch will set the last activity of the room to the current time
+func (room *WebsocketRoom) touch() {
+	atomic.StoreInt64(&room.lastActivity, time.Now().UnixNano())
+}
+
+// idle will return the time since the last activity of the room
+func (room *WebsocketRoom) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&room.lastActivity)))
+}
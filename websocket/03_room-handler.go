@@ -1,43 +1,147 @@
 package websocket
 
 import (
+	"time"
+
 	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
 )
 
 // handleRoom will handle all messages sent to the given room
 func handleRoom(srv *environment.Services, room *WebsocketRoom) {
 
+	// inform the hub and all clients when the room is closed
+	defer close(room.Done)
+
+	// stop receiving messages from other instances
+	defer unsubscribeRoom(room)
+
+	// check regularly if a snapshot of the idle document should be saved
+	snapshotTicker := time.NewTicker(snapshotIdleInterval / 2)
+	defer snapshotTicker.Stop()
+
 	// run a loop to handle all incoming messages for this room
 	for {
 		select {
 		// register a client in a document room
 		case registration := <-room.Register:
 			room.Clients[registration.Client] = true
+			metrics.Clients.Inc()
 			registration.Client.MessageHandler = room.Handler
+			registration.Client.roomDone = room.Done
 			close(registration.Done)
+			joinRoomPresence(srv, room, registration.Client)
 
 		// unregister a client from a document room
 		case registration := <-room.Unregister:
-			delete(room.Clients, registration.Client)
+			if room.Clients[registration.Client] {
+				delete(room.Clients, registration.Client)
+				metrics.Clients.Dec()
+			}
 			close(registration.Done)
+			leaveRoomPresence(srv, room, registration.Client)
 
 		// broadcast a message to all clients (including sender)
 		case message := <-room.Broadcast:
-			for client := range room.Clients {
-				client.Send <- message
-			}
+			broadcastRoom(room, message)
 
 		// notify all other clients in the room (excluding sender)
 		case notify := <-room.Notify:
 			for client := range room.Clients {
 				if client != notify.Client {
-					client.Send <- notify.Payload
+					client.send(notify.Payload)
 				}
 			}
 
 		// handle incoming messages
 		case message := <-room.Handler:
+
+			// do not handle more messages than allowed for the room. init
+			// messages are always handled to not leave clients uninitialized.
+			// presence messages are only limited per client, to not use up
+			// the budget of the room for document changes
+			if message.Type != MessageTypeProsemirrorInit &&
+				message.Type != MessageTypeProsemirrorPresence && !room.Limiter.Allow() {
+				roomRateLimitExceeded(&message, "too many messages sent to the document")
+				continue
+			}
+
+			start := time.Now()
+			room.touch()
+
+			// validate the state of the room if it was suspended
+			if room.Suspended {
+				resumeRoom(srv, room)
+			}
+
 			handleMessage(srv, room, &message)
+			metrics.Messages.WithLabelValues(message.Type.label()).
+				Observe(time.Since(start).Seconds())
+
+			// save a snapshot after a given number of steps
+			if room.DocumentVersion-room.SnapshotVersion >= snapshotStepInterval {
+				saveRoomSnapshot(srv, room)
+			}
+
+		// handle messages from other instances
+		// note: suspended rooms forward the steps without validating them
+		case payload := <-room.Remote:
+			room.touch()
+			handleRemoteMessage(srv, room, payload)
+
+		// restore a document version built outside of the room
+		case restore := <-room.Restore:
+			room.touch()
+			restoreRoomVersion(srv, room, restore)
+
+		// answer the blame requests with an attribution rebuilt outside of
+		// the room
+		case rebuild := <-room.Attributed:
+			room.touch()
+			completeAttribution(srv, room, rebuild)
+
+		// save a snapshot of documents that were not changed for a while
+		case <-snapshotTicker.C:
+			if room.idle() >= snapshotIdleInterval {
+				saveRoomSnapshot(srv, room)
+			}
+
+		// inform all clients about the changed selections
+		case <-room.PresenceUpdate:
+			sendRoomPresence(srv, room)
+
+		// drop the state of the room if it was idle for too long
+		case <-room.Suspend:
+			suspendRoom(srv, room)
+
+		// close the room when the last client left
+		case <-room.Stop:
+			saveRoomSnapshot(srv, room)
+			return
+
+		// close the room when the server is shut down
+		case done := <-room.Shutdown:
+			shutdownRoom(srv, room)
+			close(done)
+			return
+		}
+
+		// close the room if it can not continue without a step log. the
+		// clients will load the document again when they reconnect
+		if room.Failed {
+			closeFailedRoom(srv, room)
+			return
 		}
 	}
 }
+
+// broadcastRoom will send the given message to all clients of the room. The
+// message handlers of the room must use this function instead of the
+// broadcast channel, that is only read by the room itself and would block
+// the room if it is full. Sending to a client never blocks, the overflow
+// policy of the client is applied if its queue is full
+func broadcastRoom(room *WebsocketRoom, message []byte) {
+	for client := range room.Clients {
+		client.send(message)
+	}
+}
@@ -1,12 +1,16 @@
 package websocket
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	domain "dkfbasel.ch/orca/collaboration/src/domain"
-	"dkfbasel.ch/orca/collaboration/src/internal/session"
 	"dkfbasel.ch/orca/pkg/logger"
 	"nhooyr.io/websocket"
 )
@@ -16,21 +20,43 @@ type WebsocketClient struct {
 	// reference the websocket connection
 	Conn *websocket.Conn
 
+	// unique id of the connection
+	ID string
+
 	// document is the unique id of the block that the client is working on
 	DocumentID     string
 	DocumentSchema json.RawMessage
+	SchemaHash     string // hash of the schema of the client (optional)
 
 	// unique id of the respective user
 	UserID string
 
+	// memberships of the user from the session information
+	Memberships []string
+
 	// Document permissions of the respective client (read, comment, edit)
 	Permission domain.Permission
 
-	// use a channel to send messages
-	Send chan []byte
+	// bounded queue of messages to send to the client. use the send method
+	// to add messages, that will apply the overflow policy if the queue is full
+	Send         chan []byte
+	Overflow     OverflowPolicy
+	queueMutex   sync.Mutex
+	dropMessages bool // the client is disconnected
+	awaitReload  bool // the client must reload the document after a resync
+
+	// close the connection after all messages were sent
+	Close chan closeRequest
+
+	// limits of the messages the client may send
+	Limiter *clientLimiter
 
 	// reference to the handler that will manage the message
 	MessageHandler chan Message
+	roomDone       chan bool // closed when the room handler returned
+
+	// context of the connection, cancelled when the connection is closed
+	ctx context.Context
 }
 
 // Registration with separate done channel to wait until registration is complete
@@ -47,13 +73,18 @@ func newRegistration(client *WebsocketClient) *Registration {
 	}
 }
 
+// time to wait for queued messages to be sent when a connection is closed
+const clientCloseTimeout = time.Second * 5
+
 // wsHandler defines how to handle websocket requests
 func wsHandler(hub *WebsocketHub, w http.ResponseWriter, r *http.Request) error {
 
-	// parse the account id from the session header (passed by the auth service)
-	sessionInfo, err := session.Parse(r.Header.Get("Session"))
+	// parse the account id from the signed session header (passed by the
+	// auth service)
+	sessionInfo, err := hub.Srv.Sessions.Parse(r.Header.Get("Session"))
 	if err != nil {
-		return logger.NewError("session information missing", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return logger.NewError("session information invalid", err)
 	}
 
 	// initialize a new websocket connection
@@ -64,22 +95,68 @@ func wsHandler(hub *WebsocketHub, w http.ResponseWriter, r *http.Request) error
 	if err != nil {
 		return fmt.Errorf("could not accept websocket connection: %w", err)
 	}
-	// set read limit to 3MB to avoid errors on pasting long text passages
-	conn.SetReadLimit(3000000)
+	// set read limit to twice the maximum message size to avoid errors on
+	// pasting long text passages. messages exceeding the maximum message size
+	// are answered with an error response, messages exceeding the read limit
+	// will close the connection
+	conn.SetReadLimit(maxMessageSize * 2)
 	defer conn.Close(websocket.StatusInternalError, "connection could not be established")
 
 	// initialize a new websocket client
 	client := &WebsocketClient{}
+	client.ID = newClientID()
 	client.UserID = sessionInfo.UserID
+	client.Memberships = sessionInfo.Memberships
 	client.Conn = conn
-	client.Send = make(chan []byte)
+	client.Send = make(chan []byte, hub.Config.QueueSize)
+	client.Overflow = hub.Overflow
+	client.Close = make(chan closeRequest, 1)
+	client.Limiter = newClientLimiter(hub.Config)
 
 	// initialize client with no permissions
 	client.Permission = domain.None
 
+	// cancel all reads and writes when the connection is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.ctx = ctx
+
+	// do not accept any connections when the server is shutting down
+	if !hub.connect(client) {
+		conn.Close(websocket.StatusGoingAway, "server shutdown")
+		return nil
+	}
+
 	// initialize separate routine to send messages back to the client
-	go handleSend(client)
+	sent := make(chan bool)
+	go func() {
+		handleSend(client)
+		hub.disconnect(client)
+		close(sent)
+	}()
 
 	// handle incoming requests
-	return handleReceive(hub, client)
+	err = handleReceive(hub, client)
+
+	// close the connection after all queued messages were sent, but do not
+	// wait for clients that do not receive any messages anymore
+	client.close(websocket.StatusNormalClosure, "")
+	select {
+	case <-sent:
+	case <-time.After(clientCloseTimeout):
+		cancel()
+		<-sent
+	}
+
+	return err
+}
+
+// newClientID will generate a random id to identify a connection
+func newClientID() string {
+	id := make([]byte, 12)
+	_, err := rand.Read(id)
+	if err != nil {
+		logger.DebugError("could not generate client id", err)
+	}
+	return hex.EncodeToString(id)
 }
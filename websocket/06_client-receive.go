@@ -1,7 +1,6 @@
 package websocket
 
 import (
-	"context"
 	"encoding/json"
 
 	domain "dkfbasel.ch/orca/collaboration/src/domain"
@@ -10,32 +9,34 @@ import (
 )
 
 func handleReceive(hub *WebsocketHub, client *WebsocketClient) error {
+
+	// unregister the client from its room when the connection is closed
+	defer unregisterClient(hub, client)
+
 	// handle incoming requests
 	for {
 		// read data from the socket
-		_, dta, err := client.Conn.Read(context.Background())
+		_, dta, err := client.Conn.Read(client.ctx)
 
 		// handle closing of websockets
 		if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
 			logger.Debug("socket closed normally")
-
-			// unregister the client from the hub
-			registration := newRegistration(client)
-			hub.Unregister <- registration
 			return nil
 		}
 
 		// handle any error when reading (i.e. user closed window)
 		if err != nil {
-			// logger.Debug("request failed")
-
-			// unregister the client from the hub
-			registration := newRegistration(client)
-			hub.Unregister <- registration
-
 			return nil
 		}
 
+		// inform the client that the message is too large to be handled
+		if len(dta) > maxMessageSize {
+			logger.Debug("message too large", logger.String("userid", client.UserID))
+			sendProsemirrorError(client.send, "", ErrorCodePayloadTooLarge, -1, -1,
+				"message exceeds the maximum message size")
+			continue
+		}
+
 		// parse the message content
 		var msg Message
 		err = json.Unmarshal(dta, &msg)
@@ -44,6 +45,12 @@ func handleReceive(hub *WebsocketHub, client *WebsocketClient) error {
 				logger.String("content", string(dta)))
 		}
 
+		// do not handle messages of clients exceeding their rate limits
+		if !client.Limiter.allow(len(dta)) {
+			rateLimitExceeded(client, msg.RequestID, "too many messages sent")
+			continue
+		}
+
 		msg.Raw = dta
 		msg.DocumentID = client.DocumentID
 		msg.UserID = client.UserID
@@ -68,8 +75,10 @@ func handleReceive(hub *WebsocketHub, client *WebsocketClient) error {
 				client.DocumentID = payload.DocumentID
 				msg.DocumentID = payload.DocumentID
 				client.DocumentSchema = payload.DocumentSchema
+				client.SchemaHash = clientSchemaHash(&payload)
 
-				p, err := hub.Srv.Postgres.FetchPermission(payload.DocumentID, client.UserID)
+				p, err := hub.Srv.Postgres.FetchPermission(payload.DocumentID,
+					client.UserID, client.Memberships)
 				if err != nil {
 					logger.Debug("could not fetch permission for client", logger.String("userid", client.UserID),
 						logger.String("documentid", client.DocumentID))
@@ -80,6 +89,8 @@ func handleReceive(hub *WebsocketHub, client *WebsocketClient) error {
 
 				if msg.Permission == domain.None {
 					logger.Debug("permission denied. client not registered")
+					sendProsemirrorError(client.send, msg.RequestID, ErrorCodePermissionDenied,
+						payload.DocumentVersion, -1, "no permission to access the document")
 					return nil
 				}
 
@@ -88,6 +99,12 @@ func handleReceive(hub *WebsocketHub, client *WebsocketClient) error {
 				hub.Register <- registration
 				<-registration.Done
 
+				// the client is not assigned to a room if the server is
+				// shutting down
+				if client.MessageHandler == nil {
+					return nil
+				}
+
 				logger.Debug("client registered", logger.String("userid", client.UserID),
 					logger.String("documentid", client.DocumentID),
 					logger.String("permission", msg.Permission.String()))
@@ -97,15 +114,33 @@ func handleReceive(hub *WebsocketHub, client *WebsocketClient) error {
 
 		if msg.Permission == domain.None {
 			logger.Debug("permission denied. message not handled")
+			sendProsemirrorError(client.send, msg.RequestID, ErrorCodePermissionDenied,
+				-1, -1, "no permission to access the document")
 			return nil
 		}
 
 		// store the client send channel as callback channel on the message
-		msg.Reply = client.Send
+		msg.Reply = client.send
 
 		// send the message to the message handler of the room
-		client.MessageHandler <- msg
+		select {
+		case client.MessageHandler <- msg:
+		case <-client.roomDone:
+			logger.Debug("room closed. message not handled")
+			return nil
+		}
+
+	}
+
+}
 
+// unregisterClient will remove the client from its room, if the client was
+// registered in a room
+func unregisterClient(hub *WebsocketHub, client *WebsocketClient) {
+	if client.MessageHandler == nil {
+		return
 	}
 
+	registration := newRegistration(client)
+	hub.Unregister <- registration
 }
@@ -12,13 +12,38 @@ import (
 func handleSend(client *WebsocketClient) {
 
 	// read all messages sent on the send channel and return it to the client
-	for message := range client.Send {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-		err := client.Conn.Write(ctx, websocket.MessageText, message)
-		cancel()
-		if err != nil {
-			logger.Debug("could not write message")
+	for {
+		select {
+		case message := <-client.Send:
+			write(client, message)
+
+		// close the connection after all queued messages were sent
+		case request := <-client.Close:
+			for len(client.Send) > 0 {
+				write(client, <-client.Send)
+			}
+
+			err := client.Conn.Close(request.Code, request.Reason)
+			if err != nil {
+				logger.Debug("could not close connection", logger.Err(err))
+			}
+			return
+
+		// stop sending if the connection was closed
+		case <-client.ctx.Done():
+			return
 		}
 	}
 
 }
+
+// write will send the given message to the client
+func write(client *WebsocketClient, message []byte) {
+	ctx, cancel := context.WithTimeout(client.ctx, time.Second*10)
+	defer cancel()
+
+	err := client.Conn.Write(ctx, websocket.MessageText, message)
+	if err != nil {
+		logger.Debug("could not write message")
+	}
+}
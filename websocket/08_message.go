@@ -14,12 +14,42 @@ const MessageTypeProsemirrorUpdate MessageType = "prosemirror-update"
 const MessageTypeProsemirrorSteps MessageType = "prosemirror-steps"
 const MessageTypeProsemirrorApproval MessageType = "prosemirror-approval"
 const MessageTypeProssemirrorReload MessageType = "prosemirror-reload"
+const MessageTypeProsemirrorError MessageType = "prosemirror-error"
+const MessageTypeProsemirrorPresence MessageType = "prosemirror-presence"
+const MessageTypeProsemirrorAck MessageType = "prosemirror-ack"
+const MessageTypeServerShutdown MessageType = "server-shutdown"
+const MessageTypeProsemirrorSchemaMismatch MessageType = "prosemirror-schema-mismatch"
+const MessageTypeProsemirrorVersions MessageType = "prosemirror-versions"
+const MessageTypeProsemirrorVersion MessageType = "prosemirror-version"
+const MessageTypeProsemirrorRestore MessageType = "prosemirror-restore"
+const MessageTypeProsemirrorBlame MessageType = "prosemirror-blame"
+const MessageTypeCommentsSync MessageType = "comments-sync"
+const MessageTypeCommentEvent MessageType = "comment-event"
+const MessageTypeCommentID MessageType = "comment-id"
+
+// label will return the message type to be used as metrics label. Unknown
+// message types are combined to avoid unbounded label values
+func (t MessageType) label() string {
+	switch t {
+	case MessageTypeProsemirrorInit, MessageTypeProsemirrorUpdate,
+		MessageTypeProsemirrorSteps, MessageTypeProsemirrorPresence,
+		MessageTypeProsemirrorVersions, MessageTypeProsemirrorVersion,
+		MessageTypeProsemirrorRestore, MessageTypeProsemirrorBlame:
+		return string(t)
+	default:
+		return "unknown"
+	}
+}
 
 type Message struct {
 	Type    MessageType     `json:"type,omitempty"`
 	Payload json.RawMessage `json:"payload"`
 	Raw     []byte          `json:"--"`
 
+	// optional id of the request, that is echoed back in acknowledgements
+	// and error responses
+	RequestID string `json:"requestId,omitempty"`
+
 	// internal information
 	DocumentID string            `json:"-"` // current document id
 	UserID     string            `json:"-"` // current connected user
@@ -27,7 +57,7 @@ type Message struct {
 
 	// channel to reply to the sender
 	Client *WebsocketClient `json:"-"`
-	Reply  chan []byte      `json:"-"`
+	Reply  func([]byte)     `json:"-"`
 }
 
 // Response sent back to the client
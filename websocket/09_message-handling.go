@@ -1,6 +1,8 @@
 package websocket
 
 import (
+	"encoding/json"
+
 	domain "dkfbasel.ch/orca/collaboration/src/domain"
 	"dkfbasel.ch/orca/collaboration/src/internal/environment"
 	"dkfbasel.ch/orca/pkg/logger"
@@ -10,7 +12,7 @@ import (
 func handleMessage(srv *environment.Services, room *WebsocketRoom,
 	message *Message) {
 
-	if message.Permission != domain.Edit {
+	if message.Permission == domain.None {
 		logger.Debug("permission denied")
 		return
 	}
@@ -19,16 +21,78 @@ func handleMessage(srv *environment.Services, room *WebsocketRoom,
 
 	case MessageTypeProsemirrorInit:
 		logger.Debug("handle prosemirror init")
-		handleProsemirrorInitMessage(srv, room, message)
+
+		// clients initialize the document again after a resync of their
+		// queue, all further messages are based on the new state
+		if message.Client != nil {
+			message.Client.resumeQueue()
+		}
+
+		if !handleProsemirrorInitMessage(srv, room, message) {
+			return
+		}
 		handleProsemirrorStepsMessage(srv, room, message, true)
 
+		// send all comment threads after the client knows the document
+		sendCommentsSync(srv, room, message)
+
 	case MessageTypeProsemirrorUpdate:
 		logger.Debug("handle prosemirror update")
+		if !canSendSteps(room, message) {
+			return
+		}
 		handleProsemirrorStepsMessage(srv, room, message, false)
 
 	case MessageTypeProsemirrorSteps:
 		logger.Debug("handle prosemirror steps")
+		if !canSendSteps(room, message) {
+			return
+		}
 		handleProsemirrorStepsMessage(srv, room, message, false)
+
+	case MessageTypeProsemirrorPresence:
+		handleProsemirrorPresenceMessage(srv, room, message)
+
+	case MessageTypeProsemirrorVersions:
+		logger.Debug("handle prosemirror versions")
+		handleProsemirrorVersionsMessage(srv, room, message)
+
+	case MessageTypeProsemirrorVersion:
+		logger.Debug("handle prosemirror version")
+		handleProsemirrorVersionMessage(srv, room, message)
+
+	case MessageTypeProsemirrorRestore:
+		logger.Debug("handle prosemirror restore")
+		handleProsemirrorRestoreMessage(srv, room, message)
+
+	case MessageTypeProsemirrorBlame:
+		logger.Debug("handle prosemirror blame")
+		handleProsemirrorBlameMessage(srv, room, message)
 	}
 
 }
+
+// canSendSteps will check if the sender of the message is allowed to send
+// steps. Users with view permissions are informed that their steps are not
+// accepted. Users with comment permissions may send steps, but
+// handleSpecialSteps will only accept steps that modify comments
+func canSendSteps(room *WebsocketRoom, message *Message) bool {
+
+	if message.Permission >= domain.Comment {
+		return true
+	}
+
+	logger.Debug("permission denied. steps not accepted",
+		logger.String("userid", message.UserID),
+		logger.String("permission", message.Permission.String()))
+
+	// use the version of the client as base version of the error
+	var payload ProsemirrorStepMessage
+	_ = json.Unmarshal(message.Payload, &payload)
+
+	replyProsemirrorError(message, ErrorCodePermissionDenied,
+		payload.DocumentVersion, room.DocumentVersion,
+		"no permission to change the document")
+
+	return false
+}
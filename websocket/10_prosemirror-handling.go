@@ -1,14 +1,17 @@
 package websocket
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
 
 	domain "dkfbasel.ch/orca/collaboration/src/domain"
 	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/collaboration/src/internal/transform"
+	"dkfbasel.ch/orca/collaboration/src/repository"
 	image "dkfbasel.ch/orca/image/src/domain"
 	"dkfbasel.ch/orca/pkg/logger"
 	"go.uber.org/zap"
@@ -19,56 +22,74 @@ type ProsemirrorInitMessage struct {
 	DocumentID      string          `json:"documentid,omitempty"`
 	DocumentSchema  json.RawMessage `json:"schema,omitempty"`
 	DocumentVersion int64           `json:"version,omitempty"`
+	Document        json.RawMessage `json:"doc,omitempty"`
+
+	// optional information to compare the schema of the client with the
+	// schema of the room. the hash is computed from the schema if missing
+	SchemaHash    string `json:"schema_hash,omitempty"`
+	SchemaVersion string `json:"schema_version,omitempty"`
 }
 
 // handleProsemirrorInitMessage will handle all messages used to initialize
-// a prosemirror document
+// a prosemirror document. False is returned if the client was rejected or
+// the document can not be sent to the client yet
 func handleProsemirrorInitMessage(srv *environment.Services, room *WebsocketRoom,
-	message *Message) {
+	message *Message) bool {
 
 	var payload ProsemirrorInitMessage
 	err := json.Unmarshal(message.Payload, &payload)
 	if err != nil {
 		logger.DebugError("could not decode load message payload", err)
-		return
+		return false
 	}
 
-	// set the room version to the document version of the first
-	// registering client
-	if room.DocumentVersion == -1 {
-
-		room.DocumentSchema = payload.DocumentSchema
+	// only editors may define the schema, the version and the content of
+	// the document. all other clients are caught up with the server state
+	editor := message.Permission >= domain.Edit
 
-		// check if we do already have a room starting id from redis
-		cmd := srv.Redis.Get(message.DocumentID + "-starting-version")
-		roomStartVersion, err := cmd.Int64()
+	// initialize the room state from the latest snapshot and the step log
+	// when the first client registers
+	if room.DocumentVersion == -1 {
 
-		if err != nil {
-			// use the version from the client if we do not have a start version yet
+		if editor {
+			initializeRoomSchema(room, &payload)
+		}
 
-			room.DocumentVersion = payload.DocumentVersion
-			srv.Redis.Set(message.DocumentID+"-starting-version", room.DocumentVersion, roomExpiration)
-
-		} else {
-			// use the redis document version
-			// calculate the version of the room by adding the number
-			// of steps currently in the redis buffer
-			cmd2 := srv.Redis.LLen(message.DocumentID + "-steps")
-			stepCount, err := cmd2.Result()
-			if err != nil {
-				logger.DebugError("could not fetch step count from redis", err,
-					logger.String("documentid", message.DocumentID))
+		// use the version of the first registering editor if the server
+		// does not know anything about the document yet
+		if !loadRoomState(srv, room) {
+			if !editor {
+				logger.Debug("document is not initialized yet",
+					logger.String("documentid", room.DocumentID),
+					logger.String("userid", message.UserID))
+				return false
 			}
 
-			room.DocumentVersion = roomStartVersion + stepCount
+			room.DocumentVersion = payload.DocumentVersion
+			resetStepLog(srv, room, room.DocumentVersion)
 		}
+
+	} else if !checkClientSchema(room, message, &payload) {
+		// clients with an incompatible schema must not change the document
+		return false
 	}
 
-	// reset the redis version to the client version if the client
-	// version is newer than the redis version. Also reset the redis
-	// cache for the given document version
-	if room.DocumentVersion < payload.DocumentVersion {
-		logger.Debug("client version is newer than redis version",
+	// the snapshot can not be parsed before the schema is known, i.e. if the
+	// first client was not an editor. parse it once an editor provides the
+	// schema, instead of relying on the content sent by the editor
+	if room.Document == nil && room.Schema != nil && room.SnapshotVersion != -1 {
+		loadRoomDocument(srv, room)
+	}
+
+	if !editor {
+		return true
+	}
+
+	// reset the step log to the client version if the client version is
+	// newer than the step log version and no snapshot of the document is
+	// available on the server. Otherwise the server state is authoritative
+	if room.DocumentVersion < payload.DocumentVersion && room.SnapshotVersion == -1 {
+		logger.Debug("client version is newer than step log version",
 			zap.Int64("message-version", payload.DocumentVersion),
 			zap.Int64("room-version", room.DocumentVersion))
 
@@ -76,18 +97,75 @@ func handleProsemirrorInitMessage(srv *environment.Services, room *WebsocketRoom
 		// from the client
 		room.DocumentVersion = payload.DocumentVersion
 
-		// reset the starting version
-		srv.Redis.Set(message.DocumentID+"-starting-version", room.DocumentVersion, roomExpiration)
+		// reset the step log to start at the new version
+		resetStepLog(srv, room, room.DocumentVersion)
 
-		// empty the saved -steps, -userids and -clientids
-		srv.Redis.Del(message.DocumentID+"-steps", message.DocumentID+"-userids", message.DocumentID+"-clientids")
+		// the document content of the room is outdated
+		room.Document = nil
 
-		logger.Debug("Redis room version got set to message version",
+		logger.Debug("step log version got set to message version",
 			zap.Int64("message-version", payload.DocumentVersion),
 			zap.Int64("room-version", room.DocumentVersion),
 			zap.String("documentId", message.DocumentID))
 	}
 
+	// use the document content of the client if the room does not know
+	// the content of the current version yet
+	if room.Document == nil {
+		initializeRoomDocument(room, &payload)
+	}
+
+	return true
+}
+
+// resetStepLog will remove all steps of the document and start a new
+// step log at the given version. The room can not continue without a step
+// log and is closed if the step log could not be reset
+func resetStepLog(srv *environment.Services, room *WebsocketRoom, version int64) error {
+
+	err := srv.Steps.Reset(room.DocumentID, version)
+	if err != nil {
+		logger.DebugError("could not reset step log", err,
+			logger.String("documentid", room.DocumentID))
+		room.Failed = true
+		return err
+	}
+
+	err = srv.Steps.Expire(room.DocumentID, roomExpiration)
+	if err != nil {
+		logger.DebugError("could not set expiration time on step log", err,
+			logger.String("documentid", room.DocumentID))
+	}
+
+	// inform other instances about the new version
+	publishRoomSync(srv, room, &RoomSyncMessage{Reset: true, Version: version})
+
+	return nil
+}
+
+// appendRoomSteps will add the given steps to the step log at the version
+// of the room. A new step log is started at the version of the room if the
+// step log does not exist anymore, i.e. because redis was restarted or the
+// log was evicted. The new version of the document is returned
+func appendRoomSteps(srv *environment.Services, room *WebsocketRoom,
+	stored []repository.StoredStep) (int64, error) {
+
+	version, err := srv.Steps.Append(room.DocumentID, room.DocumentVersion,
+		stored, roomExpiration)
+	if err != repository.ErrNoStepLog {
+		return version, err
+	}
+
+	logger.Debug("step log not available, starting a new step log",
+		zap.Int64("room-version", room.DocumentVersion),
+		zap.String("documentid", room.DocumentID))
+
+	err = resetStepLog(srv, room, room.DocumentVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	return srv.Steps.Append(room.DocumentID, room.DocumentVersion, stored, roomExpiration)
 }
 
 // ProsemirrorStepMessage information
@@ -145,6 +223,7 @@ func handleProsemirrorStepsMessage(srv *environment.Services, room *WebsocketRoo
 		// the room version, to inform the client to reload the page
 		response := ProsemirrorInfoResponse{}
 		response.Type = MessageTypeProssemirrorReload
+		metrics.Reloads.Inc()
 
 		// add the current server version
 		response.Payload.BaseVersion = payload.DocumentVersion
@@ -158,7 +237,7 @@ func handleProsemirrorStepsMessage(srv *environment.Services, room *WebsocketRoo
 		}
 
 		// send the info to reload the page back to the client
-		message.Reply <- msg
+		message.Reply(msg)
 		return
 	}
 
@@ -172,118 +251,110 @@ func handleProsemirrorStepsMessage(srv *environment.Services, room *WebsocketRoo
 			return
 		}
 
-		// log some information about the transactions
-		logger.Debug("transactions received", zap.Int("count", stepCount),
-			zap.Int64("message-version", payload.DocumentVersion),
-			zap.Int64("room-version", room.DocumentVersion))
-
-		// push all new steps to redis
-		for _, step := range payload.Steps {
-
-			// handle link and comment steps and check permissions
-			err := handleSpecialSteps(srv, message.DocumentID,
-				message.UserID, message.Permission, step)
-			if err != nil {
-				logger.DebugError("permission missmatch", err)
-				return
-			}
-
-			asString := fmt.Sprintf("%s", step)
-
-			// push the step to our document steps list
-			cmd := srv.Redis.RPush(message.DocumentID+"-steps", asString)
-			err = cmd.Err()
-			if err != nil {
-				logger.DebugError("could not store step in redis", err)
-				return
-			}
-
-			// push the client id into our document clientids list
-			cmd = srv.Redis.RPush(message.DocumentID+"-clientids", payload.ClientID)
-			err = cmd.Err()
-			if err != nil {
-				logger.DebugError("could not store clientid in redis", err)
-				return
-			}
-
-			// push the client id into our document clientids list
-			cmd = srv.Redis.RPush(message.DocumentID+"-userids", message.UserID)
-			err = cmd.Err()
-			if err != nil {
-				logger.DebugError("could not store userid in redis", err)
-				return
-			}
-
-		}
-
-		// expire keys after a certain time of inactivity
-		cmd := srv.Redis.Expire(message.DocumentID+"-steps", roomExpiration)
-		err := cmd.Err()
-		if err != nil {
-			logger.DebugError("could not set expiration time on step list", err)
+		// do not accept more steps than allowed in a single batch
+		if room.Config.MaxStepsPerBatch > 0 && stepCount > room.Config.MaxStepsPerBatch {
+			rateLimitExceeded(message.Client, message.RequestID,
+				"too many steps sent in a single message")
 			return
 		}
 
-		// expire keys after a certain time of inactivity
-		cmd = srv.Redis.Expire(message.DocumentID+"-clientids", roomExpiration)
-		err = cmd.Err()
-		if err != nil {
-			logger.DebugError("could not set expiration time on clientid list", err)
+		// steps sent with the init message are not checked by handleMessage
+		if fromInit && !canSendSteps(room, message) {
 			return
 		}
 
-		// expire keys after a certain time of inactivity
-		cmd = srv.Redis.Expire(message.DocumentID+"-userids", roomExpiration)
-		err = cmd.Err()
+		// log some information about the transactions
+		logger.Debug("transactions received", zap.Int("count", stepCount),
+			zap.Int64("message-version", payload.DocumentVersion),
+			zap.Int64("room-version", room.DocumentVersion))
+
+		// decode all steps once, the decoded steps are used for all checks
+		// and side effects of the steps
+		decoded, err := decodeProsemirrorSteps(room, payload.Steps)
 		if err != nil {
-			logger.DebugError("could not set expiration time on userid list", err)
+			logger.DebugError("steps could not be decoded", err,
+				logger.String("documentid", message.DocumentID))
+			replyProsemirrorError(message, applyErrorCode(err),
+				payload.DocumentVersion, room.DocumentVersion, err.Error())
 			return
 		}
 
-		// expire keys after a certain time of inactivity
-		cmd = srv.Redis.Expire(message.DocumentID+"-starting-version", roomExpiration)
-		err = cmd.Err()
+		// apply the steps to the document of the room to make sure that
+		// only steps that apply cleanly are distributed to other clients
+		doc, err := applyProsemirrorSteps(room, decoded)
+		if errors.Is(err, transform.ErrUnknownStepType) {
+			// steps of unknown types are passed through to the clients. the
+			// room can not keep track of the document content anymore
+			logger.Info("document of room dropped due to unknown step type",
+				logger.String("documentid", message.DocumentID))
+			room.Document = nil
+			err = nil
+		}
 		if err != nil {
-			logger.DebugError("could not set expiration time on start version", err)
+			logger.DebugError("steps could not be applied", err,
+				logger.String("documentid", message.DocumentID))
+			replyProsemirrorError(message, applyErrorCode(err),
+				payload.DocumentVersion, room.DocumentVersion, err.Error())
 			return
 		}
 
-		// initialize the response message
-		stepMessage := ProsemirrorStepResponse{}
-		stepMessage.Type = MessageTypeProsemirrorSteps
+		// check the permissions for all steps before anything is stored
+		stored := make([]repository.StoredStep, len(payload.Steps))
+		for i, step := range payload.Steps {
 
-		// set an flag if the steps was send after an prosemirror-init event
-		stepMessage.Payload.FromInit = fromInit
+			err = checkSpecialStep(srv, room, message.UserID, message.Permission, decoded[i])
+			if err != nil {
+				logger.DebugError("permission missmatch", err)
+				replyProsemirrorError(message, stepErrorCode(err),
+					payload.DocumentVersion, room.DocumentVersion, err.Error())
+				return
+			}
 
-		// save the version, that the client must at least provide, to integrate
-		// the new steps
-		stepMessage.Payload.BaseVersion = room.DocumentVersion
+			stored[i] = repository.StoredStep{
+				Step:     step,
+				ClientID: payload.ClientID,
+				UserID:   message.UserID,
+			}
+		}
 
-		// save the new version of the room (current version plus steps applied)
-		room.DocumentVersion = room.DocumentVersion + int64(stepCount)
+		// push all new steps to the step log. the steps are only stored if
+		// the step log is still at the version of the room
+		version, err := appendRoomSteps(srv, room, stored)
 
-		// add the new version number to the payload
-		stepMessage.Payload.Version = room.DocumentVersion
-		stepMessage.Payload.Steps = payload.Steps
+		if err == repository.ErrVersionConflict {
+			logger.Debug("step log version does not match room version",
+				zap.Int64("room-version", room.DocumentVersion),
+				zap.String("documentid", message.DocumentID))
 
-		// add flag to notify if the save function on client side should be
-		// executed immediately
-		stepMessage.Payload.SaveImmediate = payload.SaveImmediate
+			// the step log was modified elsewhere, send the missing steps
+			// to all clients of the room before informing the sender
+			version, err = srv.Steps.Version(message.DocumentID)
+			if err == nil {
+				catchUpRoom(srv, room, version)
+			}
 
-		// add the client ids for all steps
-		stepMessage.Payload.ClientIDs = make([]int, len(payload.Steps))
-		for i := 0; i < len(payload.Steps); i++ {
-			stepMessage.Payload.ClientIDs[i] = payload.ClientID
+			replyProsemirrorError(message, ErrorCodeVersionConflict,
+				payload.DocumentVersion, room.DocumentVersion,
+				"steps were not accepted due to a version conflict")
+			return
 		}
 
-		// send the new steps to all clients
-		broadcast, err := json.Marshal(stepMessage)
 		if err != nil {
-			logger.DebugError("could not marshal steps broadcast", err)
+			logger.DebugError("could not store steps", err)
+			replyProsemirrorError(message, ErrorCodeStorageFailure,
+				payload.DocumentVersion, room.DocumentVersion,
+				"steps could not be stored")
 			return
 		}
 
-		room.Broadcast <- broadcast
+		// save comments and links only for steps that were accepted
+		handleSpecialSteps(srv, room, message.UserID, message.Permission, decoded)
+
+		distributeRoomSteps(srv, room, message, stored, decoded, doc, version,
+			fromInit, payload.SaveImmediate)
+
+		// replace the preliminary ids of new comments in the document
+		rewritePreliminaryComments(srv, room, message, decoded)
 		return
 	}
 
@@ -298,24 +369,18 @@ func handleProsemirrorStepsMessage(srv *environment.Services, room *WebsocketRoo
 			return
 		}
 
-		// adapt the starting point from which we need to fetch steps
-		// (redis room might not have all steps from the beginning of the document)
-		fetchFrom := payload.DocumentVersion - room.DocumentVersion
-
-		// get the last x steps. note that redis will return
-		// the steps in inverse order and we need to resort it
-		// again
-		cmd2 := srv.Redis.LRange(message.DocumentID+"-steps", fetchFrom, 50000)
-		steps, err := cmd2.Result()
-
-		if err != nil {
-			logger.Debug("could not fetch steps from redis", logger.Err(err))
+		// fetch all steps the client is missing. neither the step log nor
+		// the step history might contain all steps of the document
+		steps, err := replaySteps(srv, message.DocumentID, payload.DocumentVersion,
+			room.DocumentVersion)
+		if err != nil && err != repository.ErrStepsUnavailable && err != repository.ErrNoStepLog {
+			logger.Debug("could not fetch steps from step log", logger.Err(err))
 			return
 		}
 
 		// send a response message to inform the client to reload the page
-		// if no steps are in the redis cache. The steps got cleared during
-		// initialisation as the current version was higher than
+		// if the steps are not in the step log anymore. The steps got cleared
+		// during initialisation as the current version was higher than
 		// the room version
 		if len(steps) == 0 {
 			logger.Debug("no steps found, got probably reset",
@@ -325,6 +390,7 @@ func handleProsemirrorStepsMessage(srv *environment.Services, room *WebsocketRoo
 
 			response := ProsemirrorInfoResponse{}
 			response.Type = MessageTypeProssemirrorReload
+			metrics.Reloads.Inc()
 
 			// add the current server version
 			response.Payload.BaseVersion = payload.DocumentVersion
@@ -338,19 +404,11 @@ func handleProsemirrorStepsMessage(srv *environment.Services, room *WebsocketRoo
 			}
 
 			// send the info to reload the page back to the client
-			message.Reply <- msg
+			message.Reply(msg)
 			return
 
 		}
 
-		// get the corresponding client ids
-		cmd3 := srv.Redis.LRange(message.DocumentID+"-clientids", fetchFrom, 50000)
-		clientIDs, err := cmd3.Result()
-		if err != nil {
-			logger.Debug("could not fetch clientids from redis", logger.Err(err))
-			return
-		}
-
 		// create a response message. the client id should be
 		// different from the effective client id, so that prosemirror
 		// knows that this is from someone else
@@ -361,20 +419,12 @@ func handleProsemirrorStepsMessage(srv *environment.Services, room *WebsocketRoo
 		response.Payload.BaseVersion = payload.DocumentVersion
 		response.Payload.Version = room.DocumentVersion
 
-		// add the steps to the response
+		// add the steps and the corresponding client ids to the response
 		response.Payload.Steps = make([]json.RawMessage, len(steps))
+		response.Payload.ClientIDs = make([]int, len(steps))
 		for i := range steps {
-			response.Payload.Steps[i] = json.RawMessage(steps[i])
-		}
-
-		// add the client ids to the response
-		response.Payload.ClientIDs = make([]int, len(clientIDs))
-		for i := range clientIDs {
-			response.Payload.ClientIDs[i], err = strconv.Atoi(clientIDs[i])
-			if err != nil {
-				logger.DebugError("could not convert client id to integer", err,
-					logger.String("clientid", clientIDs[i]))
-			}
+			response.Payload.Steps[i] = steps[i].Step
+			response.Payload.ClientIDs[i] = steps[i].ClientID
 		}
 
 		// encode the message for sending
@@ -385,9 +435,83 @@ func handleProsemirrorStepsMessage(srv *environment.Services, room *WebsocketRoo
 		}
 
 		// send the missing steps back to the client
-		message.Reply <- msg
+		message.Reply(msg)
+		return
+	}
+}
+
+// distributeRoomSteps will update the room with the given steps that were
+// appended to the step log and distribute them to all clients and other
+// instances of the service. The message is nil for steps of the server
+func distributeRoomSteps(srv *environment.Services, room *WebsocketRoom, message *Message,
+	stored []repository.StoredStep, steps []*ProsemirrorStep, doc *model.Node, version int64,
+	fromInit, saveImmediate bool) {
+
+	metrics.StepsAccepted.Add(float64(len(stored)))
+
+	// initialize the response message
+	stepMessage := ProsemirrorStepResponse{}
+	stepMessage.Type = MessageTypeProsemirrorSteps
+
+	// set an flag if the steps was send after an prosemirror-init event
+	stepMessage.Payload.FromInit = fromInit
+
+	// save the version, that the client must at least provide, to integrate
+	// the new steps
+	stepMessage.Payload.BaseVersion = room.DocumentVersion
+
+	// save the new version of the room (current version plus steps applied)
+	room.DocumentVersion = version
+	if doc != nil {
+		room.Document = doc
+	}
+
+	// add the new version number to the payload
+	stepMessage.Payload.Version = room.DocumentVersion
+
+	// add flag to notify if the save function on client side should be
+	// executed immediately
+	stepMessage.Payload.SaveImmediate = saveImmediate
+
+	// add the steps and the client ids for all steps
+	stepMessage.Payload.Steps = make([]json.RawMessage, len(stored))
+	stepMessage.Payload.ClientIDs = make([]int, len(stored))
+	for i := range stored {
+		stepMessage.Payload.Steps[i] = stored[i].Step
+		stepMessage.Payload.ClientIDs[i] = stored[i].ClientID
+	}
+
+	// send the new steps to all clients
+	broadcast, err := json.Marshal(stepMessage)
+	if err != nil {
+		logger.DebugError("could not marshal steps broadcast", err)
 		return
 	}
+
+	broadcastRoom(room, broadcast)
+
+	// inform all clients about the changed comment threads
+	broadcastCommentEvents(srv, room, steps)
+
+	// acknowledge the steps to the sender
+	if message != nil {
+		replyProsemirrorAck(message, stepMessage.Payload.BaseVersion,
+			stepMessage.Payload.Version)
+	}
+
+	// keep the accepted steps beyond the lifetime of the step log
+	saveStepHistory(srv, room, stepMessage.Payload.BaseVersion, stored)
+
+	// map the selections of all clients through the new steps
+	mapRoomPresence(srv, room, steps)
+
+	// inform other instances about the accepted steps
+	publishRoomSync(srv, room, &RoomSyncMessage{
+		BaseVersion: stepMessage.Payload.BaseVersion,
+		Version:     stepMessage.Payload.Version,
+		Steps:       stepMessage.Payload.Steps,
+		Broadcast:   broadcast,
+	})
 }
 
 // ProsemirrorMarkStep information
@@ -460,25 +584,70 @@ type ProsemirrorStepContent struct {
 	Content []*ProsemirrorStepContent `json:"content,omitempty"`
 }
 
-// handleSpecialSteps is used to handle comment and link steps
-func handleSpecialSteps(srv *environment.Services, documentId string, userId string,
-	permission domain.Permission, step json.RawMessage) error {
+// checkSpecialStep will verify that a user with the given permission may
+// send the given step. Nothing is stored, as the step might still be
+// rejected by the step log
+func checkSpecialStep(srv *environment.Services, room *WebsocketRoom, userId string,
+	permission domain.Permission, decoded *ProsemirrorStep) error {
 
-	// user needs edit permissions to change anything
-	if permission != domain.Edit {
-		return fmt.Errorf("no permission to edit the document")
+	// user needs edit or comment permissions to change anything
+	if permission < domain.Comment {
+		return fmt.Errorf("%w: no permission to edit the document", errPermissionDenied)
 	}
 
-	// parse links from marks
-	if bytes.Contains(step, []byte(`"stepType":"addMark"`)) {
+	// users with comment permissions may only add and modify comments
+	if permission == domain.Comment && !isCommentStep(decoded) {
+		return fmt.Errorf("%w: no permission to change the document content",
+			errPermissionDenied)
+	}
+
+	// reviewers may only delete their own comments
+	if permission == domain.Comment && decoded.Type == StepTypeComment &&
+		decoded.Custom.Type == "delete" {
 
-		// parse the mark content
-		var stp ProsemirrorMarkStep
-		err := json.Unmarshal(step, &stp)
+		commentID := resolveCommentID(srv, room, commentStepID(decoded.Custom))
+		comment, err := srv.Postgres.FetchComment(room.DocumentID, commentID)
 		if err != nil {
-			logger.DebugError("could not marshal mark step", err)
 			return err
 		}
+		if comment != nil && comment.AuthorID != userId {
+			return repository.ErrNotCommentAuthor
+		}
+	}
+
+	return nil
+}
+
+// handleSpecialSteps will save the comments and links of the given steps,
+// that were accepted by the step log. Errors are only logged, as the steps
+// are already distributed
+func handleSpecialSteps(srv *environment.Services, room *WebsocketRoom, userId string,
+	permission domain.Permission, steps []*ProsemirrorStep) {
+
+	for _, step := range steps {
+		err := handleSpecialStep(srv, room, userId, permission, step)
+		if err != nil {
+			logger.DebugError("could not handle special step", err,
+				logger.String("documentid", room.DocumentID),
+				logger.String("step-type", string(step.Type)))
+		}
+	}
+}
+
+// handleSpecialStep is used to handle comment and link steps
+func handleSpecialStep(srv *environment.Services, room *WebsocketRoom, userId string,
+	permission domain.Permission, decoded *ProsemirrorStep) error {
+
+	documentId := room.DocumentID
+
+	var err error
+
+	switch decoded.Type {
+
+	// parse links from marks
+	case StepTypeAddMark:
+
+		stp := decoded.Mark
 
 		switch stp.Mark.Type {
 		case "file", "weblink":
@@ -506,15 +675,9 @@ func handleSpecialSteps(srv *environment.Services, documentId string, userId str
 			return err
 		}
 
-	} else if bytes.Contains(step, []byte(`"stepType":"removeMark"`)) {
+	case StepTypeRemoveMark:
 
-		// parse the mark content
-		var stp ProsemirrorMarkStep
-		err := json.Unmarshal(step, &stp)
-		if err != nil {
-			logger.DebugError("could not marshal mark step", err)
-			return err
-		}
+		stp := decoded.Mark
 
 		switch stp.Mark.Type {
 		case "file", "weblink", "process":
@@ -536,15 +699,10 @@ func handleSpecialSteps(srv *environment.Services, documentId string, userId str
 			return err
 		}
 
-	} else if bytes.Contains(step, []byte(`"stepType":"comment"`)) {
+	// handle comments
+	case StepTypeComment:
 
-		// handle comments
-		var stp ProsemirrorCustomStep
-		err := json.Unmarshal(step, &stp)
-		if err != nil {
-			logger.DebugError("could not parse mark step", err)
-			return err
-		}
+		stp := decoded.Custom
 
 		switch stp.Type {
 		case "addComment":
@@ -556,6 +714,12 @@ func handleSpecialSteps(srv *environment.Services, documentId string, userId str
 			}
 			comment.DocumentVersionID = documentId
 			comment.AuthorID = userId
+
+			// assign a server id to comments with a preliminary id
+			if repository.IsPreliminaryComment(comment.ID) {
+				return savePreliminaryComment(srv, room, &comment)
+			}
+
 			err = srv.Postgres.SaveComment(&comment)
 			if err != nil {
 				logger.DebugError("could not add comment", err)
@@ -570,6 +734,7 @@ func handleSpecialSteps(srv *environment.Services, documentId string, userId str
 				return err
 			}
 			comment.UserID = userId
+			comment.ID = resolveCommentID(srv, room, comment.ID)
 			err = srv.Postgres.SetCommentDone(&comment)
 			if err != nil {
 				logger.DebugError("could not set comment as done", err)
@@ -584,7 +749,14 @@ func handleSpecialSteps(srv *environment.Services, documentId string, userId str
 				return err
 			}
 			comment.UserID = userId
-			err = srv.Postgres.DeleteComment(&comment)
+			comment.ID = resolveCommentID(srv, room, comment.ID)
+
+			// reviewers may only delete their own comments
+			if permission == domain.Comment {
+				err = srv.Postgres.DeleteOwnComment(&comment)
+			} else {
+				err = srv.Postgres.DeleteComment(&comment)
+			}
 			if err != nil {
 				logger.DebugError("could not delete comment", err)
 			}
@@ -598,6 +770,7 @@ func handleSpecialSteps(srv *environment.Services, documentId string, userId str
 				return err
 			}
 			reply.AuthorID = userId
+			reply.CommentID = resolveCommentID(srv, room, reply.CommentID)
 			err = srv.Postgres.SaveCommentReply(&reply)
 			if err != nil {
 				logger.DebugError("could not reply to comment", err)
@@ -612,6 +785,7 @@ func handleSpecialSteps(srv *environment.Services, documentId string, userId str
 				return err
 			}
 			reply.UserID = userId
+			reply.CommentID = resolveCommentID(srv, room, reply.CommentID)
 			err = srv.Postgres.DeleteCommentReply(&reply)
 			if err != nil {
 				logger.DebugError("could not add comment", err)
@@ -623,15 +797,10 @@ func handleSpecialSteps(srv *environment.Services, documentId string, userId str
 				logger.String("comment-type", stp.Type))
 		}
 
-	} else if bytes.Contains(step, []byte(`"stepType":"picture"`)) {
+	// handle pictures
+	case StepTypePicture:
 
-		// handle pictures
-		var stp ProsemirrorCustomStep
-		err := json.Unmarshal(step, &stp)
-		if err != nil {
-			logger.DebugError("could not parse custom step", err)
-			return err
-		}
+		stp := decoded.Custom
 
 		switch stp.Type {
 		// tell the image service to create a copy of the given image
@@ -656,62 +825,18 @@ func handleSpecialSteps(srv *environment.Services, documentId string, userId str
 			break
 		}
 
-	} else if bytes.Contains(step, []byte(`"stepType":"replace"`)) {
+	// extract links from the inserted content
+	case StepTypeReplace, StepTypeReplaceAround:
 
-		// parse the step content
-		var stp ProsemirrorReplaceStep
-		err := json.Unmarshal(step, &stp)
-		if err != nil {
-			logger.DebugError("could not unmarshal replace step", err)
-			return err
+		links := replaceStepLinks(decoded.Replace)
+		if len(links) == 0 {
+			return nil
 		}
 
 		go func() {
-
-			links := make(map[string]Link)
-
-			// find pdf block and extract document id to save link for later
-			// access to the document
-			for _, content := range stp.Slice.Content {
-
-				switch content.Type {
-				case "pdf":
-					// ignore pdf blocks without document id
-					if content.Attrs.DocumentID == "" {
-						continue
-					}
-
-					downloadUrl := fmt.Sprintf("/download/process/%s", content.Attrs.DocumentID)
-					links["pdf-"+content.Attrs.DocumentID] = Link{
-						ID:   content.Attrs.DocumentID,
-						Type: "pdf",
-						URL:  downloadUrl,
-						Name: content.Attrs.FileName,
-					}
-					continue
-
-				// handle picture blocks
-				case "picture":
-					// save the image link to the database
-					imageURL := fmt.Sprintf("/image/process/%s", content.Attrs.ImageID)
-					links["image-"+content.Attrs.ImageID] = Link{
-						ID:   content.Attrs.ImageID,
-						Type: "image",
-						URL:  imageURL,
-						Name: "",
-					}
-					continue
-
-				default:
-					// extract all link marks from the content
-					extractLinks(&content, links)
-				}
-
-			}
-
 			// save all links in the database
 			for _, link := range links {
-				err = srv.Postgres.SaveLink(documentId, link.Type,
+				err := srv.Postgres.SaveLink(documentId, link.Type,
 					link.ID, link.URL, link.Name)
 				if err != nil {
 					logger.Error("could not save link", err, zap.Any("link", link))
@@ -723,3 +848,63 @@ func handleSpecialSteps(srv *environment.Services, documentId string, userId str
 	return nil
 
 }
+
+// replaceStepLinks will return all links contained in the content inserted
+// by the given replace step
+func replaceStepLinks(stp *ProsemirrorReplaceStep) map[string]Link {
+
+	links := make(map[string]Link)
+
+	// find pdf block and extract document id to save link for later
+	// access to the document
+	for _, content := range stp.Slice.Content {
+
+		switch content.Type {
+		case "pdf":
+			// ignore pdf blocks without document id
+			if content.Attrs.DocumentID == "" {
+				continue
+			}
+
+			downloadUrl := fmt.Sprintf("/download/process/%s", content.Attrs.DocumentID)
+			links["pdf-"+content.Attrs.DocumentID] = Link{
+				ID:   content.Attrs.DocumentID,
+				Type: "pdf",
+				URL:  downloadUrl,
+				Name: content.Attrs.FileName,
+			}
+			continue
+
+		// handle picture blocks
+		case "picture":
+			// save the image link to the database
+			imageURL := fmt.Sprintf("/image/process/%s", content.Attrs.ImageID)
+			links["image-"+content.Attrs.ImageID] = Link{
+				ID:   content.Attrs.ImageID,
+				Type: "image",
+				URL:  imageURL,
+				Name: "",
+			}
+			continue
+
+		default:
+			// extract all link marks from the content
+			extractLinks(&content, links)
+		}
+	}
+
+	return links
+}
+
+// isCommentStep indicates if the given step does only add or modify comments
+// without changing the content of the document
+func isCommentStep(step *ProsemirrorStep) bool {
+	switch step.Type {
+	case StepTypeComment:
+		return true
+	case StepTypeAddMark, StepTypeRemoveMark:
+		return step.Mark.Mark.Type == "comment"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,403 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// sendTestSteps will send the given steps of the client based on the given
+// version to the room
+func sendTestSteps(t *testing.T, room *WebsocketRoom, client *WebsocketClient, clientID int,
+	version int64, steps ...json.RawMessage) {
+	t.Helper()
+
+	sendTestMessage(t, room, client, MessageTypeProsemirrorSteps, &ProsemirrorStepMessage{
+		DocumentID:      testDocumentID,
+		DocumentVersion: version,
+		ClientID:        clientID,
+		Steps:           steps,
+	})
+}
+
+// expectTestSteps will wait for steps sent to the client and check their
+// versions and number
+func expectTestSteps(t *testing.T, client *WebsocketClient, baseVersion, version int64,
+	count int) *ProsemirrorStepResponse {
+	t.Helper()
+
+	responses := expectResponses(t, client, MessageTypeProsemirrorSteps)
+
+	var response ProsemirrorStepResponse
+	decodeTestPayload(t, responses[MessageTypeProsemirrorSteps], &response.Payload)
+	if response.Payload.BaseVersion != baseVersion || response.Payload.Version != version ||
+		len(response.Payload.Steps) != count || len(response.Payload.ClientIDs) != count {
+		t.Fatalf("expected %d steps from version %d to %d, got %s", count, baseVersion,
+			version, responses[MessageTypeProsemirrorSteps])
+	}
+
+	return &response
+}
+
+// expectStepLogVersion will check the version of the step log
+func expectStepLogVersion(t *testing.T, steps *repository.MemoryStepStore, want int64) {
+	t.Helper()
+
+	version, err := steps.Version(testDocumentID)
+	if err != nil {
+		t.Fatalf("could not fetch step log version: %v", err)
+	}
+	if version != want {
+		t.Errorf("expected step log version %d, got %d", want, version)
+	}
+}
+
+func TestAppendSteps(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	other := newTestClient("other", domain.Edit)
+	initTestClient(t, room, other, 0)
+
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"))
+
+	// the steps are broadcast to all clients of the room, including the
+	// sender to confirm the steps
+	for _, client := range []*WebsocketClient{editor, other} {
+		response := expectTestSteps(t, client, 0, 1, 1)
+		if response.Payload.ClientIDs[0] != 1 {
+			t.Errorf("expected steps of client 1, got %v", response.Payload.ClientIDs)
+		}
+	}
+
+	expectStepLogVersion(t, steps, 1)
+
+	stored, err := steps.Range(testDocumentID, 0)
+	if err != nil {
+		t.Fatalf("could not fetch steps: %v", err)
+	}
+	if len(stored) != 1 || stored[0].UserID != editor.UserID || stored[0].ClientID != 1 {
+		t.Errorf("unexpected step log: %v", stored)
+	}
+}
+
+func TestStepMetrics(t *testing.T) {
+
+	room := newTestRoom(t, newTestServices(t, repository.NewMemoryStepStore(),
+		repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	// the counters are shared by all tests, i.e. only the changes are checked
+	accepted := testutil.ToFloat64(metrics.StepsAccepted)
+	reloads := testutil.ToFloat64(metrics.Reloads)
+	invalid := testutil.ToFloat64(metrics.Errors.WithLabelValues(string(ErrorCodeInvalidStep)))
+
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"), testReplaceStep(2, "b"))
+	expectTestSteps(t, editor, 0, 2, 2)
+
+	// steps that can not be applied are rejected
+	sendTestSteps(t, room, editor, 1, 2, testReplaceStep(100, "c"))
+	expectTestError(t, editor, ErrorCodeInvalidStep)
+
+	// clients with a newer version than the room must reload
+	sendTestSteps(t, room, editor, 1, 5, testReplaceStep(1, "d"))
+	expectResponses(t, editor, MessageTypeProssemirrorReload)
+
+	if got := testutil.ToFloat64(metrics.StepsAccepted) - accepted; got != 2 {
+		t.Errorf("expected 2 accepted steps, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.Reloads) - reloads; got != 1 {
+		t.Errorf("expected 1 reload, got %v", got)
+	}
+	got := testutil.ToFloat64(metrics.Errors.WithLabelValues(string(ErrorCodeInvalidStep))) - invalid
+	if got != 1 {
+		t.Errorf("expected 1 invalid step error, got %v", got)
+	}
+}
+
+func TestAppendConflictingSteps(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	other := newTestClient("other", domain.Edit)
+	initTestClient(t, room, other, 0)
+
+	sendTestSteps(t, room, other, 2, 0, testReplaceStep(1, "b"))
+	expectTestSteps(t, other, 0, 1, 1)
+	expectTestSteps(t, editor, 0, 1, 1)
+
+	// steps based on an outdated version are not accepted, the sender
+	// receives the missing steps instead
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"))
+
+	response := expectTestSteps(t, editor, 0, 1, 1)
+	if response.Payload.ClientIDs[0] != 2 {
+		t.Errorf("expected the steps of client 2, got %v", response.Payload.ClientIDs)
+	}
+
+	expectStepLogVersion(t, steps, 1)
+
+	// the rebased steps are accepted
+	sendTestSteps(t, room, editor, 1, 1, testReplaceStep(1, "a"))
+	expectTestSteps(t, editor, 1, 2, 1)
+	expectTestSteps(t, other, 1, 2, 1)
+
+	expectStepLogVersion(t, steps, 2)
+}
+
+func TestStepLogConflict(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+	syncTestRoom(t, room, editor)
+
+	// steps appended elsewhere, without informing the room
+	_, err := steps.Append(testDocumentID, 0, []repository.StoredStep{
+		{Step: testReplaceStep(1, "b"), ClientID: 2, UserID: "remote"},
+	}, roomExpiration)
+	if err != nil {
+		t.Fatalf("could not append steps: %v", err)
+	}
+
+	// the steps are not accepted and the sender receives the missing steps
+	// before the error
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"))
+
+	response := expectTestSteps(t, editor, 0, 1, 1)
+	if response.Payload.ClientIDs[0] != 2 {
+		t.Errorf("expected the steps of client 2, got %v", response.Payload.ClientIDs)
+	}
+
+	responses := expectResponses(t, editor, MessageTypeProsemirrorError)
+
+	var conflict ProsemirrorErrorResponse
+	decodeTestPayload(t, responses[MessageTypeProsemirrorError], &conflict.Payload)
+	if conflict.Payload.BaseVersion != 0 || conflict.Payload.Version != 1 {
+		t.Errorf("unexpected error response: %s", responses[MessageTypeProsemirrorError])
+	}
+
+	// the rebased steps are accepted
+	sendTestSteps(t, room, editor, 1, 1, testReplaceStep(1, "a"))
+	expectTestSteps(t, editor, 1, 2, 1)
+
+	expectStepLogVersion(t, steps, 2)
+}
+
+func TestUnknownSteps(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	// steps of types unknown to the server are passed through
+	sendTestSteps(t, room, editor, 1, 0,
+		json.RawMessage(`{"stepType":"addNodeMark","pos":0,"mark":{"type":"em"}}`),
+		testReplaceStep(1, "a"))
+	expectTestSteps(t, editor, 0, 2, 2)
+
+	// the room continues without knowing the document content
+	sendTestSteps(t, room, editor, 1, 2, testReplaceStep(1, "b"))
+	expectTestSteps(t, editor, 2, 3, 1)
+
+	expectStepLogVersion(t, steps, 3)
+}
+
+// failingStepStore is a step log that can not be reset
+type failingStepStore struct {
+	*repository.MemoryStepStore
+}
+
+func (s *failingStepStore) Reset(documentID string, version int64) error {
+	return errors.New("step log not available")
+}
+
+func TestMissingStepLog(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"))
+	expectTestSteps(t, editor, 0, 1, 1)
+
+	// the step log was lost, i.e. because redis was restarted
+	err := steps.Expire(testDocumentID, -time.Second)
+	if err != nil {
+		t.Fatalf("could not expire step log: %v", err)
+	}
+
+	// a new step log is started at the version of the room
+	sendTestSteps(t, room, editor, 1, 1, testReplaceStep(1, "b"))
+	expectTestSteps(t, editor, 1, 2, 1)
+
+	expectStepLogVersion(t, steps, 2)
+}
+
+func TestFailedStepLogReset(t *testing.T) {
+
+	steps := &failingStepStore{MemoryStepStore: repository.NewMemoryStepStore()}
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	// the room can not start a step log for the new document
+	editor := newTestClient("editor", domain.Edit)
+	responses := initTestClient(t, room, editor, 0, MessageTypeProsemirrorError)
+
+	var response ProsemirrorErrorResponse
+	decodeTestPayload(t, responses[MessageTypeProsemirrorError], &response.Payload)
+	if response.Payload.Code != ErrorCodeStorageFailure {
+		t.Errorf("expected storage failure, got %s", response.Payload.Code)
+	}
+
+	// the room is closed and the client is disconnected
+	select {
+	case <-room.Done:
+	case <-time.After(testTimeout):
+		t.Fatal("room was not closed")
+	}
+
+	select {
+	case <-editor.Close:
+	default:
+		t.Error("client was not disconnected")
+	}
+}
+
+func TestCatchUpSteps(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"), testReplaceStep(2, "b"))
+	expectTestSteps(t, editor, 0, 2, 2)
+
+	// clients joining with an older version receive the missing steps
+	late := newTestClient("late", domain.Edit)
+	initTestClient(t, room, late, 0)
+	expectTestSteps(t, late, 0, 2, 2)
+
+	// the caught up client can send steps based on the current version
+	sendTestSteps(t, room, late, 3, 2, testReplaceStep(3, "c"))
+	expectTestSteps(t, late, 2, 3, 1)
+	expectTestSteps(t, editor, 2, 3, 1)
+
+	expectStepLogVersion(t, steps, 3)
+}
+
+func TestRemoteSteps(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	broker := repository.NewMemoryBroker()
+	room := newTestRoom(t, newTestServices(t, steps, broker, "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+	syncTestRoom(t, room, editor)
+
+	// another instance appended three steps, but the room only receives the
+	// sync messages of the first and the last step
+	for i, text := range []string{"b", "c", "d"} {
+		_, err := steps.Append(testDocumentID, int64(i), []repository.StoredStep{
+			{Step: testReplaceStep(1, text), ClientID: 2, UserID: "remote"},
+		}, roomExpiration)
+		if err != nil {
+			t.Fatalf("could not append steps: %v", err)
+		}
+	}
+
+	for _, version := range []int64{0, 2} {
+		broadcast, err := json.Marshal(&ProsemirrorStepResponse{Type: MessageTypeProsemirrorSteps})
+		if err != nil {
+			t.Fatalf("could not encode broadcast: %v", err)
+		}
+
+		sync, err := json.Marshal(&RoomSyncMessage{
+			Instance:    "b",
+			BaseVersion: version,
+			Version:     version + 1,
+			Steps:       []json.RawMessage{testReplaceStep(1, "x")},
+			Broadcast:   broadcast,
+		})
+		if err != nil {
+			t.Fatalf("could not encode sync message: %v", err)
+		}
+
+		err = broker.Publish(testDocumentID, sync)
+		if err != nil {
+			t.Fatalf("could not publish sync message: %v", err)
+		}
+	}
+
+	// the steps based on the version of the room are forwarded directly,
+	// the missed steps are replayed from the step log
+	expectResponses(t, editor, MessageTypeProsemirrorSteps)
+	response := expectTestSteps(t, editor, 1, 3, 2)
+	if response.Payload.ClientIDs[0] != 2 {
+		t.Errorf("expected the steps of client 2, got %v", response.Payload.ClientIDs)
+	}
+
+	// the room continues at the version of the step log
+	sendTestSteps(t, room, editor, 1, 3, testReplaceStep(1, "a"))
+	expectTestSteps(t, editor, 3, 4, 1)
+
+	expectStepLogVersion(t, steps, 4)
+}
+
+func TestReloadClients(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	// clients with a version unknown to the room must reload
+	sendTestSteps(t, room, editor, 1, 5, testReplaceStep(1, "a"))
+	responses := expectResponses(t, editor, MessageTypeProssemirrorReload)
+
+	var reload ProsemirrorInfoResponse
+	decodeTestPayload(t, responses[MessageTypeProssemirrorReload], &reload.Payload)
+	if reload.Payload.BaseVersion != 5 || reload.Payload.Version != 0 {
+		t.Errorf("unexpected reload response: %s", responses[MessageTypeProssemirrorReload])
+	}
+
+	// a client with a newer version resets the step log, the steps missing
+	// for older clients are not available anymore
+	newer := newTestClient("newer", domain.Edit)
+	initTestClient(t, room, newer, 5)
+
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"))
+	responses = expectResponses(t, editor, MessageTypeProssemirrorReload)
+
+	decodeTestPayload(t, responses[MessageTypeProssemirrorReload], &reload.Payload)
+	if reload.Payload.BaseVersion != 0 || reload.Payload.Version != 5 {
+		t.Errorf("unexpected reload response: %s", responses[MessageTypeProssemirrorReload])
+	}
+
+	expectStepLogVersion(t, steps, 5)
+}
@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/pkg/logger"
+)
+
+// initializeRoomSchema will parse the document schema of the room. The
+// schema is sent by the first client registering in the room
+func initializeRoomSchema(room *WebsocketRoom, payload *ProsemirrorInitMessage) {
+
+	schema := payload.DocumentSchema
+
+	room.DocumentSchema = schema
+	room.SchemaHash = clientSchemaHash(payload)
+	room.SchemaVersion = payload.SchemaVersion
+	room.Schema = nil
+
+	if len(schema) == 0 {
+		return
+	}
+
+	parsed, err := model.ParseSchema(schema)
+	if err != nil {
+		logger.DebugError("could not parse document schema", err,
+			logger.String("documentid", room.DocumentID))
+		return
+	}
+
+	room.Schema = parsed
+}
+
+// initializeRoomDocument will set the document content of the room from
+// the content sent by a client. The content is only accepted if it
+// corresponds to the current version of the room
+func initializeRoomDocument(room *WebsocketRoom, payload *ProsemirrorInitMessage) {
+
+	if room.Schema == nil || len(payload.Document) == 0 {
+		return
+	}
+
+	if payload.DocumentVersion != room.DocumentVersion {
+		return
+	}
+
+	var raw model.NodeJSON
+	err := json.Unmarshal(payload.Document, &raw)
+	if err != nil {
+		logger.DebugError("could not decode document content", err,
+			logger.String("documentid", room.DocumentID))
+		return
+	}
+
+	room.Document = parseRoomDocument(room, raw)
+}
+
+// parseRoomDocument will parse the given document content with the schema
+// of the room. Nil is returned if the content does not match the schema
+func parseRoomDocument(room *WebsocketRoom, raw model.NodeJSON) *model.Node {
+
+	if room.Schema == nil {
+		return nil
+	}
+
+	doc, err := model.NodeFromJSON(room.Schema, raw)
+	if err == nil {
+		err = doc.Check()
+	}
+	if err != nil {
+		logger.DebugError("could not parse document content", err,
+			logger.String("documentid", room.DocumentID))
+		return nil
+	}
+
+	return doc
+}
+
+// applyProsemirrorSteps will apply the given decoded steps to the document
+// of the room and return the resulting document. The room itself is not
+// modified. Nil is returned if the room does not know its document yet
+func applyProsemirrorSteps(room *WebsocketRoom, steps []*ProsemirrorStep) (*model.Node, error) {
+
+	if room.Schema == nil || room.Document == nil {
+		return nil, nil
+	}
+
+	doc := room.Document
+
+	var err error
+	for i, step := range steps {
+		doc, err = step.Step.Apply(doc)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply step %d: %w", i, err)
+		}
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"encoding/json"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"dkfbasel.ch/orca/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// RoomSyncMessage is used to inform the rooms of other instances about
+// changes of a document
+type RoomSyncMessage struct {
+	Instance    string            `json:"instance"`
+	Reset       bool              `json:"reset,omitempty"`
+	BaseVersion int64             `json:"base_version"`
+	Version     int64             `json:"version"`
+	Steps       []json.RawMessage `json:"steps,omitempty"`
+	Broadcast   json.RawMessage   `json:"broadcast,omitempty"`
+	Comments    []json.RawMessage `json:"comments,omitempty"`
+	Presence    *PresenceSync     `json:"presence,omitempty"`
+}
+
+// publishRoomSync will inform other instances about the given change
+func publishRoomSync(srv *environment.Services, room *WebsocketRoom, sync *RoomSyncMessage) {
+
+	sync.Instance = srv.Instance
+
+	payload, err := json.Marshal(sync)
+	if err != nil {
+		logger.DebugError("could not encode room sync message", err)
+		return
+	}
+
+	err = srv.Broker.Publish(room.DocumentID, payload)
+	if err != nil {
+		logger.DebugError("could not publish room sync message", err,
+			logger.String("documentid", room.DocumentID))
+	}
+}
+
+// handleRemoteMessage will handle changes of the document that were
+// accepted by other instances
+func handleRemoteMessage(srv *environment.Services, room *WebsocketRoom, payload []byte) {
+
+	var sync RoomSyncMessage
+	err := json.Unmarshal(payload, &sync)
+	if err != nil {
+		logger.DebugError("could not decode room sync message", err)
+		return
+	}
+
+	// ignore our own messages
+	if sync.Instance == srv.Instance {
+		return
+	}
+
+	// update the presence of the clients on other instances
+	if sync.Presence != nil {
+		handleRemotePresence(srv, room, sync.Instance, sync.Presence)
+		return
+	}
+
+	// ignore rooms that are not initialized yet, as they will fetch their
+	// version from the step log on initialization
+	if room.DocumentVersion == -1 {
+		return
+	}
+
+	// forward changed comment threads to all clients
+	if len(sync.Comments) > 0 {
+		for _, comment := range sync.Comments {
+			broadcastRoom(room, comment)
+		}
+		return
+	}
+
+	// the step log was reset on another instance
+	if sync.Reset {
+		room.DocumentVersion = sync.Version
+		room.Document = nil
+		return
+	}
+
+	// nothing to do if the steps are already known
+	if sync.Version <= room.DocumentVersion {
+		return
+	}
+
+	// apply the steps directly if they are based on the current version
+	if sync.BaseVersion == room.DocumentVersion {
+		steps := applyAcceptedSteps(room, sync.Steps)
+
+		room.DocumentVersion = sync.Version
+		broadcastRoom(room, sync.Broadcast)
+		mapRoomPresence(srv, room, steps)
+		return
+	}
+
+	// some steps were missed, fetch all steps that are not yet known from
+	// the step log and send them to the clients
+	logger.Debug("missing remote steps",
+		zap.Int64("room-version", room.DocumentVersion),
+		zap.Int64("remote-version", sync.BaseVersion))
+
+	catchUpRoom(srv, room, sync.Version)
+}
+
+// catchUpRoom will apply all steps of the step log up to the given version,
+// that the room does not know yet, and send them to all clients of the room.
+// The clients are asked to reload if the steps are not available anymore
+func catchUpRoom(srv *environment.Services, room *WebsocketRoom, version int64) {
+
+	if version <= room.DocumentVersion {
+		return
+	}
+
+	steps, err := replaySteps(srv, room.DocumentID, room.DocumentVersion, version)
+	if err != nil {
+		logger.DebugError("could not fetch missing steps from step log", err,
+			logger.String("documentid", room.DocumentID))
+
+		if err == repository.ErrStepsUnavailable || err == repository.ErrNoStepLog {
+			// inform all clients to reload the document
+			response := ProsemirrorInfoResponse{}
+			response.Type = MessageTypeProssemirrorReload
+			metrics.Reloads.Inc()
+			response.Payload.BaseVersion = room.DocumentVersion
+			response.Payload.Version = version
+
+			room.DocumentVersion = version
+			room.Document = nil
+
+			msg, err := json.Marshal(&response)
+			if err != nil {
+				logger.DebugError("could not encode reload page response", err)
+				return
+			}
+			broadcastRoom(room, msg)
+		}
+		return
+	}
+
+	if len(steps) == 0 {
+		return
+	}
+
+	response := ProsemirrorStepResponse{}
+	response.Type = MessageTypeProsemirrorSteps
+	response.Payload.BaseVersion = room.DocumentVersion
+	response.Payload.Version = room.DocumentVersion + int64(len(steps))
+	response.Payload.Steps = make([]json.RawMessage, len(steps))
+	response.Payload.ClientIDs = make([]int, len(steps))
+	for i := range steps {
+		response.Payload.Steps[i] = steps[i].Step
+		response.Payload.ClientIDs[i] = steps[i].ClientID
+	}
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		logger.DebugError("could not encode steps response", err)
+		return
+	}
+
+	decoded := applyAcceptedSteps(room, response.Payload.Steps)
+
+	room.DocumentVersion = response.Payload.Version
+	broadcastRoom(room, msg)
+	mapRoomPresence(srv, room, decoded)
+}
+
+// applyAcceptedSteps will decode the given steps, that were already accepted
+// by the step log, and apply them to the document of the room. The document
+// is dropped if the steps do not apply, as it does not match the step log
+func applyAcceptedSteps(room *WebsocketRoom, raw []json.RawMessage) []*ProsemirrorStep {
+
+	steps, err := decodeProsemirrorSteps(room, raw)
+	if err == nil {
+		room.Document, err = applyProsemirrorSteps(room, steps)
+	}
+
+	if err != nil {
+		logger.DebugError("accepted steps could not be applied", err,
+			logger.String("documentid", room.DocumentID))
+		room.Document = nil
+		return nil
+	}
+
+	return steps
+}
@@ -0,0 +1,212 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"dkfbasel.ch/orca/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// save a snapshot of the document after the given number of steps
+const snapshotStepInterval = 100
+
+// save a snapshot of the document if it was not changed for the given time
+const snapshotIdleInterval = time.Second * 30
+
+// saveRoomSnapshot will persist the current document content of the room,
+// if it changed since the last snapshot
+func saveRoomSnapshot(srv *environment.Services, room *WebsocketRoom) {
+
+	snapshot := roomSnapshot(room)
+	if snapshot == nil {
+		return
+	}
+
+	if saveSnapshot(srv, snapshot) {
+		room.SnapshotVersion = snapshot.Version
+	}
+}
+
+// roomSnapshot will return a snapshot of the current document content of
+// the room. Nil is returned if the content did not change since the last
+// snapshot or if the content is unknown
+func roomSnapshot(room *WebsocketRoom) *domain.DocumentSnapshot {
+
+	if room.Document == nil || room.DocumentVersion <= room.SnapshotVersion {
+		return nil
+	}
+
+	content, err := json.Marshal(room.Document.ToJSON())
+	if err != nil {
+		logger.DebugError("could not encode document snapshot", err,
+			logger.String("documentid", room.DocumentID))
+		return nil
+	}
+
+	return &domain.DocumentSnapshot{
+		DocumentID: room.DocumentID,
+		Version:    room.DocumentVersion,
+		Content:    content,
+	}
+}
+
+// saveSnapshot will persist the given snapshot and report if it was saved.
+// The snapshot does not reference the room, i.e. it may be saved outside
+// of the room
+func saveSnapshot(srv *environment.Services, snapshot *domain.DocumentSnapshot) bool {
+
+	err := srv.Postgres.SaveSnapshot(snapshot)
+	if err != nil {
+		logger.DebugError("could not save document snapshot", err,
+			logger.String("documentid", snapshot.DocumentID))
+		return false
+	}
+
+	logger.Debug("document snapshot saved",
+		zap.Int64("room-version", snapshot.Version),
+		zap.String("documentid", snapshot.DocumentID))
+
+	return true
+}
+
+// loadRoomState will initialize the version and the content of the room
+// from the latest snapshot of the document and the tail of the step log.
+// False is returned if neither a snapshot nor a step log is available
+func loadRoomState(srv *environment.Services, room *WebsocketRoom) bool {
+
+	snapshot, err := srv.Postgres.FetchSnapshot(room.DocumentID)
+	if err != nil {
+		logger.DebugError("could not fetch document snapshot", err,
+			logger.String("documentid", room.DocumentID))
+	}
+
+	logVersion, logErr := srv.Steps.Version(room.DocumentID)
+	if logErr != nil && logErr != repository.ErrNoStepLog {
+		logger.DebugError("could not fetch document version from step store", logErr,
+			logger.String("documentid", room.DocumentID))
+	}
+
+	// continue at the version of the persisted step history if the step
+	// log does not exist anymore, i.e. because it expired
+	if logErr == repository.ErrNoStepLog {
+		logVersion, logErr = restoreStepLog(srv, room)
+	}
+
+	// use the version of the step log if there is no snapshot available
+	if snapshot == nil {
+		if logErr != nil {
+			return false
+		}
+		room.DocumentVersion = logVersion
+		return true
+	}
+
+	room.SnapshotVersion = snapshot.Version
+
+	// start a new step log at the snapshot version, if the step log does not
+	// exist anymore or if it is behind the snapshot
+	if logErr != nil || logVersion < snapshot.Version {
+		room.DocumentVersion = snapshot.Version
+		room.Document = parseSnapshot(room, snapshot)
+		resetStepLog(srv, room, room.DocumentVersion)
+		return true
+	}
+
+	room.DocumentVersion = logVersion
+	room.Document = snapshotDocument(srv, room, snapshot)
+
+	return true
+}
+
+// loadRoomDocument will build the document content of the current version
+// of the room from the latest snapshot, i.e. if the snapshot could not be
+// parsed before as the schema of the room was not known yet
+func loadRoomDocument(srv *environment.Services, room *WebsocketRoom) {
+
+	snapshot, err := srv.Postgres.FetchSnapshot(room.DocumentID)
+	if err != nil {
+		logger.DebugError("could not fetch document snapshot", err,
+			logger.String("documentid", room.DocumentID))
+		return
+	}
+
+	if snapshot == nil || snapshot.Version > room.DocumentVersion {
+		return
+	}
+
+	room.Document = snapshotDocument(srv, room, snapshot)
+}
+
+// snapshotDocument will parse the given snapshot and apply all steps that
+// were accepted after the snapshot was taken up to the version of the room.
+// Nil is returned if the document content can not be built
+func snapshotDocument(srv *environment.Services, room *WebsocketRoom,
+	snapshot *domain.DocumentSnapshot) *model.Node {
+
+	doc := parseSnapshot(room, snapshot)
+	if doc == nil || snapshot.Version == room.DocumentVersion {
+		return doc
+	}
+
+	steps, err := replaySteps(srv, room.DocumentID, snapshot.Version, room.DocumentVersion)
+	if err != nil {
+		logger.DebugError("could not fetch steps after snapshot", err,
+			logger.String("documentid", room.DocumentID))
+		return nil
+	}
+
+	decoded, err := decodeStoredSteps(room, steps)
+	if err != nil {
+		logger.DebugError("could not decode steps after snapshot", err,
+			logger.String("documentid", room.DocumentID))
+		return nil
+	}
+
+	for i, step := range decoded {
+		doc, err = step.Step.Apply(doc)
+		if err != nil {
+			logger.DebugError("could not apply steps to snapshot", err,
+				logger.String("documentid", room.DocumentID), zap.Int("step", i))
+			return nil
+		}
+	}
+
+	return doc
+}
+
+// parseSnapshot will parse the content of the given snapshot with the
+// schema of the room
+func parseSnapshot(room *WebsocketRoom, snapshot *domain.DocumentSnapshot) *model.Node {
+
+	if room.Schema == nil {
+		return nil
+	}
+
+	doc, err := parseSnapshotContent(room.Schema, snapshot)
+	if err != nil {
+		logger.DebugError("could not parse document snapshot", err,
+			logger.String("documentid", room.DocumentID))
+		return nil
+	}
+
+	return doc
+}
+
+// parseSnapshotContent will parse the content of the given snapshot with
+// the given schema
+func parseSnapshotContent(schema *model.Schema, snapshot *domain.DocumentSnapshot) (*model.Node, error) {
+
+	var raw model.NodeJSON
+	err := json.Unmarshal(snapshot.Content, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode document snapshot: %w", err)
+	}
+
+	return model.NodeFromJSON(schema, raw)
+}
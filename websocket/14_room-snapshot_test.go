@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+)
+
+// queueTestSnapshot will let the next request of the latest snapshot return
+// the test document at the given version
+func queueTestSnapshot(t *testing.T, instance string, version int64) {
+	t.Helper()
+
+	queueTestRows(t, instance, []driver.Value{testDocumentID},
+		[]string{"document_id", "version", "content", "created"},
+		[]driver.Value{testDocumentID, version, []byte(testDocument), time.Now()})
+}
+
+func TestSnapshotWithoutSchema(t *testing.T) {
+
+	// the step log contains a step accepted after the snapshot
+	steps := repository.NewMemoryStepStore()
+	err := steps.Reset(testDocumentID, 0)
+	if err != nil {
+		t.Fatalf("could not reset step log: %v", err)
+	}
+	_, err = steps.Append(testDocumentID, 0, []repository.StoredStep{
+		{Step: testReplaceStep(1, "a"), ClientID: 2, UserID: "user-other"},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("could not append step: %v", err)
+	}
+
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	// the snapshot can not be parsed without the schema of an editor
+	queueTestSnapshot(t, "a", 0)
+	viewer := newTestClient("viewer", domain.View)
+	initTestClient(t, room, viewer, 1)
+	syncTestRoom(t, room, viewer)
+
+	// the snapshot is parsed when an editor provides the schema, the outdated
+	// content sent by the editor is not used
+	queueTestSnapshot(t, "a", 0)
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 1)
+
+	sendTestMessage(t, room, editor, MessageTypeProsemirrorVersion,
+		&ProsemirrorVersionMessage{Version: 1})
+	expectDocumentVersion(t, editor, 1, testDocumentWithText("ahello"))
+
+	// steps are validated against the content built from the snapshot, i.e.
+	// the end of the text is at position 7
+	sendTestSteps(t, room, editor, 1, 1, testReplaceStep(7, "b"))
+	expectTestSteps(t, editor, 1, 2, 1)
+}
@@ -0,0 +1,287 @@
+package websocket
+
+import (
+	"encoding/json"
+	"time"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/transform"
+	"dkfbasel.ch/orca/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// send changed selections at most once during the given interval, as
+// clients send their selection on every change
+const presenceInterval = time.Millisecond * 100
+
+// events sent with presence responses
+const (
+	presenceJoin   = "join"
+	presenceLeave  = "leave"
+	presenceUpdate = "update"
+)
+
+// Presence holds the selection of a single client in the room. Positions
+// are set to -1 as long as the selection of the client is unknown
+type Presence struct {
+	ClientID string `json:"clientId"`
+	UserID   string `json:"userId"`
+	Anchor   int    `json:"anchor"`
+	Head     int    `json:"head"`
+}
+
+// PresenceSync informs the rooms of other instances about the presence of
+// all clients of the room on the sending instance
+type PresenceSync struct {
+	Event    string      `json:"event"`
+	ClientID string      `json:"clientId,omitempty"`
+	UserID   string      `json:"userId,omitempty"`
+	Users    []*Presence `json:"users"`
+}
+
+// ProsemirrorPresenceMessage is sent by clients to share their selection
+type ProsemirrorPresenceMessage struct {
+	DocumentVersion int64 `json:"version"`
+	Anchor          int   `json:"anchor"`
+	Head            int   `json:"head"`
+}
+
+// ProsemirrorPresenceResponse informs all clients about the presence of
+// the users in the room
+type ProsemirrorPresenceResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		Event    string      `json:"event"`
+		ClientID string      `json:"clientId,omitempty"`
+		UserID   string      `json:"userId,omitempty"`
+		Version  int64       `json:"version"`
+		Users    []*Presence `json:"users"`
+	} `json:"payload"`
+}
+
+// joinRoomPresence will add the client to the presence of the room
+func joinRoomPresence(srv *environment.Services, room *WebsocketRoom,
+	client *WebsocketClient) {
+
+	room.Presence[client] = &Presence{
+		ClientID: client.ID,
+		UserID:   client.UserID,
+		Anchor:   -1,
+		Head:     -1,
+	}
+	broadcastPresence(room, presenceJoin, client.ID, client.UserID)
+	publishRoomPresence(srv, room, presenceJoin, client.ID, client.UserID)
+}
+
+// leaveRoomPresence will remove the client from the presence of the room
+func leaveRoomPresence(srv *environment.Services, room *WebsocketRoom,
+	client *WebsocketClient) {
+
+	if _, ok := room.Presence[client]; !ok {
+		return
+	}
+	delete(room.Presence, client)
+	broadcastPresence(room, presenceLeave, client.ID, client.UserID)
+	publishRoomPresence(srv, room, presenceLeave, client.ID, client.UserID)
+}
+
+// broadcastPresence will send the presence of all clients in the room,
+// including the clients of other instances, to all clients. The client
+// that triggered the event may be empty
+func broadcastPresence(room *WebsocketRoom, event string, clientID string, userID string) {
+
+	response := ProsemirrorPresenceResponse{}
+	response.Type = MessageTypeProsemirrorPresence
+	response.Payload.Event = event
+	response.Payload.ClientID = clientID
+	response.Payload.UserID = userID
+	response.Payload.Version = room.DocumentVersion
+	response.Payload.Users = make([]*Presence, 0, len(room.Presence))
+
+	for _, presence := range room.Presence {
+		response.Payload.Users = append(response.Payload.Users, presence)
+	}
+
+	for _, users := range room.RemotePresence {
+		response.Payload.Users = append(response.Payload.Users, users...)
+	}
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		logger.DebugError("could not encode presence response", err)
+		return
+	}
+
+	broadcastRoom(room, msg)
+}
+
+// publishRoomPresence will inform other instances about the presence of
+// the clients of the room on this instance
+func publishRoomPresence(srv *environment.Services, room *WebsocketRoom, event string,
+	clientID string, userID string) {
+
+	sync := PresenceSync{
+		Event:    event,
+		ClientID: clientID,
+		UserID:   userID,
+		Users:    make([]*Presence, 0, len(room.Presence)),
+	}
+
+	for _, presence := range room.Presence {
+		sync.Users = append(sync.Users, presence)
+	}
+
+	publishRoomSync(srv, room, &RoomSyncMessage{Presence: &sync})
+}
+
+// handleRemotePresence will replace the presence of the clients of the
+// given instance and inform all local clients about it
+func handleRemotePresence(srv *environment.Services, room *WebsocketRoom, instance string,
+	sync *PresenceSync) {
+
+	_, known := room.RemotePresence[instance]
+
+	if len(sync.Users) == 0 {
+		delete(room.RemotePresence, instance)
+	} else {
+		room.RemotePresence[instance] = sync.Users
+	}
+
+	// share the presence of our clients with instances that were not
+	// aware of the document until now
+	if !known && sync.Event == presenceJoin && len(room.Presence) > 0 {
+		publishRoomPresence(srv, room, presenceUpdate, "", "")
+	}
+
+	broadcastPresence(room, sync.Event, sync.ClientID, sync.UserID)
+}
+
+// handleProsemirrorPresenceMessage will update the selection of the sender
+// and inform all other clients about it
+func handleProsemirrorPresenceMessage(srv *environment.Services, room *WebsocketRoom,
+	message *Message) {
+
+	var payload ProsemirrorPresenceMessage
+	err := json.Unmarshal(message.Payload, &payload)
+	if err != nil {
+		logger.DebugError("could not decode presence message payload", err)
+		return
+	}
+
+	presence, ok := room.Presence[message.Client]
+	if !ok {
+		return
+	}
+
+	// drop selections that are not based on the current version. the steps
+	// missing for the client are on their way and the client will send its
+	// mapped selection after receiving them
+	if payload.DocumentVersion != room.DocumentVersion {
+		logger.Debug("presence version does not match room version",
+			zap.Int64("message-version", payload.DocumentVersion),
+			zap.Int64("room-version", room.DocumentVersion))
+		return
+	}
+
+	anchor, head := payload.Anchor, payload.Head
+
+	// make sure that the selection is inside of the document
+	if room.Document != nil {
+		size := room.Document.Content.Size()
+		if anchor < 0 || anchor > size || head < 0 || head > size {
+			logger.Debug("presence selection outside of document")
+			return
+		}
+	}
+
+	presence.Anchor = anchor
+	presence.Head = head
+
+	schedulePresenceUpdate(room, true)
+}
+
+// schedulePresenceUpdate will inform all clients about the selections after
+// the presence interval, so that all changes during the interval are sent
+// with a single update. Other instances are only informed if the selections
+// of our own clients changed
+func schedulePresenceUpdate(room *WebsocketRoom, changed bool) {
+
+	if changed {
+		room.PresenceChanged = true
+	}
+
+	if room.PresenceUpdate == nil {
+		room.PresenceUpdate = time.After(presenceInterval)
+	}
+}
+
+// sendRoomPresence will send the selections of all clients to the clients
+// of the room and inform other instances if our selections changed
+func sendRoomPresence(srv *environment.Services, room *WebsocketRoom) {
+
+	room.PresenceUpdate = nil
+	broadcastPresence(room, presenceUpdate, "", "")
+
+	if room.PresenceChanged {
+		room.PresenceChanged = false
+		publishRoomPresence(srv, room, presenceUpdate, "", "")
+	}
+}
+
+// mapRoomPresence will map the selections of all clients through the given
+// steps, that were accepted by the room. Selections of clients on other
+// instances are mapped as well until the instances share the new selections
+func mapRoomPresence(srv *environment.Services, room *WebsocketRoom, steps []*ProsemirrorStep) {
+
+	if room.Schema == nil || (len(room.Presence) == 0 && len(room.RemotePresence) == 0) {
+		return
+	}
+
+	maps := make([]*transform.StepMap, len(steps))
+	for i := range steps {
+		maps[i] = steps[i].Step.GetMap()
+	}
+
+	changed := false
+	for _, presence := range room.Presence {
+		if mapPresence(presence, maps) {
+			changed = true
+		}
+	}
+
+	remoteChanged := false
+	for _, users := range room.RemotePresence {
+		for _, presence := range users {
+			if mapPresence(presence, maps) {
+				remoteChanged = true
+			}
+		}
+	}
+
+	if changed || remoteChanged {
+		schedulePresenceUpdate(room, changed)
+	}
+}
+
+// mapPresence will map the selection of the given presence through the
+// given step maps and report if the selection changed
+func mapPresence(presence *Presence, maps []*transform.StepMap) bool {
+
+	if presence.Anchor < 0 {
+		return false
+	}
+
+	anchor, head := presence.Anchor, presence.Head
+	for _, stepMap := range maps {
+		anchor = stepMap.Map(anchor, 1)
+		head = stepMap.Map(head, 1)
+	}
+
+	if anchor == presence.Anchor && head == presence.Head {
+		return false
+	}
+
+	presence.Anchor = anchor
+	presence.Head = head
+	return true
+}
@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+)
+
+// sendTestPresence will send the selection of the client based on the given
+// version to the room
+func sendTestPresence(t *testing.T, room *WebsocketRoom, client *WebsocketClient,
+	version int64, anchor, head int) {
+	t.Helper()
+
+	sendTestMessage(t, room, client, MessageTypeProsemirrorPresence, &ProsemirrorPresenceMessage{
+		DocumentVersion: version,
+		Anchor:          anchor,
+		Head:            head,
+	})
+}
+
+// expectPresenceUpdate will wait for the next presence update sent to the
+// client and return the selection of the given client. Join and leave
+// events are skipped
+func expectPresenceUpdate(t *testing.T, client *WebsocketClient, clientID string) *Presence {
+	t.Helper()
+
+	for {
+		responses := expectResponses(t, client, MessageTypeProsemirrorPresence)
+
+		var response ProsemirrorPresenceResponse
+		decodeTestPayload(t, responses[MessageTypeProsemirrorPresence], &response.Payload)
+		if response.Payload.Event != presenceUpdate {
+			continue
+		}
+
+		for _, presence := range response.Payload.Users {
+			if presence.ClientID == clientID {
+				return presence
+			}
+		}
+		t.Fatalf("expected selection of %s, got %s", clientID,
+			responses[MessageTypeProsemirrorPresence])
+	}
+}
+
+// expectNoPresenceUpdate will check that the client does not receive a
+// presence update during the presence interval
+func expectNoPresenceUpdate(t *testing.T, client *WebsocketClient) {
+	t.Helper()
+
+	timeout := time.After(presenceInterval * 2)
+	for {
+		select {
+		case raw := <-client.Send:
+			var response ProsemirrorPresenceResponse
+			err := json.Unmarshal(raw, &response)
+			if err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if response.Type == MessageTypeProsemirrorPresence &&
+				response.Payload.Event == presenceUpdate {
+				t.Fatalf("unexpected presence update: %s", raw)
+			}
+
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestPresenceUpdates(t *testing.T) {
+
+	broker := repository.NewMemoryBroker()
+	steps := repository.NewMemoryStepStore()
+	room := newTestRoom(t, newTestServices(t, steps, broker, "a"))
+	remote := newTestRoom(t, newTestServices(t, steps, broker, "b"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	other := newTestClient("other", domain.Edit)
+	initTestClient(t, room, other, 0)
+
+	// the instance of the viewer receives the selections of the clients
+	// of the other instance when the first client joins
+	viewer := newTestClient("viewer", domain.View)
+	initTestClient(t, remote, viewer, 0)
+	expectPresenceUpdate(t, viewer, editor.ID)
+
+	// all selections sent during the presence interval are sent with a
+	// single update to the clients of all instances
+	for anchor := 1; anchor <= 3; anchor++ {
+		sendTestPresence(t, room, editor, 0, anchor, anchor)
+	}
+
+	for _, client := range []*WebsocketClient{editor, other, viewer} {
+		presence := expectPresenceUpdate(t, client, editor.ID)
+		if presence.Anchor != 3 || presence.Head != 3 {
+			t.Errorf("expected selection at 3, got %d-%d", presence.Anchor, presence.Head)
+		}
+	}
+
+	expectNoPresenceUpdate(t, other)
+}
+
+func TestStalePresence(t *testing.T) {
+
+	room := newTestRoom(t, newTestServices(t, repository.NewMemoryStepStore(),
+		repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	other := newTestClient("other", domain.Edit)
+	initTestClient(t, room, other, 0)
+
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"))
+	expectTestSteps(t, other, 0, 1, 1)
+
+	// selections based on an outdated version are dropped
+	sendTestPresence(t, room, other, 0, 2, 2)
+	expectNoPresenceUpdate(t, editor)
+
+	// the client sends its selection again after receiving the steps
+	sendTestPresence(t, room, other, 1, 3, 3)
+	presence := expectPresenceUpdate(t, editor, other.ID)
+	if presence.Anchor != 3 || presence.Head != 3 {
+		t.Errorf("expected selection at 3, got %d-%d", presence.Anchor, presence.Head)
+	}
+}
@@ -0,0 +1,139 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/collaboration/src/internal/transform"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"dkfbasel.ch/orca/pkg/logger"
+)
+
+// maximum size of messages handled by the server (3MB)
+const maxMessageSize = 3000000
+
+// ErrorCode is a machine readable code sent with error responses
+type ErrorCode string
+
+const ErrorCodePermissionDenied ErrorCode = "permission_denied"
+const ErrorCodeInvalidStep ErrorCode = "invalid_step"
+const ErrorCodeStorageFailure ErrorCode = "storage_failure"
+const ErrorCodeVersionConflict ErrorCode = "version_conflict"
+const ErrorCodePayloadTooLarge ErrorCode = "payload_too_large"
+const ErrorCodeRateLimited ErrorCode = "rate_limited"
+const ErrorCodeSchemaViolation ErrorCode = "schema_violation"
+const ErrorCodeVersionUnavailable ErrorCode = "version_unavailable"
+
+// errPermissionDenied is returned if a user is not allowed to send a step
+var errPermissionDenied = errors.New("permission denied")
+
+// ProsemirrorErrorResponse is used to inform the client that its message
+// could not be handled. The base version is the version sent by the client
+// and the version is the current version of the room (-1 if unknown)
+type ProsemirrorErrorResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		RequestID   string    `json:"requestId,omitempty"`
+		Code        ErrorCode `json:"code"`
+		Message     string    `json:"message"`
+		BaseVersion int64     `json:"base_version"`
+		Version     int64     `json:"version"`
+	} `json:"payload"`
+}
+
+// ProsemirrorAckResponse is used to inform the client that its steps were
+// accepted by the server
+type ProsemirrorAckResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		RequestID   string `json:"requestId,omitempty"`
+		BaseVersion int64  `json:"base_version"`
+		Version     int64  `json:"version"`
+	} `json:"payload"`
+}
+
+// replyProsemirrorError will send an error response with the given message
+// back to the sender of the message
+func replyProsemirrorError(message *Message, code ErrorCode, baseVersion, version int64,
+	text string) {
+	sendProsemirrorError(message.Reply, message.RequestID, code, baseVersion, version, text)
+}
+
+// sendProsemirrorError will send an error response with the given message
+// with the given send function
+func sendProsemirrorError(send func([]byte), requestID string, code ErrorCode,
+	baseVersion, version int64, text string) {
+
+	metrics.Errors.WithLabelValues(string(code)).Inc()
+
+	response := ProsemirrorErrorResponse{}
+	response.Type = MessageTypeProsemirrorError
+	response.Payload.RequestID = requestID
+	response.Payload.Code = code
+	response.Payload.Message = text
+	response.Payload.BaseVersion = baseVersion
+	response.Payload.Version = version
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		logger.DebugError("could not encode error response", err)
+		return
+	}
+
+	send(msg)
+}
+
+// replyProsemirrorAck will acknowledge the steps of the given message to
+// the sender of the message
+func replyProsemirrorAck(message *Message, baseVersion, version int64) {
+
+	response := ProsemirrorAckResponse{}
+	response.Type = MessageTypeProsemirrorAck
+	response.Payload.RequestID = message.RequestID
+	response.Payload.BaseVersion = baseVersion
+	response.Payload.Version = version
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		logger.DebugError("could not encode acknowledgement", err)
+		return
+	}
+
+	message.Reply(msg)
+}
+
+// stepErrorCode will return the error code for an error returned when
+// handling the side effects of a step
+func stepErrorCode(err error) ErrorCode {
+
+	if errors.Is(err, errPermissionDenied) || errors.Is(err, repository.ErrNotCommentAuthor) {
+		return ErrorCodePermissionDenied
+	}
+
+	if errors.Is(err, transform.ErrUnknownStepType) {
+		return ErrorCodeInvalidStep
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return ErrorCodeInvalidStep
+	}
+
+	return ErrorCodeStorageFailure
+}
+
+// applyErrorCode will return the error code for an error returned when
+// applying steps to the document of the room. Steps containing content
+// that is not part of the schema are most likely sent by an outdated editor
+func applyErrorCode(err error) ErrorCode {
+
+	var schemaErr *model.SchemaError
+	if errors.As(err, &schemaErr) {
+		return ErrorCodeSchemaViolation
+	}
+
+	return ErrorCodeInvalidStep
+}
@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/pkg/logger"
+	"nhooyr.io/websocket"
+)
+
+// clients should wait at least the given time before reconnecting after a
+// server shutdown. a random delay of up to the same duration is added to
+// avoid that all clients reconnect at the same time
+const shutdownReconnectDelay = time.Second * 2
+
+// ServerShutdownResponse informs the clients that the server is shutting
+// down and when they should try to reconnect
+type ServerShutdownResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		Reconnect  bool  `json:"reconnect"`
+		RetryAfter int64 `json:"retry_after"` // in milliseconds
+	} `json:"payload"`
+}
+
+// shutdownRoom will send all pending messages to the clients of the room
+// and persist the document. The clients are informed about the shutdown by
+// the hub, after all rooms were closed
+func shutdownRoom(srv *environment.Services, room *WebsocketRoom) {
+
+	// send all messages that are still waiting to be sent
+	flushRoomMessages(room)
+
+	// persist the current document content
+	saveRoomSnapshot(srv, room)
+
+	for client := range room.Clients {
+		delete(room.Clients, client)
+		delete(room.Presence, client)
+		metrics.Clients.Dec()
+	}
+
+	// remove the clients of this instance from the presence on other instances
+	publishRoomPresence(srv, room, presenceLeave, "", "")
+}
+
+// closeFailedRoom will inform all clients of the room, that the document
+// is not available anymore, and close their connections
+func closeFailedRoom(srv *environment.Services, room *WebsocketRoom) {
+
+	logger.Info("closing room without step log",
+		logger.String("documentid", room.DocumentID))
+
+	for client := range room.Clients {
+		sendProsemirrorError(client.send, "", ErrorCodeStorageFailure, -1,
+			room.DocumentVersion, "document is not available")
+		client.close(websocket.StatusInternalError, "document not available")
+
+		delete(room.Clients, client)
+		delete(room.Presence, client)
+		metrics.Clients.Dec()
+	}
+
+	// remove the clients of this instance from the presence on other instances
+	publishRoomPresence(srv, room, presenceLeave, "", "")
+}
+
+// sendServerShutdown will inform the client about the shutdown and close the
+// connection after all queued messages were written
+func sendServerShutdown(client *WebsocketClient) {
+
+	response := ServerShutdownResponse{}
+	response.Type = MessageTypeServerShutdown
+	response.Payload.Reconnect = true
+	response.Payload.RetryAfter = (shutdownReconnectDelay +
+		time.Duration(rand.Int63n(int64(shutdownReconnectDelay)))).Milliseconds()
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		logger.DebugError("could not encode shutdown response", err)
+	} else {
+		client.send(msg)
+	}
+
+	client.close(websocket.StatusGoingAway, "server shutdown")
+}
+
+// flushRoomMessages will send all buffered broadcast and notify messages
+// to the clients of the room
+func flushRoomMessages(room *WebsocketRoom) {
+	for {
+		select {
+		case message := <-room.Broadcast:
+			broadcastRoom(room, message)
+
+		case notify := <-room.Notify:
+			for client := range room.Clients {
+				if client != notify.Client {
+					client.send(notify.Payload)
+				}
+			}
+
+		default:
+			return
+		}
+	}
+}
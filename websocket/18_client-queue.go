@@ -0,0 +1,240 @@
+package websocket
+
+import (
+	"encoding/json"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/pkg/logger"
+	"nhooyr.io/websocket"
+)
+
+// OverflowPolicy defines how to handle clients whose outbound queue is full
+type OverflowPolicy string
+
+// OverflowCoalesce will merge consecutive steps and presence messages in
+// the queue and fall back to a resync if the queue is still full
+const OverflowCoalesce OverflowPolicy = "coalesce"
+
+// OverflowResync will drop all queued messages and ask the client to
+// reload the document
+const OverflowResync OverflowPolicy = "resync"
+
+// OverflowDisconnect will drop all queued messages and close the connection
+const OverflowDisconnect OverflowPolicy = "disconnect"
+
+// default size of the outbound queue of every client
+const defaultQueueSize = 256
+
+// parseOverflowPolicy will return the overflow policy with the given name
+func parseOverflowPolicy(name string) OverflowPolicy {
+	switch policy := OverflowPolicy(name); policy {
+	case OverflowCoalesce, OverflowResync, OverflowDisconnect:
+		return policy
+	default:
+		logger.Info("unknown overflow policy, using coalesce",
+			logger.String("policy", name))
+		return OverflowCoalesce
+	}
+}
+
+// closeRequest is used to close the connection of a client after all
+// queued messages were sent
+type closeRequest struct {
+	Code   websocket.StatusCode
+	Reason string
+}
+
+// send will add the given message to the outbound queue of the client. The
+// message is never blocking, the overflow policy of the client is applied
+// if the queue is full
+func (client *WebsocketClient) send(message []byte) {
+
+	client.queueMutex.Lock()
+	defer client.queueMutex.Unlock()
+
+	// the client will reload or is disconnected, i.e. there is no need
+	// to send any further messages
+	if client.dropMessages || client.awaitReload {
+		return
+	}
+
+	metrics.ClientQueueDepth.Observe(float64(len(client.Send)))
+
+	select {
+	case client.Send <- message:
+		return
+	default:
+	}
+
+	metrics.ClientQueueOverflows.WithLabelValues(string(client.Overflow)).Inc()
+	logger.Debug("outbound queue of client is full",
+		logger.String("userid", client.UserID),
+		logger.String("policy", string(client.Overflow)))
+
+	switch client.Overflow {
+	case OverflowCoalesce:
+		if client.coalesceQueue(message) {
+			return
+		}
+		client.resyncQueue()
+
+	case OverflowResync:
+		client.resyncQueue()
+
+	default:
+		client.drainQueue()
+		client.dropMessages = true
+		client.close(websocket.StatusPolicyViolation, "client too slow")
+	}
+}
+
+// close will request to close the connection of the client after all
+// queued messages were sent
+func (client *WebsocketClient) close(code websocket.StatusCode, reason string) {
+	select {
+	case client.Close <- closeRequest{Code: code, Reason: reason}:
+	default:
+		// the connection is already being closed
+	}
+}
+
+// drainQueue will remove all messages from the outbound queue
+func (client *WebsocketClient) drainQueue() [][]byte {
+	messages := make([][]byte, 0, len(client.Send))
+	for {
+		select {
+		case message := <-client.Send:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+// resyncQueue will drop all queued messages and ask the client to reload
+// the document. The versions of the reload response are unknown (-1)
+func (client *WebsocketClient) resyncQueue() {
+
+	client.drainQueue()
+	client.awaitReload = true
+
+	metrics.Reloads.Inc()
+
+	response := ProsemirrorInfoResponse{}
+	response.Type = MessageTypeProssemirrorReload
+	response.Payload.BaseVersion = -1
+	response.Payload.Version = -1
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		logger.DebugError("could not encode reload page response", err)
+		client.close(websocket.StatusInternalError, "client too slow")
+		return
+	}
+
+	client.Send <- msg
+}
+
+// resumeQueue will send messages to the client again after a resync, as the
+// client initializes the document again. Disconnected clients are not resumed
+func (client *WebsocketClient) resumeQueue() {
+
+	client.queueMutex.Lock()
+	defer client.queueMutex.Unlock()
+
+	client.awaitReload = false
+}
+
+// coalesceQueue will merge all queued messages with the given message and
+// add them to the queue again. False is returned if the merged messages
+// do not fit into the queue
+func (client *WebsocketClient) coalesceQueue(message []byte) bool {
+
+	messages := append(client.drainQueue(), message)
+	messages = coalesceMessages(messages)
+
+	if len(messages) > cap(client.Send) {
+		return false
+	}
+
+	for _, message := range messages {
+		client.Send <- message
+	}
+
+	return true
+}
+
+// coalesceMessages will merge steps messages with consecutive versions into
+// a single message and only keep the latest presence message. All other
+// messages are kept as they are
+func coalesceMessages(messages [][]byte) [][]byte {
+
+	types := make([]MessageType, len(messages))
+	lastPresence := -1
+
+	for i, message := range messages {
+		var envelope struct {
+			Type MessageType `json:"type"`
+		}
+		_ = json.Unmarshal(message, &envelope)
+
+		types[i] = envelope.Type
+		if envelope.Type == MessageTypeProsemirrorPresence {
+			lastPresence = i
+		}
+	}
+
+	coalesced := make([][]byte, 0, len(messages))
+
+	// keep track of the last steps message to merge into
+	var steps *ProsemirrorStepResponse
+
+	for i, message := range messages {
+
+		switch types[i] {
+		case MessageTypeProsemirrorPresence:
+			// presence messages always contain the selections of all users,
+			// i.e. only the latest message is relevant
+			if i != lastPresence {
+				continue
+			}
+
+		case MessageTypeProsemirrorSteps:
+			var current ProsemirrorStepResponse
+			err := json.Unmarshal(message, &current)
+			if err != nil {
+				break
+			}
+
+			// append the steps to the previous steps message if the versions
+			// are consecutive and no other message was sent in between
+			if steps != nil &&
+				steps.Payload.Version == current.Payload.BaseVersion &&
+				steps.Payload.FromInit == current.Payload.FromInit {
+
+				steps.Payload.Version = current.Payload.Version
+				steps.Payload.Steps = append(steps.Payload.Steps, current.Payload.Steps...)
+				steps.Payload.ClientIDs = append(steps.Payload.ClientIDs,
+					current.Payload.ClientIDs...)
+				steps.Payload.SaveImmediate = steps.Payload.SaveImmediate ||
+					current.Payload.SaveImmediate
+
+				merged, err := json.Marshal(steps)
+				if err == nil {
+					coalesced[len(coalesced)-1] = merged
+					continue
+				}
+			}
+
+			coalesced = append(coalesced, message)
+			steps = &current
+			continue
+		}
+
+		// other messages are sent as they are and may not be merged
+		coalesced = append(coalesced, message)
+		steps = nil
+	}
+
+	return coalesced
+}
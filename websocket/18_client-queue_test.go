@@ -0,0 +1,221 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"nhooyr.io/websocket"
+)
+
+// newTestQueueClient will return a client with the given overflow policy
+// and a queue of the given size
+func newTestQueueClient(policy OverflowPolicy, size int) *WebsocketClient {
+	client := newTestClient("client", domain.Edit)
+	client.Send = make(chan []byte, size)
+	client.Overflow = policy
+	return client
+}
+
+// testStepsMessage will return an encoded steps response with one step per
+// version between the given versions
+func testStepsMessage(t *testing.T, baseVersion, version int64) []byte {
+	t.Helper()
+
+	response := ProsemirrorStepResponse{}
+	response.Type = MessageTypeProsemirrorSteps
+	response.Payload.BaseVersion = baseVersion
+	response.Payload.Version = version
+	for v := baseVersion; v < version; v++ {
+		response.Payload.Steps = append(response.Payload.Steps, testReplaceStep(1, "a"))
+		response.Payload.ClientIDs = append(response.Payload.ClientIDs, 1)
+	}
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		t.Fatalf("could not encode steps: %v", err)
+	}
+	return msg
+}
+
+// testPresenceMessage will return an encoded presence update with the
+// selection of a single client at the given position
+func testPresenceMessage(t *testing.T, pos int) []byte {
+	t.Helper()
+
+	response := ProsemirrorPresenceResponse{}
+	response.Type = MessageTypeProsemirrorPresence
+	response.Payload.Event = presenceUpdate
+	response.Payload.Users = []*Presence{{ClientID: "other", Anchor: pos, Head: pos}}
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		t.Fatalf("could not encode presence: %v", err)
+	}
+	return msg
+}
+
+// queuedTestMessages will return the types of all messages in the queue of
+// the client together with the decoded messages
+func queuedTestMessages(t *testing.T, client *WebsocketClient) ([]MessageType, []json.RawMessage) {
+	t.Helper()
+
+	messages := client.drainQueue()
+	types := make([]MessageType, len(messages))
+	payloads := make([]json.RawMessage, len(messages))
+
+	for i, message := range messages {
+		var envelope struct {
+			Type    MessageType     `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		err := json.Unmarshal(message, &envelope)
+		if err != nil {
+			t.Fatalf("could not decode message: %v", err)
+		}
+		types[i] = envelope.Type
+		payloads[i] = envelope.Payload
+	}
+
+	return types, payloads
+}
+
+// expectNoCloseRequest will check that the connection of the client is not
+// requested to be closed
+func expectNoCloseRequest(t *testing.T, client *WebsocketClient) {
+	t.Helper()
+
+	select {
+	case request := <-client.Close:
+		t.Fatalf("unexpected close request: %v", request)
+	default:
+	}
+}
+
+func TestCoalesceQueue(t *testing.T) {
+
+	client := newTestQueueClient(OverflowCoalesce, 3)
+
+	// consecutive steps are merged and only the latest presence is kept
+	client.send(testStepsMessage(t, 0, 1))
+	client.send(testPresenceMessage(t, 1))
+	client.send(testPresenceMessage(t, 2))
+	client.send(testStepsMessage(t, 1, 2))
+	client.send(testStepsMessage(t, 2, 4))
+
+	types, payloads := queuedTestMessages(t, client)
+	if len(types) != 3 || types[0] != MessageTypeProsemirrorSteps ||
+		types[1] != MessageTypeProsemirrorPresence || types[2] != MessageTypeProsemirrorSteps {
+		t.Fatalf("unexpected queue: %v", types)
+	}
+
+	var presence ProsemirrorPresenceResponse
+	decodeTestPayload(t, payloads[1], &presence.Payload)
+	if presence.Payload.Users[0].Anchor != 2 {
+		t.Errorf("expected latest presence, got %s", payloads[1])
+	}
+
+	var steps ProsemirrorStepResponse
+	decodeTestPayload(t, payloads[2], &steps.Payload)
+	if steps.Payload.BaseVersion != 1 || steps.Payload.Version != 4 ||
+		len(steps.Payload.Steps) != 3 {
+		t.Errorf("expected merged steps from 1 to 4, got %s", payloads[2])
+	}
+
+	expectNoCloseRequest(t, client)
+}
+
+func TestCoalesceQueueResync(t *testing.T) {
+
+	client := newTestQueueClient(OverflowCoalesce, 2)
+
+	// error responses can not be merged, i.e. the client must reload
+	for i := 0; i < 3; i++ {
+		sendProsemirrorError(client.send, "", ErrorCodeInvalidStep, -1, -1, "error")
+	}
+
+	types, _ := queuedTestMessages(t, client)
+	if len(types) != 1 || types[0] != MessageTypeProssemirrorReload {
+		t.Fatalf("expected reload, got %v", types)
+	}
+
+	expectNoCloseRequest(t, client)
+}
+
+func TestResyncQueue(t *testing.T) {
+
+	room := newTestRoom(t, newTestServices(t, repository.NewMemoryStepStore(),
+		repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	slow := newTestQueueClient(OverflowResync, 2)
+	initTestClient(t, room, slow, 0, MessageTypeCommentsSync)
+	syncTestRoom(t, room, editor)
+	slow.drainQueue()
+
+	// the queue of the slow client overflows
+	for version := int64(0); version < 3; version++ {
+		sendTestSteps(t, room, editor, 1, version, testReplaceStep(1, "a"))
+		expectTestSteps(t, editor, version, version+1, 1)
+	}
+
+	types, _ := queuedTestMessages(t, slow)
+	if len(types) != 1 || types[0] != MessageTypeProssemirrorReload {
+		t.Fatalf("expected reload, got %v", types)
+	}
+
+	// no messages are sent until the client initializes the document again
+	sendTestSteps(t, room, editor, 1, 3, testReplaceStep(1, "a"))
+	expectTestSteps(t, editor, 3, 4, 1)
+	syncTestRoom(t, room, editor)
+
+	if types, _ := queuedTestMessages(t, slow); len(types) != 0 {
+		t.Fatalf("expected no messages before the reload, got %v", types)
+	}
+
+	sendTestMessage(t, room, slow, MessageTypeProsemirrorInit, &ProsemirrorInitMessage{
+		DocumentID:      testDocumentID,
+		DocumentSchema:  json.RawMessage(testSchema),
+		DocumentVersion: 4,
+	})
+	expectResponses(t, slow, MessageTypeCommentsSync)
+
+	sendTestSteps(t, room, editor, 1, 4, testReplaceStep(1, "a"))
+	expectTestSteps(t, slow, 4, 5, 1)
+
+	expectNoCloseRequest(t, slow)
+}
+
+func TestDisconnectQueue(t *testing.T) {
+
+	client := newTestQueueClient(OverflowDisconnect, 2)
+
+	for i := 0; i < 3; i++ {
+		client.send(testStepsMessage(t, int64(i), int64(i)+1))
+	}
+
+	if types, _ := queuedTestMessages(t, client); len(types) != 0 {
+		t.Fatalf("expected empty queue, got %v", types)
+	}
+
+	select {
+	case request := <-client.Close:
+		if request.Code != websocket.StatusPolicyViolation {
+			t.Errorf("expected policy violation, got %v", request.Code)
+		}
+	default:
+		t.Fatal("expected client to be disconnected")
+	}
+
+	// disconnected clients do not receive any messages, even if they sent
+	// an init message before the connection was closed
+	client.resumeQueue()
+	client.send(testStepsMessage(t, 3, 4))
+
+	if types, _ := queuedTestMessages(t, client); len(types) != 0 {
+		t.Fatalf("expected no messages after disconnect, got %v", types)
+	}
+}
@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// suspendRoom will persist the document of the room and drop its state.
+// The room stays subscribed to other instances, to forward their steps to
+// the clients that are still connected
+func suspendRoom(srv *environment.Services, room *WebsocketRoom) {
+
+	if room.Suspended {
+		return
+	}
+
+	saveRoomSnapshot(srv, room)
+
+	room.Suspended = true
+	room.Document = nil
+	room.Attribution = nil
+
+	// avoid that the hub suspends the room again on every check
+	room.touch()
+
+	logger.Debug("room suspended",
+		zap.Int64("room-version", room.DocumentVersion),
+		zap.String("documentid", room.DocumentID))
+}
+
+// resumeRoom will validate the state of the room against the latest
+// snapshot and the step log
+func resumeRoom(srv *environment.Services, room *WebsocketRoom) {
+
+	room.Suspended = false
+
+	// the room was never initialized
+	if room.DocumentVersion == -1 {
+		return
+	}
+
+	// send all steps that the clients missed while the room was suspended,
+	// i.e. because messages from other instances were dropped
+	logVersion, err := srv.Steps.Version(room.DocumentID)
+	if err == nil {
+		catchUpRoom(srv, room, logVersion)
+	}
+
+	version := room.DocumentVersion
+
+	room.DocumentVersion = -1
+	room.SnapshotVersion = -1
+	room.Document = nil
+
+	// keep the version of the room and start a new step log if neither a
+	// snapshot nor a step log is available anymore
+	if !loadRoomState(srv, room) {
+		room.DocumentVersion = version
+		resetStepLog(srv, room, version)
+	}
+
+	if room.DocumentVersion != version {
+		logger.Debug("room version changed while suspended",
+			zap.Int64("suspended-version", version),
+			zap.Int64("room-version", room.DocumentVersion),
+			zap.String("documentid", room.DocumentID))
+	}
+}
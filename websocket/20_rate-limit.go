@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"time"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/pkg/logger"
+	"golang.org/x/time/rate"
+	"nhooyr.io/websocket"
+)
+
+// clientLimiter limits the messages and bytes a single client may send
+type clientLimiter struct {
+	messages   *rate.Limiter
+	bytes      *rate.Limiter
+	violations *rate.Limiter
+}
+
+// newLimiter will return a token bucket with the given rate per second.
+// The limiter does not restrict anything if the rate is not positive
+func newLimiter(perSecond float64, burst int) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// newClientLimiter will initialize the rate limits of a client
+func newClientLimiter(config environment.WebsocketConfig) *clientLimiter {
+
+	limiter := clientLimiter{}
+	limiter.messages = newLimiter(config.ClientMessageRate, config.ClientMessageBurst)
+	limiter.bytes = newLimiter(float64(config.ClientBytesPerMinute)/60,
+		config.ClientBytesPerMinute)
+
+	// clients are disconnected if they exceed the number of violations
+	// within one minute
+	limiter.violations = newLimiter(float64(config.RateLimitViolations)/60,
+		config.RateLimitViolations)
+
+	return &limiter
+}
+
+// newRoomLimiter will initialize the message rate limit of a room
+func newRoomLimiter(config environment.WebsocketConfig) *rate.Limiter {
+	return newLimiter(config.RoomMessageRate, config.RoomMessageBurst)
+}
+
+// allow will check if the client may send a message of the given size. The
+// tokens are only taken if both the message and the bytes limit permit the
+// message, so that rejected messages do not use up the budget of the client
+func (limiter *clientLimiter) allow(size int) bool {
+	now := time.Now()
+
+	message := limiter.messages.ReserveN(now, 1)
+	if !message.OK() || message.DelayFrom(now) > 0 {
+		message.CancelAt(now)
+		return false
+	}
+
+	bytes := limiter.bytes.ReserveN(now, size)
+	if !bytes.OK() || bytes.DelayFrom(now) > 0 {
+		bytes.CancelAt(now)
+		message.CancelAt(now)
+		return false
+	}
+
+	return true
+}
+
+// rateLimitExceeded will inform the client that its message was not handled
+// due to a rate limit. Clients that exceed the rate limits repeatedly are
+// disconnected
+func rateLimitExceeded(client *WebsocketClient, requestID string, text string) {
+
+	logger.Debug("rate limit exceeded", logger.String("userid", client.UserID),
+		logger.String("reason", text))
+
+	if !client.Limiter.violations.Allow() {
+		logger.Debug("client disconnected due to repeated rate limit violations",
+			logger.String("userid", client.UserID))
+		client.close(websocket.StatusPolicyViolation, "rate limit exceeded")
+		return
+	}
+
+	sendProsemirrorError(client.send, requestID, ErrorCodeRateLimited, -1, -1, text)
+}
+
+// roomRateLimitExceeded will inform the client that its message was not
+// handled due to the rate limit of the room. The violation is not counted
+// against the client, as the limit may have been used up by other clients
+func roomRateLimitExceeded(message *Message, text string) {
+
+	logger.Debug("room rate limit exceeded", logger.String("userid", message.UserID),
+		logger.String("documentid", message.DocumentID))
+
+	replyProsemirrorError(message, ErrorCodeRateLimited, -1, -1, text)
+}
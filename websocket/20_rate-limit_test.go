@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"math"
+	"testing"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"nhooyr.io/websocket"
+)
+
+// expectTestError will wait for an error response sent to the client and
+// check its code
+func expectTestError(t *testing.T, client *WebsocketClient, code ErrorCode) {
+	t.Helper()
+
+	responses := expectResponses(t, client, MessageTypeProsemirrorError)
+
+	var response ProsemirrorErrorResponse
+	decodeTestPayload(t, responses[MessageTypeProsemirrorError], &response.Payload)
+	if response.Payload.Code != code {
+		t.Fatalf("expected error %s, got %s", code, responses[MessageTypeProsemirrorError])
+	}
+}
+
+func TestClientLimiter(t *testing.T) {
+
+	// the message budget is not refilled during the test, the bytes budget
+	// is refilled by one byte per second
+	limiter := newClientLimiter(environment.WebsocketConfig{
+		ClientMessageRate:    0.001,
+		ClientMessageBurst:   2,
+		ClientBytesPerMinute: 60,
+	})
+
+	tests := []struct {
+		name     string
+		size     int
+		allowed  bool
+		messages int
+		bytes    int
+	}{
+		{"message larger than the bytes burst", 100, false, 2, 60},
+		{"message within both limits", 50, true, 1, 10},
+		{"message exceeding the bytes limit", 20, false, 1, 10},
+		{"last message within both limits", 5, true, 0, 5},
+		{"message exceeding the message limit", 1, false, 0, 5},
+	}
+
+	// rejected messages do not take any tokens of the other limiter
+	for _, tt := range tests {
+		if got := limiter.allow(tt.size); got != tt.allowed {
+			t.Fatalf("%s: expected allowed to be %t, got %t", tt.name, tt.allowed, got)
+		}
+
+		messages := int(math.Floor(limiter.messages.Tokens()))
+		bytes := int(math.Floor(limiter.bytes.Tokens()))
+		if messages != tt.messages || bytes != tt.bytes {
+			t.Fatalf("%s: expected %d messages and %d bytes left, got %d and %d",
+				tt.name, tt.messages, tt.bytes, messages, bytes)
+		}
+	}
+}
+
+func TestClientLimiterUnlimited(t *testing.T) {
+
+	limiter := newClientLimiter(environment.WebsocketConfig{})
+	for i := 0; i < 100; i++ {
+		if !limiter.allow(1 << 20) {
+			t.Fatalf("expected message %d to be allowed without limits", i)
+		}
+	}
+}
+
+func TestRateLimitViolations(t *testing.T) {
+
+	client := newTestClient("editor", domain.Edit)
+	client.Limiter = newClientLimiter(environment.WebsocketConfig{RateLimitViolations: 1})
+
+	// the first violation is answered with an error
+	rateLimitExceeded(client, "request", "too many messages sent")
+	expectTestError(t, client, ErrorCodeRateLimited)
+
+	select {
+	case request := <-client.Close:
+		t.Fatalf("unexpected close request: %v", request)
+	default:
+	}
+
+	// clients exceeding the violations are disconnected
+	rateLimitExceeded(client, "request", "too many messages sent")
+
+	select {
+	case request := <-client.Close:
+		if request.Code != websocket.StatusPolicyViolation {
+			t.Errorf("expected policy violation, got %v", request.Code)
+		}
+	default:
+		t.Fatal("expected client to be disconnected")
+	}
+}
+
+func TestRoomRateLimit(t *testing.T) {
+
+	// the room handles a single message apart from init messages
+	room := newTestRoomWithConfig(t, newTestServices(t, repository.NewMemoryStepStore(),
+		repository.NewMemoryBroker(), "a"), environment.WebsocketConfig{
+		RoomMessageRate:  0.001,
+		RoomMessageBurst: 1,
+	})
+
+	editor := newTestClient("editor", domain.Edit)
+	editor.Limiter = newClientLimiter(environment.WebsocketConfig{RateLimitViolations: 1})
+	initTestClient(t, room, editor, 0)
+
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"))
+	expectTestSteps(t, editor, 0, 1, 1)
+
+	// messages exceeding the limit of the room are rejected, but are not
+	// counted as violations of the client
+	for i := 0; i < 3; i++ {
+		sendTestSteps(t, room, editor, 1, 1, testReplaceStep(1, "b"))
+		expectTestError(t, editor, ErrorCodeRateLimited)
+	}
+
+	select {
+	case request := <-editor.Close:
+		t.Fatalf("unexpected close request: %v", request)
+	default:
+	}
+}
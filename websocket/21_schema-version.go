@@ -0,0 +1,316 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/pkg/logger"
+	"nhooyr.io/websocket"
+)
+
+// SchemaCompatibility defines which schemas of joining clients are accepted
+// if they differ from the schema of the room
+type SchemaCompatibility string
+
+// SchemaCompatibilityStrict will only accept clients with an identical schema
+const SchemaCompatibilityStrict SchemaCompatibility = "strict"
+
+// SchemaCompatibilityMinor will accept clients with a schema version of the
+// same major version, that is not older than the schema version of the room
+const SchemaCompatibilityMinor SchemaCompatibility = "minor"
+
+// SchemaCompatibilitySuperset will accept clients whose schema contains all
+// node and mark types of the schema of the room
+const SchemaCompatibilitySuperset SchemaCompatibility = "superset"
+
+// parseSchemaCompatibility will return the schema compatibility rule with
+// the given name
+func parseSchemaCompatibility(name string) SchemaCompatibility {
+	switch rule := SchemaCompatibility(name); rule {
+	case SchemaCompatibilityStrict, SchemaCompatibilityMinor, SchemaCompatibilitySuperset:
+		return rule
+	default:
+		logger.Info("unknown schema compatibility, using strict",
+			logger.String("compatibility", name))
+		return SchemaCompatibilityStrict
+	}
+}
+
+// ProsemirrorSchemaMismatchResponse is used to inform a client that its
+// schema is not compatible with the schema of the room and that it must
+// reload the editor. The schema information of the room is included
+type ProsemirrorSchemaMismatchResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		RequestID     string `json:"requestId,omitempty"`
+		Message       string `json:"message"`
+		SchemaHash    string `json:"schema_hash"`
+		SchemaVersion string `json:"schema_version,omitempty"`
+	} `json:"payload"`
+}
+
+// schemaHash will return the hash of the canonical form of the given json
+// encoded schema, i.e. the hash does not depend on the order of the keys or
+// the formatting. The order of node and mark types is kept, as they are
+// encoded as ordered lists
+func schemaHash(schema json.RawMessage) string {
+
+	if len(schema) == 0 {
+		return ""
+	}
+
+	canonical, err := canonicalJSON(schema)
+	if err != nil {
+		canonical = schema
+	}
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalJSON will encode the given json with sorted keys and without
+// any whitespace. Numbers are kept as they are
+func canonicalJSON(raw json.RawMessage) ([]byte, error) {
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	// maps are always encoded with sorted keys
+	return json.Marshal(value)
+}
+
+// clientSchemaHash will return the schema hash sent by the client or the
+// hash of the schema sent by the client if no hash was given
+func clientSchemaHash(payload *ProsemirrorInitMessage) string {
+	if payload.SchemaHash != "" {
+		return payload.SchemaHash
+	}
+	return schemaHash(payload.DocumentSchema)
+}
+
+// checkClientSchema will verify that the schema of the client initializing
+// the document is compatible with the schema of the room. Clients with an
+// incompatible schema are asked to reload and disconnected, unless they are
+// editors with a newer schema version. The older clients must reload then
+func checkClientSchema(room *WebsocketRoom, message *Message,
+	payload *ProsemirrorInitMessage) bool {
+
+	// the room will use the schema of the first editor that sends one
+	if room.SchemaHash == "" {
+		if message.Permission >= domain.Edit {
+			initializeRoomSchema(room, payload)
+		}
+		return true
+	}
+
+	hash := clientSchemaHash(payload)
+
+	// clients without any schema information can not be verified. their
+	// steps are still validated against the schema of the room
+	if hash == "" && payload.SchemaVersion == "" {
+		return true
+	}
+
+	if hash == room.SchemaHash {
+		return true
+	}
+
+	rule := SchemaCompatibility(room.Config.SchemaCompatibility)
+
+	switch rule {
+	case SchemaCompatibilityMinor:
+		if compatibleSchemaVersion(room.SchemaVersion, payload.SchemaVersion) {
+			return true
+		}
+
+	case SchemaCompatibilitySuperset:
+		if room.Schema != nil && len(payload.DocumentSchema) > 0 {
+			schema, err := model.ParseSchema(payload.DocumentSchema)
+			if err == nil && schema.Includes(room.Schema) {
+				return true
+			}
+		}
+	}
+
+	// editors with a newer schema version replace the schema of the room,
+	// if the content of the room can be represented with the new schema.
+	// all clients with an older schema are asked to reload instead
+	if message.Permission >= domain.Edit &&
+		newerSchemaVersion(room.SchemaVersion, payload.SchemaVersion) &&
+		extendsRoomSchema(room, payload) {
+		upgradeRoomSchema(room, message, payload)
+		return true
+	}
+
+	logger.Debug("client schema does not match room schema",
+		logger.String("documentid", room.DocumentID),
+		logger.String("userid", message.UserID),
+		logger.String("compatibility", string(rule)),
+		logger.String("room-schema", room.SchemaVersion),
+		logger.String("client-schema", payload.SchemaVersion))
+
+	replySchemaMismatch(room, message)
+	return false
+}
+
+// upgradeRoomSchema will use the schema of the sender of the message for the
+// room and ask all other clients with a different schema to reload
+func upgradeRoomSchema(room *WebsocketRoom, message *Message,
+	payload *ProsemirrorInitMessage) {
+
+	logger.Debug("upgrade room schema",
+		logger.String("documentid", room.DocumentID),
+		logger.String("userid", message.UserID),
+		logger.String("room-schema", room.SchemaVersion),
+		logger.String("client-schema", payload.SchemaVersion))
+
+	initializeRoomSchema(room, payload)
+
+	// the content of the room must use the types of the new schema
+	if room.Document != nil {
+		room.Document = parseRoomDocument(room, room.Document.ToJSON())
+	}
+
+	for client := range room.Clients {
+		if client == message.Client || client.SchemaHash == "" ||
+			client.SchemaHash == room.SchemaHash {
+			continue
+		}
+		sendSchemaMismatch(room, client, "")
+	}
+}
+
+// extendsRoomSchema indicates if the schema of the client contains all node
+// and mark types of the schema of the room, so that the room may use the
+// schema of the client without losing any content
+func extendsRoomSchema(room *WebsocketRoom, payload *ProsemirrorInitMessage) bool {
+
+	if len(payload.DocumentSchema) == 0 {
+		return false
+	}
+
+	schema, err := model.ParseSchema(payload.DocumentSchema)
+	if err != nil {
+		logger.DebugError("could not parse client schema", err,
+			logger.String("documentid", room.DocumentID))
+		return false
+	}
+
+	return room.Schema == nil || schema.Includes(room.Schema)
+}
+
+// newerSchemaVersion indicates if the given client schema version is newer
+// than the room schema version
+func newerSchemaVersion(roomVersion, clientVersion string) bool {
+
+	room, ok := parseSchemaVersion(roomVersion)
+	if !ok {
+		return false
+	}
+
+	client, ok := parseSchemaVersion(clientVersion)
+	if !ok {
+		return false
+	}
+
+	return client[0] > room[0] || (client[0] == room[0] && client[1] > room[1])
+}
+
+// compatibleSchemaVersion indicates if the given client schema version has
+// the same major version as the room schema version and is not older
+func compatibleSchemaVersion(roomVersion, clientVersion string) bool {
+
+	room, ok := parseSchemaVersion(roomVersion)
+	if !ok {
+		return false
+	}
+
+	client, ok := parseSchemaVersion(clientVersion)
+	if !ok {
+		return false
+	}
+
+	return client[0] == room[0] && client[1] >= room[1]
+}
+
+// parseSchemaVersion will parse the major and minor number of the given
+// version (i.e. 1.2 or v1.2.3)
+func parseSchemaVersion(version string) ([2]int, bool) {
+
+	var parsed [2]int
+
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 {
+		return parsed, false
+	}
+
+	for i := range parsed {
+		number, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return parsed, false
+		}
+		parsed[i] = number
+	}
+
+	return parsed, true
+}
+
+// replySchemaMismatch will inform the sender of the message that its schema
+// is not compatible with the room and close the connection afterwards
+func replySchemaMismatch(room *WebsocketRoom, message *Message) {
+
+	msg, err := schemaMismatchResponse(room, message.RequestID)
+	if err != nil {
+		logger.DebugError("could not encode schema mismatch response", err)
+		return
+	}
+
+	message.Reply(msg)
+
+	if message.Client != nil {
+		message.Client.close(websocket.StatusPolicyViolation, "schema mismatch")
+	}
+}
+
+// sendSchemaMismatch will inform the given client that its schema is not
+// compatible with the room anymore and close the connection afterwards
+func sendSchemaMismatch(room *WebsocketRoom, client *WebsocketClient, requestID string) {
+
+	msg, err := schemaMismatchResponse(room, requestID)
+	if err != nil {
+		logger.DebugError("could not encode schema mismatch response", err)
+		return
+	}
+
+	client.send(msg)
+	client.close(websocket.StatusPolicyViolation, "schema mismatch")
+}
+
+// schemaMismatchResponse will return the encoded response to inform clients
+// about the schema of the room
+func schemaMismatchResponse(room *WebsocketRoom, requestID string) ([]byte, error) {
+
+	metrics.Reloads.Inc()
+
+	response := ProsemirrorSchemaMismatchResponse{}
+	response.Type = MessageTypeProsemirrorSchemaMismatch
+	response.Payload.RequestID = requestID
+	response.Payload.Message = "the document schema changed, please reload the editor"
+	response.Payload.SchemaHash = room.SchemaHash
+	response.Payload.SchemaVersion = room.SchemaVersion
+
+	return json.Marshal(&response)
+}
@@ -0,0 +1,200 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+)
+
+// the test schema with different formatting and order of the keys
+const testSchemaReordered = `{"marks":{"content":["em",{},"comment",{"excludes":"",` +
+	`"attrs":{"id":{}}},"weblink",{"attrs":{"url":{},"name":{"default":""},"id":{}}}]},` +
+	`"nodes":{"content":["doc",{"content":"block+"},"paragraph",{"group":"block",` +
+	`"content":"inline*"},"text",{"group":"inline"}]}}`
+
+// the test schema with an additional mark type
+const testSchemaExtended = `{
+	"nodes": {"content": [
+		"doc", {"content": "block+"},
+		"paragraph", {"content": "inline*", "group": "block"},
+		"text", {"group": "inline"}
+	]},
+	"marks": {"content": [
+		"em", {},
+		"strong", {},
+		"comment", {"attrs": {"id": {}}, "excludes": ""},
+		"weblink", {"attrs": {"id": {}, "url": {}, "name": {"default": ""}}}
+	]}
+}`
+
+// the test schema without the weblink mark type
+const testSchemaReduced = `{
+	"nodes": {"content": [
+		"doc", {"content": "block+"},
+		"paragraph", {"content": "inline*", "group": "block"},
+		"text", {"group": "inline"}
+	]},
+	"marks": {"content": [
+		"em", {},
+		"comment", {"attrs": {"id": {}}, "excludes": ""}
+	]}
+}`
+
+// sendTestInit will register the client in the room and initialize it with
+// the given schema and schema version
+func sendTestInit(t *testing.T, room *WebsocketRoom, client *WebsocketClient,
+	schema, version string) {
+	t.Helper()
+
+	client.SchemaHash = schemaHash(json.RawMessage(schema))
+	joinTestRoom(t, room, client)
+
+	sendTestMessage(t, room, client, MessageTypeProsemirrorInit, &ProsemirrorInitMessage{
+		DocumentID:      testDocumentID,
+		DocumentSchema:  json.RawMessage(schema),
+		DocumentVersion: 0,
+		Document:        json.RawMessage(testDocument),
+		SchemaVersion:   version,
+	})
+}
+
+// expectSchemaMismatch will wait for the schema mismatch response and check
+// that the connection of the client is closed afterwards
+func expectSchemaMismatch(t *testing.T, client *WebsocketClient, version string) {
+	t.Helper()
+
+	responses := expectResponses(t, client, MessageTypeProsemirrorSchemaMismatch)
+
+	var response ProsemirrorSchemaMismatchResponse
+	decodeTestPayload(t, responses[MessageTypeProsemirrorSchemaMismatch], &response.Payload)
+	if response.Payload.SchemaVersion != version {
+		t.Errorf("expected schema version %s, got %s", version,
+			responses[MessageTypeProsemirrorSchemaMismatch])
+	}
+
+	select {
+	case <-client.Close:
+	default:
+		t.Error("expected client to be disconnected")
+	}
+}
+
+func TestSchemaHash(t *testing.T) {
+
+	hash := schemaHash(json.RawMessage(testSchema))
+
+	tests := []struct {
+		name   string
+		schema string
+		same   bool
+	}{
+		{"identical schema", testSchema, true},
+		{"different formatting and key order", testSchemaReordered, true},
+		{"additional mark type", testSchemaExtended, false},
+		{"different order of mark types", `{
+			"nodes": {"content": [
+				"doc", {"content": "block+"},
+				"paragraph", {"content": "inline*", "group": "block"},
+				"text", {"group": "inline"}
+			]},
+			"marks": {"content": [
+				"comment", {"attrs": {"id": {}}, "excludes": ""},
+				"em", {},
+				"weblink", {"attrs": {"id": {}, "url": {}, "name": {"default": ""}}}
+			]}
+		}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := schemaHash(json.RawMessage(tt.schema))
+			if (got == hash) != tt.same {
+				t.Errorf("expected same hash to be %t, got %s and %s", tt.same, hash, got)
+			}
+		})
+	}
+
+	if schemaHash(nil) != "" {
+		t.Error("expected no hash without schema")
+	}
+}
+
+func TestSchemaVersions(t *testing.T) {
+
+	tests := []struct {
+		room       string
+		client     string
+		newer      bool
+		compatible bool
+	}{
+		{"1.0", "1.0", false, true},
+		{"1.0", "1.1", true, true},
+		{"v1.2.3", "v1.3.0", true, true},
+		{"1.1", "1.0", false, false},
+		{"1.5", "2.0", true, false},
+		{"2.0", "1.5", false, false},
+		{"", "1.0", false, false},
+		{"1.0", "latest", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.room+"-"+tt.client, func(t *testing.T) {
+			if got := newerSchemaVersion(tt.room, tt.client); got != tt.newer {
+				t.Errorf("expected newer to be %t, got %t", tt.newer, got)
+			}
+			if got := compatibleSchemaVersion(tt.room, tt.client); got != tt.compatible {
+				t.Errorf("expected compatible to be %t, got %t", tt.compatible, got)
+			}
+		})
+	}
+}
+
+func TestSchemaNegotiation(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		permission domain.Permission
+		schema     string
+		version    string
+		accepted   bool
+		upgraded   bool
+	}{
+		{"identical schema", domain.Edit, testSchemaReordered, "1.0", true, false},
+		{"newer extended schema of an editor", domain.Edit, testSchemaExtended, "1.1", true, true},
+		{"newer reduced schema of an editor", domain.Edit, testSchemaReduced, "1.1", false, false},
+		{"newer extended schema of a viewer", domain.View, testSchemaExtended, "1.1", false, false},
+		{"older schema of an editor", domain.Edit, testSchemaExtended, "0.9", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			room := newTestRoom(t, newTestServices(t, repository.NewMemoryStepStore(),
+				repository.NewMemoryBroker(), "a"))
+
+			first := newTestClient("first", domain.Edit)
+			sendTestInit(t, room, first, testSchema, "1.0")
+			expectResponses(t, first, MessageTypeCommentsSync)
+
+			client := newTestClient("client", tt.permission)
+			sendTestInit(t, room, client, tt.schema, tt.version)
+
+			if !tt.accepted {
+				expectSchemaMismatch(t, client, "1.0")
+				return
+			}
+			expectResponses(t, client, MessageTypeCommentsSync)
+
+			// clients with the schema replaced by the upgrade must reload
+			if tt.upgraded {
+				expectSchemaMismatch(t, first, tt.version)
+			}
+
+			// the document is still validated against the schema of the room
+			sendTestSteps(t, room, client, 2, 0, testReplaceStep(1, "a"))
+			expectTestSteps(t, client, 0, 1, 1)
+		})
+	}
+}
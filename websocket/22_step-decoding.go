@@ -0,0 +1,137 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/collaboration/src/internal/transform"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"dkfbasel.ch/orca/pkg/logger"
+)
+
+// StepType is the discriminator of the json representation of steps
+type StepType string
+
+const StepTypeReplace StepType = "replace"
+const StepTypeReplaceAround StepType = "replaceAround"
+const StepTypeAddMark StepType = "addMark"
+const StepTypeRemoveMark StepType = "removeMark"
+const StepTypeAttr StepType = "attr"
+const StepTypeComment StepType = "comment"
+const StepTypePicture StepType = "picture"
+
+// ProsemirrorAttrStep information
+type ProsemirrorAttrStep struct {
+	Pos   int         `json:"pos"`
+	Attr  string      `json:"attr"`
+	Value interface{} `json:"value"`
+}
+
+// ProsemirrorStep is a decoded step. Depending on the step type, exactly
+// one of the typed steps is set
+type ProsemirrorStep struct {
+	Type StepType
+
+	Replace *ProsemirrorReplaceStep // replace and replaceAround steps
+	Mark    *ProsemirrorMarkStep    // addMark and removeMark steps
+	Attr    *ProsemirrorAttrStep    // attr steps
+	Custom  *ProsemirrorCustomStep  // comment and picture steps
+
+	// step to apply to the document, nil if the schema is unknown. steps
+	// of unknown types can not be applied to the document
+	Step transform.Step
+}
+
+// decodeProsemirrorSteps will decode the given steps once with the schema
+// of the room. The document steps are not set if the schema is unknown
+func decodeProsemirrorSteps(room *WebsocketRoom, raw []json.RawMessage) ([]*ProsemirrorStep, error) {
+
+	steps := make([]*ProsemirrorStep, len(raw))
+	for i := range raw {
+		step, err := decodeProsemirrorStep(room.Schema, raw[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse step %d: %w", i, err)
+		}
+		steps[i] = step
+	}
+
+	return steps, nil
+}
+
+// decodeStoredSteps will decode the given steps of the step log with the
+// schema of the room
+func decodeStoredSteps(room *WebsocketRoom, stored []repository.StoredStep) ([]*ProsemirrorStep, error) {
+
+	raw := make([]json.RawMessage, len(stored))
+	for i := range stored {
+		raw[i] = stored[i].Step
+	}
+
+	return decodeProsemirrorSteps(room, raw)
+}
+
+// decodeProsemirrorStep will decode the step type of the given step once
+// and parse the step into the respective typed step and, if the schema is
+// given, into the step to apply to the document. Steps of unknown types,
+// i.e. of newer editors, are passed through without typed step. Applying
+// them returns an error wrapping transform.ErrUnknownStepType
+func decodeProsemirrorStep(schema *model.Schema, raw json.RawMessage) (*ProsemirrorStep, error) {
+
+	var header struct {
+		StepType StepType `json:"stepType"`
+	}
+	err := json.Unmarshal(raw, &header)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse step: %w", err)
+	}
+
+	step := ProsemirrorStep{Type: header.StepType}
+
+	switch header.StepType {
+	case StepTypeReplace, StepTypeReplaceAround:
+		step.Replace = &ProsemirrorReplaceStep{}
+		err = json.Unmarshal(raw, step.Replace)
+
+	case StepTypeAddMark, StepTypeRemoveMark:
+		step.Mark = &ProsemirrorMarkStep{}
+		err = json.Unmarshal(raw, step.Mark)
+
+	case StepTypeAttr:
+		step.Attr = &ProsemirrorAttrStep{}
+		err = json.Unmarshal(raw, step.Attr)
+
+	case StepTypeComment, StepTypePicture:
+		step.Custom = &ProsemirrorCustomStep{}
+		err = json.Unmarshal(raw, step.Custom)
+
+	default:
+		logger.Info("unknown step type", logger.String("step-type", string(header.StepType)))
+		if schema != nil {
+			step.Step = &transform.UnknownStep{StepType: string(header.StepType)}
+		}
+		return &step, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s step: %w", header.StepType, err)
+	}
+
+	if schema == nil {
+		return &step, nil
+	}
+
+	// comment and picture steps are only used to inform the server and
+	// other clients and do not change the document itself
+	if step.Custom != nil {
+		step.Step = &transform.NoopStep{StepType: string(header.StepType)}
+		return &step, nil
+	}
+
+	step.Step, err = transform.DecodeStep(schema, string(header.StepType), raw)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s step: %w", header.StepType, err)
+	}
+
+	return &step, nil
+}
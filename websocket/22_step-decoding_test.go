@@ -0,0 +1,190 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/collaboration/src/internal/transform"
+)
+
+// parseTestSchema will parse the schema used by the tests of the package
+func parseTestSchema(t testing.TB) *model.Schema {
+	t.Helper()
+
+	schema, err := model.ParseSchema(json.RawMessage(testSchema))
+	if err != nil {
+		t.Fatalf("could not parse schema: %v", err)
+	}
+	return schema
+}
+
+func TestDecodeProsemirrorStep(t *testing.T) {
+
+	schema := parseTestSchema(t)
+
+	tests := []struct {
+		name string
+		step string
+		want StepType
+	}{
+		{"replace", `{"stepType":"replace","from":1,"to":1,
+			"slice":{"content":[{"type":"text","text":"a"}]}}`, StepTypeReplace},
+		{"add mark with whitespace", `{ "stepType" : "addMark", "from":1, "to":2,
+			"mark":{"type":"weblink","attrs":{"id":"1","url":"https://example.com"}}}`,
+			StepTypeAddMark},
+		{"remove mark", `{"stepType":"removeMark","from":1,"to":2,"mark":{"type":"em"}}`,
+			StepTypeRemoveMark},
+		{"comment", `{"stepType":"comment","type":"addComment","payload":{"id":"1"}}`,
+			StepTypeComment},
+		{"picture", `{"stepType":"picture","type":"copyPicture","payload":{}}`,
+			StepTypePicture},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, err := decodeProsemirrorStep(schema, json.RawMessage(test.step))
+			if err != nil {
+				t.Fatalf("could not decode step: %v", err)
+			}
+			if step.Type != test.want {
+				t.Errorf("expected step type %s, got %s", test.want, step.Type)
+			}
+			if step.Step == nil {
+				t.Error("document step was not decoded")
+			}
+		})
+	}
+}
+
+func TestDecodeUnknownProsemirrorStep(t *testing.T) {
+
+	raw := json.RawMessage(`{"stepType":"addNodeMark","pos":0,"mark":{"type":"em"}}`)
+
+	for _, schema := range []*model.Schema{nil, parseTestSchema(t)} {
+
+		// steps of newer editors are passed through without typed step
+		step, err := decodeProsemirrorStep(schema, raw)
+		if err != nil {
+			t.Fatalf("could not decode step: %v", err)
+		}
+		if step.Type != "addNodeMark" || step.Replace != nil || step.Mark != nil ||
+			step.Attr != nil || step.Custom != nil {
+			t.Errorf("unexpected step: %+v", step)
+		}
+
+		if schema == nil {
+			if step.Step != nil {
+				t.Error("expected no document step without schema")
+			}
+			continue
+		}
+
+		// the step can not be applied to the document
+		_, err = step.Step.Apply(nil)
+		if !errors.Is(err, transform.ErrUnknownStepType) {
+			t.Errorf("expected unknown step type error, got %v", err)
+		}
+	}
+}
+
+// FuzzDecodeProsemirrorStep will insert arbitrary text into the document and
+// make sure that the text is never mistaken for a mark or comment step
+func FuzzDecodeProsemirrorStep(f *testing.F) {
+
+	f.Add(`"stepType":"addMark"`)
+	f.Add(`{"stepType":"addMark","mark":{"type":"weblink","attrs":{"id":"1","url":"x"}}}`)
+	f.Add(`{"stepType":"comment","type":"addComment","payload":{"id":"1"}}`)
+	f.Add(`","marks":[{"type":"weblink","attrs":{"id":"1"}}],"x":"`)
+	f.Add("hello world")
+
+	schema := parseTestSchema(f)
+
+	f.Fuzz(func(t *testing.T, text string) {
+
+		raw, err := json.Marshal(map[string]interface{}{
+			"stepType": "replace",
+			"from":     1,
+			"to":       1,
+			"slice": map[string]interface{}{
+				"content": []interface{}{
+					map[string]interface{}{"type": "text", "text": text},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("could not encode step: %v", err)
+		}
+
+		for _, s := range []*model.Schema{nil, schema} {
+
+			step, err := decodeProsemirrorStep(s, raw)
+			if err != nil {
+				// empty text nodes are rejected by the schema
+				if s != nil && text == "" {
+					continue
+				}
+				t.Fatalf("could not decode replace step: %v", err)
+			}
+
+			if step.Type != StepTypeReplace || step.Mark != nil || step.Custom != nil {
+				t.Fatalf("text content was decoded as %s step", step.Type)
+			}
+
+			if isCommentStep(step) {
+				t.Fatal("text content was decoded as comment step")
+			}
+
+			if links := replaceStepLinks(step.Replace); len(links) > 0 {
+				t.Fatalf("text content was decoded as link: %v", links)
+			}
+		}
+	})
+}
+
+// FuzzDecodeProsemirrorStepJSON will make sure that arbitrary input is either
+// rejected or decoded into exactly one typed step. Steps of unknown types
+// are decoded without typed step
+func FuzzDecodeProsemirrorStepJSON(f *testing.F) {
+
+	f.Add(`{"stepType":"replace","from":1,"to":1}`)
+	f.Add(`{"stepType":"addMark","from":1,"to":2,"mark":{"type":"em"}}`)
+	f.Add(`{"stepType":"comment","type":"delete","payload":{"id":"1"}}`)
+	f.Add(`{"stepType":"attr","pos":0,"attr":"level","value":2}`)
+	f.Add(`{"stepType":1}`)
+	f.Add(`[]`)
+
+	f.Fuzz(func(t *testing.T, raw string) {
+
+		step, err := decodeProsemirrorStep(nil, json.RawMessage(raw))
+		if err != nil {
+			return
+		}
+
+		typed := 0
+		if step.Replace != nil {
+			typed++
+		}
+		if step.Mark != nil {
+			typed++
+		}
+		if step.Attr != nil {
+			typed++
+		}
+		if step.Custom != nil {
+			typed++
+		}
+
+		known := false
+		switch step.Type {
+		case StepTypeReplace, StepTypeReplaceAround, StepTypeAddMark, StepTypeRemoveMark,
+			StepTypeAttr, StepTypeComment, StepTypePicture:
+			known = true
+		}
+
+		if known && typed != 1 || !known && typed != 0 {
+			t.Fatalf("%s step decoded into %d typed steps", step.Type, typed)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"dkfbasel.ch/orca/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// saveStepHistory will persist the given batch of accepted steps in the
+// step history of the document. Steps are accepted even if the history
+// could not be written, replaying the steps will then fail for the gap
+func saveStepHistory(srv *environment.Services, room *WebsocketRoom,
+	baseVersion int64, steps []repository.StoredStep) {
+
+	err := srv.Postgres.SaveStepHistory(room.DocumentID, baseVersion, steps)
+	if err != nil {
+		logger.DebugError("could not save step history", err,
+			logger.String("documentid", room.DocumentID),
+			zap.Int64("base-version", baseVersion))
+	}
+}
+
+// replaySteps will return all steps from the given version up to the given
+// version of the document. The steps are taken from the step log and from
+// the persisted step history, if the step log does not contain them anymore
+func replaySteps(srv *environment.Services, documentID string,
+	from, to int64) ([]repository.StoredStep, error) {
+
+	steps, err := srv.Steps.Range(documentID, from)
+	if err == nil && to >= from && int64(len(steps)) > to-from {
+		steps = steps[:to-from]
+	}
+	if err != repository.ErrStepsUnavailable && err != repository.ErrNoStepLog {
+		return steps, err
+	}
+
+	history, historyErr := srv.Postgres.FetchStepHistory(documentID, from, to)
+	if historyErr != nil {
+		if historyErr != repository.ErrStepsUnavailable {
+			logger.DebugError("could not fetch steps from step history", historyErr,
+				logger.String("documentid", documentID))
+		}
+		return nil, err
+	}
+
+	logger.Debug("steps replayed from step history",
+		zap.Int64("from", from), zap.Int64("to", to),
+		zap.String("documentid", documentID))
+
+	return history, nil
+}
+
+// restoreStepLog will start a new step log at the latest version of the
+// persisted step history, if the step log of the document does not exist
+// anymore. The version of the new step log is returned
+func restoreStepLog(srv *environment.Services, room *WebsocketRoom) (int64, error) {
+
+	version, err := srv.Postgres.FetchStepHistoryVersion(room.DocumentID)
+	if err != nil {
+		if err != repository.ErrNoStepLog {
+			logger.DebugError("could not fetch step history version", err,
+				logger.String("documentid", room.DocumentID))
+		}
+		return 0, repository.ErrNoStepLog
+	}
+
+	err = resetStepLog(srv, room, version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"dkfbasel.ch/orca/collaboration/src/repository"
+)
+
+func TestReplaySteps(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		logStart int64 // starting version of the step log, -1 without step log
+		history  int   // number of steps in the step history
+		failing  bool  // whether the step history can not be fetched
+		user     string
+		err      error
+	}{
+		{"steps in the step log", 0, 0, false, "user-log", nil},
+		{"step log starting after the version", 2, 2, false, "user-editor", nil},
+		{"missing step log", -1, 2, false, "user-editor", nil},
+		{"missing step log and history", -1, 0, false, "", repository.ErrNoStepLog},
+		{"incomplete step history", 2, 1, false, "", repository.ErrStepsUnavailable},
+		{"failing step history", -1, 2, true, "", repository.ErrNoStepLog},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			steps := repository.NewMemoryStepStore()
+			if tt.logStart >= 0 {
+				err := steps.Reset(testDocumentID, tt.logStart)
+				if err != nil {
+					t.Fatalf("could not reset step log: %v", err)
+				}
+				_, err = steps.Append(testDocumentID, tt.logStart, []repository.StoredStep{
+					{Step: testReplaceStep(1, "a"), ClientID: 2, UserID: "user-log"},
+					{Step: testReplaceStep(1, "b"), ClientID: 2, UserID: "user-log"},
+				}, time.Minute)
+				if err != nil {
+					t.Fatalf("could not append steps: %v", err)
+				}
+			}
+
+			srv := newTestServices(t, steps, repository.NewMemoryBroker(), "a")
+
+			if tt.history == 2 {
+				setTestStepHistory(t, "a", 0, testReplaceStep(1, "a"), testReplaceStep(1, "b"))
+			} else if tt.history == 1 {
+				setTestStepHistory(t, "a", 0, testReplaceStep(1, "a"))
+			}
+			if tt.failing {
+				setTestError(t, "a", []driver.Value{testDocumentID, int64(0), int64(2)},
+					errors.New("connection refused"))
+			}
+
+			replayed, err := replaySteps(srv, testDocumentID, 0, 2)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			if len(replayed) != 2 {
+				t.Fatalf("expected 2 steps, got %d", len(replayed))
+			}
+			for _, step := range replayed {
+				if step.UserID != tt.user {
+					t.Errorf("expected step of %s, got %+v", tt.user, step)
+				}
+			}
+		})
+	}
+}
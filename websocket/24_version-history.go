@@ -0,0 +1,387 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"dkfbasel.ch/orca/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// client id used for steps created by the server, i.e. to restore a version.
+// the id must differ from all client ids, so that prosemirror treats the
+// steps as changes of another client
+const serverClientID = -1
+
+// errVersionUnavailable is returned if a document version can not be built
+var errVersionUnavailable = errors.New("document version not available")
+
+// number of attempts to restore a version if the step log was modified by
+// another instance in the meantime
+const restoreAttempts = 3
+
+// versionRestore is a document version, that was built outside of the room
+// and must be restored by the room
+type versionRestore struct {
+	Message  Message
+	Version  int64
+	Document *model.Node
+}
+
+// ProsemirrorVersionMessage is used to request or restore a document version
+type ProsemirrorVersionMessage struct {
+	Version int64 `json:"version"`
+}
+
+// ProsemirrorVersionInfo describes a saved version of the document
+type ProsemirrorVersionInfo struct {
+	Version int64     `json:"version"`
+	Created time.Time `json:"created"`
+}
+
+// ProsemirrorVersionsResponse is used to send the saved versions of the
+// document to the client
+type ProsemirrorVersionsResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		RequestID string                   `json:"requestId,omitempty"`
+		Version   int64                    `json:"version"`
+		Versions  []ProsemirrorVersionInfo `json:"versions"`
+	} `json:"payload"`
+}
+
+// ProsemirrorVersionResponse is used to send the document content at the
+// requested version to the client
+type ProsemirrorVersionResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		RequestID string          `json:"requestId,omitempty"`
+		Version   int64           `json:"version"`
+		Document  json.RawMessage `json:"doc"`
+	} `json:"payload"`
+}
+
+// restoreStepJSON is the json representation of the replace step used to
+// restore a document version
+type restoreStepJSON struct {
+	StepType StepType         `json:"stepType"`
+	From     int              `json:"from"`
+	To       int              `json:"to"`
+	Slice    *model.SliceJSON `json:"slice,omitempty"`
+}
+
+// handleProsemirrorVersionsMessage will send the list of saved versions of
+// the document back to the client
+func handleProsemirrorVersionsMessage(srv *environment.Services, room *WebsocketRoom,
+	message *Message) {
+
+	snapshots, err := srv.Postgres.ListSnapshots(room.DocumentID)
+	if err != nil {
+		logger.DebugError("could not list document snapshots", err,
+			logger.String("documentid", room.DocumentID))
+		replyProsemirrorError(message, ErrorCodeStorageFailure, -1, room.DocumentVersion,
+			"versions could not be loaded")
+		return
+	}
+
+	response := ProsemirrorVersionsResponse{}
+	response.Type = MessageTypeProsemirrorVersions
+	response.Payload.RequestID = message.RequestID
+	response.Payload.Version = room.DocumentVersion
+	response.Payload.Versions = make([]ProsemirrorVersionInfo, len(snapshots))
+	for i, snapshot := range snapshots {
+		response.Payload.Versions[i] = ProsemirrorVersionInfo{
+			Version: snapshot.Version,
+			Created: snapshot.Created,
+		}
+	}
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		logger.DebugError("could not encode versions response", err)
+		return
+	}
+
+	message.Reply(msg)
+}
+
+// handleProsemirrorVersionMessage will send the content of the document at
+// the requested version back to the client. Previous versions are built
+// outside of the room, as they are loaded from the database
+func handleProsemirrorVersionMessage(srv *environment.Services, room *WebsocketRoom,
+	message *Message) {
+
+	var payload ProsemirrorVersionMessage
+	err := json.Unmarshal(message.Payload, &payload)
+	if err != nil {
+		logger.DebugError("could not decode version message", err)
+		return
+	}
+
+	if payload.Version == room.DocumentVersion && room.Document != nil {
+		sendDocumentVersion(message, payload.Version, room.Document)
+		return
+	}
+
+	if room.Schema == nil || payload.Version < 0 || payload.Version > room.DocumentVersion {
+		replyVersionError(message, payload.Version, room.DocumentVersion, errVersionUnavailable)
+		return
+	}
+
+	request := *message
+	documentID, schema, roomVersion := room.DocumentID, room.Schema, room.DocumentVersion
+
+	go func() {
+		doc, err := documentAtVersion(srv, documentID, schema, payload.Version)
+		if err != nil {
+			replyVersionError(&request, payload.Version, roomVersion, err)
+			return
+		}
+
+		sendDocumentVersion(&request, payload.Version, doc)
+	}()
+}
+
+// sendDocumentVersion will send the given content of the document at the
+// given version to the client
+func sendDocumentVersion(message *Message, version int64, doc *model.Node) {
+
+	var err error
+
+	response := ProsemirrorVersionResponse{}
+	response.Type = MessageTypeProsemirrorVersion
+	response.Payload.RequestID = message.RequestID
+	response.Payload.Version = version
+
+	response.Payload.Document, err = json.Marshal(doc.ToJSON())
+	if err != nil {
+		logger.DebugError("could not encode document version", err)
+		return
+	}
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		logger.DebugError("could not encode version response", err)
+		return
+	}
+
+	message.Reply(msg)
+}
+
+// replyVersionError will inform the client that the requested version could
+// not be built. Storage failures are reported as such, as the version might
+// be available later on
+func replyVersionError(message *Message, version, roomVersion int64, err error) {
+
+	logger.DebugError("could not build document version", err,
+		logger.String("documentid", message.DocumentID),
+		zap.Int64("version", version))
+
+	if errors.Is(err, errVersionUnavailable) {
+		replyProsemirrorError(message, ErrorCodeVersionUnavailable,
+			version, roomVersion, err.Error())
+		return
+	}
+
+	replyProsemirrorError(message, ErrorCodeStorageFailure,
+		version, roomVersion, "version could not be loaded")
+}
+
+// handleProsemirrorRestoreMessage will restore the requested version of the
+// document. The requested version is built outside of the room, as it is
+// loaded from the database, and restored by the room afterwards
+func handleProsemirrorRestoreMessage(srv *environment.Services, room *WebsocketRoom,
+	message *Message) {
+
+	var payload ProsemirrorVersionMessage
+	err := json.Unmarshal(message.Payload, &payload)
+	if err != nil {
+		logger.DebugError("could not decode restore message", err)
+		return
+	}
+
+	if message.Permission < domain.Edit {
+		replyProsemirrorError(message, ErrorCodePermissionDenied,
+			payload.Version, room.DocumentVersion, "no permission to restore a version")
+		return
+	}
+
+	if room.Schema == nil || room.Document == nil || payload.Version < 0 ||
+		payload.Version >= room.DocumentVersion {
+		replyProsemirrorError(message, ErrorCodeVersionUnavailable,
+			payload.Version, room.DocumentVersion, "version can not be restored")
+		return
+	}
+
+	request := *message
+	documentID, schema, roomVersion := room.DocumentID, room.Schema, room.DocumentVersion
+
+	go func() {
+		doc, err := documentAtVersion(srv, documentID, schema, payload.Version)
+		if err != nil {
+			replyVersionError(&request, payload.Version, roomVersion, err)
+			return
+		}
+
+		select {
+		case room.Restore <- &versionRestore{Message: request, Version: payload.Version,
+			Document: doc}:
+		case <-room.Done:
+		}
+	}()
+}
+
+// restoreRoomVersion will restore the given version of the document. The
+// content of the current document is replaced with the content of the
+// version in a single step, that is distributed like any other step and
+// recorded for the user requesting the restore. The step is built again if
+// the step log was modified by another instance in the meantime
+func restoreRoomVersion(srv *environment.Services, room *WebsocketRoom,
+	restore *versionRestore) {
+
+	message := &restore.Message
+
+	for attempt := 1; ; attempt++ {
+
+		// the document might have been dropped or reloaded in the meantime
+		if room.Document == nil || restore.Version >= room.DocumentVersion {
+			replyProsemirrorError(message, ErrorCodeVersionUnavailable,
+				restore.Version, room.DocumentVersion, "version can not be restored")
+			return
+		}
+
+		// replace the whole content of the current document
+		step, err := json.Marshal(&restoreStepJSON{
+			StepType: StepTypeReplace,
+			From:     0,
+			To:       room.Document.Content.Size(),
+			Slice:    model.NewSlice(restore.Document.Content, 0, 0).ToJSON(),
+		})
+		if err != nil {
+			logger.DebugError("could not encode restore step", err)
+			return
+		}
+
+		decoded, err := decodeProsemirrorStep(room.Schema, step)
+		if err != nil {
+			logger.DebugError("could not decode restore step", err)
+			return
+		}
+
+		doc, err := applyProsemirrorSteps(room, []*ProsemirrorStep{decoded})
+		if err != nil {
+			logger.DebugError("restore step could not be applied", err,
+				logger.String("documentid", room.DocumentID))
+			replyProsemirrorError(message, applyErrorCode(err),
+				restore.Version, room.DocumentVersion, err.Error())
+			return
+		}
+
+		stored := []repository.StoredStep{{
+			Step:     step,
+			ClientID: serverClientID,
+			UserID:   message.UserID,
+		}}
+
+		version, err := appendRoomSteps(srv, room, stored)
+
+		if err == repository.ErrVersionConflict {
+			// the step log was modified elsewhere, send the missing steps
+			// to all clients of the room and restore the version based on
+			// the new content
+			logVersion, err := srv.Steps.Version(room.DocumentID)
+			if err == nil {
+				catchUpRoom(srv, room, logVersion)
+			}
+
+			if err == nil && attempt < restoreAttempts {
+				continue
+			}
+
+			replyProsemirrorError(message, ErrorCodeVersionConflict,
+				restore.Version, room.DocumentVersion,
+				"version was not restored due to a version conflict")
+			return
+		}
+
+		if err != nil {
+			logger.DebugError("could not store restore step", err)
+			replyProsemirrorError(message, ErrorCodeStorageFailure,
+				restore.Version, room.DocumentVersion, "version could not be restored")
+			return
+		}
+
+		logger.Info("document version restored",
+			logger.String("documentid", room.DocumentID),
+			logger.String("userid", message.UserID),
+			zap.Int64("restored-version", restore.Version),
+			zap.Int64("version", version))
+
+		// save the links contained in the restored content again
+		steps := []*ProsemirrorStep{decoded}
+		handleSpecialSteps(srv, room, message.UserID, message.Permission, steps)
+
+		distributeRoomSteps(srv, room, message, stored, steps, doc, version, false, true)
+
+		// keep the restored state as saved version of the document. the
+		// snapshot is saved outside of the room, as the restore step is
+		// already stored
+		snapshot := roomSnapshot(room)
+		if snapshot != nil {
+			room.SnapshotVersion = snapshot.Version
+			go saveSnapshot(srv, snapshot)
+		}
+		return
+	}
+}
+
+// documentAtVersion will build the content of the document at the given
+// version from the closest snapshot and the steps accepted afterwards. The
+// function does not access the room, i.e. it may be called outside of the
+// room. Errors wrap errVersionUnavailable unless the storage failed
+func documentAtVersion(srv *environment.Services, documentID string, schema *model.Schema,
+	version int64) (*model.Node, error) {
+
+	snapshot, err := srv.Postgres.FetchSnapshotAt(documentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot == nil {
+		return nil, errVersionUnavailable
+	}
+
+	doc, err := parseSnapshotContent(schema, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errVersionUnavailable, err)
+	}
+
+	stored, err := replaySteps(srv, documentID, snapshot.Version, version)
+	if err == repository.ErrStepsUnavailable || err == repository.ErrNoStepLog {
+		return nil, fmt.Errorf("%w: steps after snapshot not available", errVersionUnavailable)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range stored {
+		step, err := decodeProsemirrorStep(schema, stored[i].Step)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errVersionUnavailable, err)
+		}
+
+		doc, err = step.Step.Apply(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: could not apply step %d: %v",
+				errVersionUnavailable, i, err)
+		}
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,249 @@
+package websocket
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+)
+
+// setTestSnapshot will let the database of the given instance return a
+// snapshot of the test document at the given version for requests of the
+// document at the requested version
+func setTestSnapshot(t *testing.T, instance string, requested, version int64) {
+	t.Helper()
+
+	setTestRows(t, instance, []driver.Value{testDocumentID, requested},
+		[]string{"document_id", "version", "content", "created"},
+		[]driver.Value{testDocumentID, version, []byte(testDocument), time.Now()})
+}
+
+// setTestStepHistory will let the database of the given instance return the
+// given steps as step history after the given version
+func setTestStepHistory(t *testing.T, instance string, from int64, steps ...json.RawMessage) {
+	t.Helper()
+
+	values := make([][]driver.Value, len(steps))
+	for i := range steps {
+		values[i] = []driver.Value{testDocumentID, from + int64(i) + 1, []byte(steps[i]),
+			int64(1), "user-editor", time.Now()}
+	}
+
+	setTestRows(t, instance, []driver.Value{testDocumentID, from, from + int64(len(steps))},
+		[]string{"document_id", "version", "step", "client_id", "user_id", "created"},
+		values...)
+}
+
+// expectDocumentVersion will wait for the content of the document at the
+// given version and compare it with the given document
+func expectDocumentVersion(t *testing.T, client *WebsocketClient, version int64, want string) {
+	t.Helper()
+
+	responses := expectResponses(t, client, MessageTypeProsemirrorVersion)
+
+	var response ProsemirrorVersionResponse
+	decodeTestPayload(t, responses[MessageTypeProsemirrorVersion], &response.Payload)
+	if response.Payload.Version != version {
+		t.Fatalf("expected version %d, got %s", version, responses[MessageTypeProsemirrorVersion])
+	}
+
+	// compare the documents independent of their formatting
+	var got, expected interface{}
+	decodeTestPayload(t, response.Payload.Document, &got)
+	decodeTestPayload(t, json.RawMessage(want), &expected)
+
+	gotJSON, _ := json.Marshal(got)
+	expectedJSON, _ := json.Marshal(expected)
+	if string(gotJSON) != string(expectedJSON) {
+		t.Fatalf("expected document %s, got %s", expectedJSON, gotJSON)
+	}
+}
+
+// testDocumentWithText will return the test document with the given text
+func testDocumentWithText(text string) string {
+	return `{"type":"doc","content":[
+		{"type":"paragraph","content":[{"type":"text","text":"` + text + `"}]}
+	]}`
+}
+
+func TestVersionList(t *testing.T) {
+
+	room := newTestRoom(t, newTestServices(t, repository.NewMemoryStepStore(),
+		repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	setTestRows(t, "a", []driver.Value{testDocumentID},
+		[]string{"document_id", "version", "created"},
+		[]driver.Value{testDocumentID, int64(100), created},
+		[]driver.Value{testDocumentID, int64(0), created})
+
+	sendTestMessage(t, room, editor, MessageTypeProsemirrorVersions, struct{}{})
+	responses := expectResponses(t, editor, MessageTypeProsemirrorVersions)
+
+	var response ProsemirrorVersionsResponse
+	decodeTestPayload(t, responses[MessageTypeProsemirrorVersions], &response.Payload)
+	if len(response.Payload.Versions) != 2 || response.Payload.Versions[0].Version != 100 ||
+		response.Payload.Versions[1].Version != 0 ||
+		!response.Payload.Versions[0].Created.Equal(created) {
+		t.Errorf("unexpected versions: %s", responses[MessageTypeProsemirrorVersions])
+	}
+
+	// storage failures are reported to the client
+	setTestError(t, "a", []driver.Value{testDocumentID}, errors.New("connection lost"))
+
+	sendTestMessage(t, room, editor, MessageTypeProsemirrorVersions, struct{}{})
+	expectTestError(t, editor, ErrorCodeStorageFailure)
+}
+
+func TestDocumentVersion(t *testing.T) {
+
+	room := newTestRoom(t, newTestServices(t, repository.NewMemoryStepStore(),
+		repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"), testReplaceStep(1, "b"))
+	expectTestSteps(t, editor, 0, 2, 2)
+
+	setTestSnapshot(t, "a", 1, 0)
+
+	tests := []struct {
+		name    string
+		version int64
+		want    string
+		code    ErrorCode
+	}{
+		{"current version", 2, testDocumentWithText("bahello"), ""},
+		{"version built from snapshot", 1, testDocumentWithText("ahello"), ""},
+		{"version without snapshot", 0, "", ErrorCodeVersionUnavailable},
+		{"future version", 3, "", ErrorCodeVersionUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			sendTestMessage(t, room, editor, MessageTypeProsemirrorVersion,
+				&ProsemirrorVersionMessage{Version: tt.version})
+
+			if tt.code != "" {
+				expectTestError(t, editor, tt.code)
+				return
+			}
+			expectDocumentVersion(t, editor, tt.version, tt.want)
+		})
+	}
+
+	// storage failures are not reported as unavailable versions
+	setTestError(t, "a", []driver.Value{testDocumentID, int64(1)}, errors.New("connection lost"))
+
+	sendTestMessage(t, room, editor, MessageTypeProsemirrorVersion,
+		&ProsemirrorVersionMessage{Version: 1})
+	expectTestError(t, editor, ErrorCodeStorageFailure)
+}
+
+func TestRestoreVersion(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		expired bool
+	}{
+		{"step log", false},
+		{"expired step log", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			steps := repository.NewMemoryStepStore()
+			room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+			editor := newTestClient("editor", domain.Edit)
+			initTestClient(t, room, editor, 0)
+
+			viewer := newTestClient("viewer", domain.View)
+			initTestClient(t, room, viewer, 0)
+
+			sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"),
+				testReplaceStep(1, "b"))
+			expectTestSteps(t, editor, 0, 2, 2)
+			expectTestSteps(t, viewer, 0, 2, 2)
+
+			setTestSnapshot(t, "a", 1, 0)
+
+			// the steps are replayed from the step history if the step log
+			// expired, the room starts a new step log for the restore step
+			if tt.expired {
+				setTestStepHistory(t, "a", 0, testReplaceStep(1, "a"))
+				err := steps.Expire(testDocumentID, -time.Second)
+				if err != nil {
+					t.Fatalf("could not expire step log: %v", err)
+				}
+			}
+
+			// viewers can not restore versions
+			sendTestMessage(t, room, viewer, MessageTypeProsemirrorRestore,
+				&ProsemirrorVersionMessage{Version: 1})
+			expectTestError(t, viewer, ErrorCodePermissionDenied)
+
+			sendTestMessage(t, room, editor, MessageTypeProsemirrorRestore,
+				&ProsemirrorVersionMessage{Version: 1})
+
+			// the restore step is sent to all clients as step of the server
+			for _, client := range []*WebsocketClient{editor, viewer} {
+				response := expectTestSteps(t, client, 2, 3, 1)
+				if response.Payload.ClientIDs[0] != serverClientID {
+					t.Errorf("expected step of the server, got %v", response.Payload.ClientIDs)
+				}
+			}
+
+			expectStepLogVersion(t, steps, 3)
+
+			sendTestMessage(t, room, editor, MessageTypeProsemirrorVersion,
+				&ProsemirrorVersionMessage{Version: 3})
+			expectDocumentVersion(t, editor, 3, testDocumentWithText("ahello"))
+		})
+	}
+}
+
+func TestRestoreVersionConflict(t *testing.T) {
+
+	steps := &conflictStepStore{MemoryStepStore: repository.NewMemoryStepStore()}
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"), testReplaceStep(1, "b"))
+	expectTestSteps(t, editor, 0, 2, 2)
+
+	setTestSnapshot(t, "a", 1, 0)
+
+	sendTestMessage(t, room, editor, MessageTypeProsemirrorRestore,
+		&ProsemirrorVersionMessage{Version: 1})
+
+	// the step of the other instance is sent first, the version is restored
+	// based on the new content afterwards
+	response := expectTestSteps(t, editor, 2, 3, 1)
+	if response.Payload.ClientIDs[0] != 2 {
+		t.Errorf("expected the step of client 2, got %v", response.Payload.ClientIDs)
+	}
+
+	response = expectTestSteps(t, editor, 3, 4, 1)
+	if response.Payload.ClientIDs[0] != serverClientID {
+		t.Errorf("expected step of the server, got %v", response.Payload.ClientIDs)
+	}
+
+	expectStepLogVersion(t, steps.MemoryStepStore, 4)
+
+	sendTestMessage(t, room, editor, MessageTypeProsemirrorVersion,
+		&ProsemirrorVersionMessage{Version: 4})
+	expectDocumentVersion(t, editor, 4, testDocumentWithText("ahello"))
+}
@@ -0,0 +1,281 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"dkfbasel.ch/orca/collaboration/src/internal/attribution"
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"dkfbasel.ch/orca/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// steps can not be attributed without the schema of the room
+var errSchemaUnknown = errors.New("document schema of the room is unknown")
+
+// ProsemirrorBlameMessage is used to request the authors of the content
+// between the given positions. The whole document is used if the positions
+// are omitted
+type ProsemirrorBlameMessage struct {
+	From *int `json:"from"`
+	To   *int `json:"to"`
+}
+
+// ProsemirrorBlameSpan describes a range of the document written by a
+// single user. The user id is empty if the author is not known
+type ProsemirrorBlameSpan struct {
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	UserID string `json:"userId"`
+	Text   string `json:"text"`
+}
+
+// ProsemirrorBlameResponse is used to send the authors of the requested
+// range back to the client. The positions refer to the given version
+type ProsemirrorBlameResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		RequestID string                 `json:"requestId,omitempty"`
+		Version   int64                  `json:"version"`
+		Spans     []ProsemirrorBlameSpan `json:"spans"`
+	} `json:"payload"`
+}
+
+// attributionRebuild is an attribution of the whole document, that was
+// rebuilt outside of the room and must be completed by the room
+type attributionRebuild struct {
+	Tracker *attribution.Tracker
+	Err     error
+}
+
+// handleProsemirrorBlameMessage will send the authors of the requested range
+// of the current document back to the client. If the attribution of the
+// room must be rebuilt, the rebuild is done outside of the room and the
+// request is answered once the attribution is handed back to the room
+func handleProsemirrorBlameMessage(srv *environment.Services, room *WebsocketRoom,
+	message *Message) {
+
+	_, _, ok := blameRange(room, message)
+	if !ok {
+		return
+	}
+
+	// continue with the steps accepted since the last update
+	tracker := room.Attribution
+	if tracker != nil && tracker.Version <= room.DocumentVersion &&
+		applyAttribution(srv, room, tracker) == nil {
+		replyProsemirrorBlame(room, message)
+		return
+	}
+
+	// requests received during a rebuild are answered with its result
+	room.BlameRequests = append(room.BlameRequests, *message)
+	if len(room.BlameRequests) > 1 {
+		return
+	}
+
+	if room.Schema == nil {
+		completeAttribution(srv, room, &attributionRebuild{Err: errSchemaUnknown})
+		return
+	}
+
+	documentID, schema, roomVersion := room.DocumentID, room.Schema, room.DocumentVersion
+
+	go func() {
+		tracker, err := rebuildAttribution(srv, documentID, schema, roomVersion)
+
+		select {
+		case room.Attributed <- &attributionRebuild{Tracker: tracker, Err: err}:
+		case <-room.Done:
+		}
+	}()
+}
+
+// blameRange will return the range requested by the given blame message. An
+// error is sent back to the client if the range is not valid
+func blameRange(room *WebsocketRoom, message *Message) (int, int, bool) {
+
+	var payload ProsemirrorBlameMessage
+	err := json.Unmarshal(message.Payload, &payload)
+	if err != nil {
+		logger.DebugError("could not decode blame message", err)
+		return 0, 0, false
+	}
+
+	if room.Document == nil {
+		replyProsemirrorError(message, ErrorCodeVersionUnavailable, -1,
+			room.DocumentVersion, "document content is not known")
+		return 0, 0, false
+	}
+
+	size := room.Document.Content.Size()
+
+	from, to := 0, size
+	if payload.From != nil {
+		from = *payload.From
+	}
+	if payload.To != nil {
+		to = *payload.To
+	}
+
+	if from < 0 || to > size || from > to {
+		replyProsemirrorError(message, ErrorCodeInvalidStep, -1,
+			room.DocumentVersion, "invalid range requested")
+		return 0, 0, false
+	}
+
+	return from, to, true
+}
+
+// replyProsemirrorBlame will send the authors of the requested range to the
+// client, using the attribution of the room at the current version
+func replyProsemirrorBlame(room *WebsocketRoom, message *Message) {
+
+	from, to, ok := blameRange(room, message)
+	if !ok {
+		return
+	}
+
+	response := ProsemirrorBlameResponse{}
+	response.Type = MessageTypeProsemirrorBlame
+	response.Payload.RequestID = message.RequestID
+	response.Payload.Version = room.DocumentVersion
+
+	spans := room.Attribution.Blame(from, to)
+	response.Payload.Spans = make([]ProsemirrorBlameSpan, len(spans))
+	for i, span := range spans {
+		response.Payload.Spans[i] = ProsemirrorBlameSpan{
+			From:   span.From,
+			To:     span.To,
+			UserID: span.UserID,
+			Text:   room.Document.TextBetween(span.From, span.To, "\n", ""),
+		}
+	}
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		logger.DebugError("could not encode blame response", err)
+		return
+	}
+
+	message.Reply(msg)
+}
+
+// completeAttribution will bring the rebuilt attribution up to the current
+// version of the room and answer all blame requests waiting for it
+func completeAttribution(srv *environment.Services, room *WebsocketRoom,
+	rebuild *attributionRebuild) {
+
+	requests := room.BlameRequests
+	room.BlameRequests = nil
+
+	err := rebuild.Err
+	if err == nil && (room.Document == nil || rebuild.Tracker.Version > room.DocumentVersion) {
+		// the document was dropped or reset in the meantime
+		err = repository.ErrStepsUnavailable
+	}
+	if err == nil {
+		err = applyAttribution(srv, room, rebuild.Tracker)
+	}
+
+	if err != nil {
+		logger.DebugError("could not update attribution", err,
+			logger.String("documentid", room.DocumentID))
+
+		for i := range requests {
+			replyProsemirrorError(&requests[i], ErrorCodeVersionUnavailable, -1,
+				room.DocumentVersion, "authors could not be determined")
+		}
+		return
+	}
+
+	for i := range requests {
+		replyProsemirrorBlame(room, &requests[i])
+	}
+}
+
+// rebuildAttribution will attribute all changes of the document up to the
+// given version using the step history and fall back to the steps of the
+// step log. The function does not access the room, i.e. it may be called
+// outside of the room
+func rebuildAttribution(srv *environment.Services, documentID string,
+	schema *model.Schema, version int64) (*attribution.Tracker, error) {
+
+	// the starting version is kept separately, as the tracker moves to
+	// the given version
+	start := int64(0)
+	tracker := attribution.NewTracker(start)
+	err := attributeSteps(srv, documentID, schema, tracker, version)
+	if err != nil {
+		start, err = srv.Steps.StartingVersion(documentID)
+		if err != nil {
+			return nil, err
+		}
+
+		tracker = attribution.NewTracker(start)
+		err = attributeSteps(srv, documentID, schema, tracker, version)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Debug("attribution initialized",
+		zap.Int64("from-version", start),
+		zap.Int64("room-version", version),
+		zap.String("documentid", documentID))
+
+	return tracker, nil
+}
+
+// applyAttribution will apply all steps from the version of the tracker to
+// the current version of the room to the tracker
+func applyAttribution(srv *environment.Services, room *WebsocketRoom,
+	tracker *attribution.Tracker) error {
+
+	if tracker.Version != room.DocumentVersion {
+		if room.Schema == nil {
+			return errSchemaUnknown
+		}
+
+		err := attributeSteps(srv, room.DocumentID, room.Schema, tracker, room.DocumentVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	room.Attribution = tracker
+	return nil
+}
+
+// attributeSteps will apply all steps from the version of the tracker to
+// the given version of the document to the tracker. The tracker is not
+// modified if any of the steps is not available
+func attributeSteps(srv *environment.Services, documentID string, schema *model.Schema,
+	tracker *attribution.Tracker, version int64) error {
+
+	stored, err := replaySteps(srv, documentID, tracker.Version, version)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(stored)) != version-tracker.Version {
+		return repository.ErrStepsUnavailable
+	}
+
+	steps := make([]*ProsemirrorStep, len(stored))
+	for i := range stored {
+		steps[i], err = decodeProsemirrorStep(schema, stored[i].Step)
+		if err != nil {
+			return fmt.Errorf("could not parse step %d: %w", i, err)
+		}
+	}
+
+	for i, step := range steps {
+		tracker.Apply(step.Step.GetMap(), stored[i].UserID)
+	}
+
+	return nil
+}
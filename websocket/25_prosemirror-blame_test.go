@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"reflect"
+	"testing"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+)
+
+// expectTestBlame will wait for the authors of the requested range and
+// compare them with the given spans
+func expectTestBlame(t *testing.T, client *WebsocketClient, version int64,
+	want []ProsemirrorBlameSpan) {
+	t.Helper()
+
+	responses := expectResponses(t, client, MessageTypeProsemirrorBlame)
+
+	var response ProsemirrorBlameResponse
+	decodeTestPayload(t, responses[MessageTypeProsemirrorBlame], &response.Payload)
+	if response.Payload.Version != version {
+		t.Errorf("expected version %d, got %s", version, responses[MessageTypeProsemirrorBlame])
+	}
+	if !reflect.DeepEqual(response.Payload.Spans, want) {
+		t.Errorf("expected spans %+v, got %+v", want, response.Payload.Spans)
+	}
+}
+
+func TestBlame(t *testing.T) {
+
+	room := newTestRoom(t, newTestServices(t, repository.NewMemoryStepStore(),
+		repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+	other := newTestClient("other", domain.Edit)
+	initTestClient(t, room, other, 0)
+
+	sendTestSteps(t, room, editor, 1, 0, testReplaceStep(1, "a"))
+	expectTestSteps(t, editor, 0, 1, 1)
+
+	// the attribution is rebuilt outside of the room, requests received in
+	// the meantime are answered with the same attribution
+	spans := []ProsemirrorBlameSpan{
+		{From: 0, To: 1, Text: ""},
+		{From: 1, To: 2, UserID: "user-editor", Text: "a"},
+		{From: 2, To: 8, Text: "hello"},
+	}
+
+	sendTestMessage(t, room, editor, MessageTypeProsemirrorBlame, &ProsemirrorBlameMessage{})
+	sendTestMessage(t, room, other, MessageTypeProsemirrorBlame, &ProsemirrorBlameMessage{})
+	expectTestBlame(t, editor, 1, spans)
+	expectTestBlame(t, other, 1, spans)
+
+	// the attribution of the room is continued with the new steps
+	sendTestSteps(t, room, other, 2, 1, testReplaceStep(2, "b"))
+	expectTestSteps(t, other, 1, 2, 1)
+
+	from, to := 1, 3
+	sendTestMessage(t, room, editor, MessageTypeProsemirrorBlame,
+		&ProsemirrorBlameMessage{From: &from, To: &to})
+	expectTestBlame(t, editor, 2, []ProsemirrorBlameSpan{
+		{From: 1, To: 2, UserID: "user-editor", Text: "a"},
+		{From: 2, To: 3, UserID: "user-other", Text: "b"},
+	})
+
+	// ranges outside of the document are rejected
+	to = 20
+	sendTestMessage(t, room, editor, MessageTypeProsemirrorBlame,
+		&ProsemirrorBlameMessage{From: &from, To: &to})
+	expectTestError(t, editor, ErrorCodeInvalidStep)
+}
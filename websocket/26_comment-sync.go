@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"encoding/json"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"dkfbasel.ch/orca/pkg/logger"
+)
+
+// CommentsSyncResponse is used to send all comment threads of the document
+// to a client that joined the room
+type CommentsSyncResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		Version  int64                  `json:"version"`
+		Comments []domain.CommentThread `json:"comments"`
+	} `json:"payload"`
+}
+
+// CommentEventResponse is used to inform all clients about the current
+// state of a comment thread that was changed by a comment step
+type CommentEventResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		Event   string                `json:"event"`
+		Comment *domain.CommentThread `json:"comment"`
+	} `json:"payload"`
+}
+
+// sendCommentsSync will send all comment threads of the document to the
+// sender of the message
+func sendCommentsSync(srv *environment.Services, room *WebsocketRoom, message *Message) {
+
+	comments, err := srv.Postgres.FetchComments(room.DocumentID)
+	if err != nil {
+		logger.DebugError("could not fetch comments", err,
+			logger.String("documentid", room.DocumentID))
+		replyProsemirrorError(message, ErrorCodeStorageFailure, -1,
+			room.DocumentVersion, "comments could not be loaded")
+		return
+	}
+
+	response := CommentsSyncResponse{}
+	response.Type = MessageTypeCommentsSync
+	response.Payload.Version = room.DocumentVersion
+	response.Payload.Comments = comments
+
+	msg, err := json.Marshal(&response)
+	if err != nil {
+		logger.DebugError("could not encode comments sync response", err)
+		return
+	}
+
+	message.Reply(msg)
+}
+
+// broadcastCommentEvents will send the current state of all comment threads
+// changed by the given steps to all clients of the room and inform the
+// rooms of other instances with a single message. The server ids of new
+// preliminary comments are sent before the comment events
+func broadcastCommentEvents(srv *environment.Services, room *WebsocketRoom,
+	steps []*ProsemirrorStep) {
+
+	ids := make(map[string]string)
+	var messages []json.RawMessage
+
+	for _, decoded := range steps {
+
+		if decoded.Type != StepTypeComment {
+			continue
+		}
+
+		commentID := commentStepID(decoded.Custom)
+		if commentID == "" {
+			continue
+		}
+
+		// inform the clients about the server id of new preliminary
+		// comments. comments that could not be resolved are not persisted
+		if repository.IsPreliminaryComment(commentID) {
+			id := resolveCommentID(srv, room, commentID)
+			if id == commentID {
+				continue
+			}
+
+			if decoded.Custom.Type == "addComment" {
+				ids[commentID] = id
+			}
+
+			commentID = id
+		}
+
+		comment, err := srv.Postgres.FetchComment(room.DocumentID, commentID)
+		if err != nil {
+			logger.DebugError("could not fetch comment", err,
+				logger.String("documentid", room.DocumentID),
+				logger.String("commentid", commentID))
+			continue
+		}
+
+		if comment == nil {
+			continue
+		}
+
+		event := CommentEventResponse{}
+		event.Type = MessageTypeCommentEvent
+		event.Payload.Event = decoded.Custom.Type
+		event.Payload.Comment = comment
+
+		msg, err := json.Marshal(&event)
+		if err != nil {
+			logger.DebugError("could not encode comment event", err)
+			continue
+		}
+
+		messages = append(messages, msg)
+	}
+
+	if len(ids) > 0 {
+		msg, err := encodeCommentIDs(ids)
+		if err != nil {
+			logger.DebugError("could not encode comment id response", err)
+		} else {
+			messages = append([]json.RawMessage{msg}, messages...)
+		}
+	}
+
+	if len(messages) == 0 {
+		return
+	}
+
+	for _, msg := range messages {
+		broadcastRoom(room, msg)
+	}
+
+	publishRoomSync(srv, room, &RoomSyncMessage{
+		BaseVersion: room.DocumentVersion,
+		Version:     room.DocumentVersion,
+		Comments:    messages,
+	})
+}
+
+// commentStepID will return the id of the comment that is changed by the
+// given comment step
+func commentStepID(step *ProsemirrorCustomStep) string {
+
+	var payload struct {
+		ID        string `json:"id"`
+		CommentID string `json:"commentId"`
+	}
+
+	err := json.Unmarshal(step.Payload, &payload)
+	if err != nil {
+		return ""
+	}
+
+	// replies reference the comment they belong to
+	if payload.CommentID != "" {
+		return payload.CommentID
+	}
+
+	return payload.ID
+}
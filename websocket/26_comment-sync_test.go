@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+)
+
+// testCommentStep will return a comment step of the given type
+func testCommentStep(commentType, payload string) json.RawMessage {
+	return json.RawMessage(fmt.Sprintf(`{"stepType":"comment","type":%q,"payload":%s}`,
+		commentType, payload))
+}
+
+// setTestComment will let the database of the given instance return a comment
+// thread with the given id
+func setTestComment(t *testing.T, instance, commentID string) {
+	t.Helper()
+
+	setTestRows(t, instance, []driver.Value{testDocumentID, commentID},
+		[]string{"id", "author_id", "message", "origin", "created"},
+		[]driver.Value{commentID, "user-editor", "comment", "", time.Now()})
+}
+
+// expectCommentEvent will wait for a comment event sent to the client and
+// check the changed comment thread
+func expectCommentEvent(t *testing.T, client *WebsocketClient, event, commentID string) {
+	t.Helper()
+
+	responses := expectResponses(t, client, MessageTypeCommentEvent)
+
+	var response CommentEventResponse
+	decodeTestPayload(t, responses[MessageTypeCommentEvent], &response.Payload)
+	if response.Payload.Event != event || response.Payload.Comment == nil ||
+		response.Payload.Comment.ID != commentID {
+		t.Fatalf("expected %s event of comment %s, got %s", event, commentID,
+			responses[MessageTypeCommentEvent])
+	}
+}
+
+func TestCommentsSync(t *testing.T) {
+
+	room := newTestRoom(t, newTestServices(t, repository.NewMemoryStepStore(),
+		repository.NewMemoryBroker(), "a"))
+
+	// clients receive the comment threads of the document on init
+	editor := newTestClient("editor", domain.Edit)
+	responses := initTestClient(t, room, editor, 0, MessageTypeCommentsSync)
+
+	var response CommentsSyncResponse
+	decodeTestPayload(t, responses[MessageTypeCommentsSync], &response.Payload)
+	if response.Payload.Version != 0 || response.Payload.Comments == nil {
+		t.Errorf("unexpected comments sync: %s", responses[MessageTypeCommentsSync])
+	}
+}
+
+func TestCommentEvents(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	broker := repository.NewMemoryBroker()
+
+	local := newTestServices(t, steps, broker, "a")
+	room := newTestRoom(t, local)
+	setTestComment(t, "a", "c1")
+
+	remote := newTestRoom(t, newTestServices(t, steps, broker, "b"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	other := newTestClient("other", domain.Edit)
+	initTestClient(t, room, other, 0)
+
+	viewer := newTestClient("viewer", domain.View)
+	initTestClient(t, remote, viewer, 0)
+
+	sendTestSteps(t, room, editor, 1, 0,
+		testCommentStep("setCommentDone", `{"id":"c1"}`))
+	expectTestSteps(t, editor, 0, 1, 1)
+
+	// the changed comment thread is sent to all clients of the document,
+	// including the clients of other instances
+	for _, client := range []*WebsocketClient{editor, other, viewer} {
+		expectCommentEvent(t, client, "setCommentDone", "c1")
+	}
+}
+
+func TestManyCommentEvents(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+	setTestComment(t, "a", "c1")
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	// send more comment steps in one batch than the broadcast channel of
+	// the room can hold
+	count := cap(room.Broadcast) + 10
+	batch := make([]json.RawMessage, count)
+	for i := range batch {
+		batch[i] = testCommentStep("setCommentDone", `{"id":"c1"}`)
+	}
+
+	sendTestSteps(t, room, editor, 1, 0, batch...)
+	expectTestSteps(t, editor, 0, int64(count), count)
+
+	for i := 0; i < count; i++ {
+		expectCommentEvent(t, editor, "setCommentDone", "c1")
+	}
+
+	// the room is still handling messages
+	sendTestSteps(t, room, editor, 1, int64(count), testReplaceStep(1, "a"))
+	expectTestSteps(t, editor, int64(count), int64(count)+1, 1)
+
+	expectStepLogVersion(t, steps, int64(count)+1)
+}
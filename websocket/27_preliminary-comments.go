@@ -0,0 +1,223 @@
+package websocket
+
+import (
+	"encoding/json"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"dkfbasel.ch/orca/pkg/logger"
+)
+
+// CommentIDResponse is used to inform all clients about the ids assigned by
+// the server to comments with a preliminary id, mapped by the preliminary id.
+// Clients must replace the preliminary ids in the comment marks and thread
+// references
+type CommentIDResponse struct {
+	Type    MessageType `json:"type"`
+	Payload struct {
+		IDs map[string]string `json:"ids"`
+	} `json:"payload"`
+}
+
+// markStepJSON is the json representation of the mark steps used to replace
+// the preliminary ids of comment marks
+type markStepJSON struct {
+	StepType StepType       `json:"stepType"`
+	From     int            `json:"from"`
+	To       int            `json:"to"`
+	Mark     model.MarkJSON `json:"mark"`
+}
+
+// savePreliminaryComment will persist the given comment with a new id
+// assigned by the server. Comments that were already saved, i.e. if the
+// step is sent again, are not saved twice
+func savePreliminaryComment(srv *environment.Services, room *WebsocketRoom,
+	comment *domain.CommentAdd) error {
+
+	if id := resolveCommentID(srv, room, comment.ID); id != comment.ID {
+		return nil
+	}
+
+	id, err := srv.Postgres.SavePreliminaryComment(comment)
+	if err != nil {
+		logger.DebugError("could not add preliminary comment", err,
+			logger.String("documentid", room.DocumentID))
+		return err
+	}
+
+	room.CommentIDs[comment.ID] = id
+
+	logger.Debug("preliminary comment resolved",
+		logger.String("preliminaryid", comment.ID),
+		logger.String("commentid", id),
+		logger.String("documentid", room.DocumentID))
+
+	return nil
+}
+
+// resolveCommentID will return the id assigned by the server to the comment
+// with the given preliminary id. The given id is returned if it is not
+// preliminary or if the comment is not known
+func resolveCommentID(srv *environment.Services, room *WebsocketRoom, id string) string {
+
+	if !repository.IsPreliminaryComment(id) {
+		return id
+	}
+
+	if resolved, ok := room.CommentIDs[id]; ok {
+		return resolved
+	}
+
+	// the comment might have been saved by another instance or before the
+	// room was initialized
+	resolved, err := srv.Postgres.FetchPreliminaryCommentID(room.DocumentID, id)
+	if err != nil {
+		logger.DebugError("could not resolve preliminary comment", err,
+			logger.String("documentid", room.DocumentID))
+		return id
+	}
+
+	if resolved == "" {
+		return id
+	}
+
+	room.CommentIDs[id] = resolved
+	return resolved
+}
+
+// encodeCommentIDs will return the message informing the clients about the
+// server ids of the given preliminary comments
+func encodeCommentIDs(ids map[string]string) (json.RawMessage, error) {
+
+	response := CommentIDResponse{}
+	response.Type = MessageTypeCommentID
+	response.Payload.IDs = ids
+
+	return json.Marshal(&response)
+}
+
+// rewritePreliminaryComments will replace the preliminary ids of the comment
+// marks added with the given steps by the ids assigned by the server. The
+// marks are changed with steps of the server, so that all copies of the
+// document use the same ids
+func rewritePreliminaryComments(srv *environment.Services, room *WebsocketRoom,
+	message *Message, steps []*ProsemirrorStep) {
+
+	ids := make(map[string]string)
+	for _, step := range steps {
+		if step.Type != StepTypeComment || step.Custom.Type != "addComment" {
+			continue
+		}
+
+		preliminaryID := commentStepID(step.Custom)
+		if !repository.IsPreliminaryComment(preliminaryID) {
+			continue
+		}
+
+		if id := resolveCommentID(srv, room, preliminaryID); id != preliminaryID {
+			ids[preliminaryID] = id
+		}
+	}
+
+	// the marks can only be found if the room knows its document. clients
+	// still receive the comment id event in this case
+	if len(ids) == 0 || room.Document == nil {
+		return
+	}
+
+	raw := commentRewriteSteps(room.Document, ids)
+	if len(raw) == 0 {
+		return
+	}
+
+	decoded, err := decodeProsemirrorSteps(room, raw)
+	if err != nil {
+		logger.DebugError("could not decode comment rewrite steps", err,
+			logger.String("documentid", room.DocumentID))
+		return
+	}
+
+	doc, err := applyProsemirrorSteps(room, decoded)
+	if err != nil {
+		logger.DebugError("comment rewrite steps could not be applied", err,
+			logger.String("documentid", room.DocumentID))
+		return
+	}
+
+	stored := make([]repository.StoredStep, len(raw))
+	for i := range raw {
+		stored[i] = repository.StoredStep{
+			Step:     raw[i],
+			ClientID: serverClientID,
+			UserID:   message.UserID,
+		}
+	}
+
+	version, err := appendRoomSteps(srv, room, stored)
+
+	if err == repository.ErrVersionConflict {
+		// the step log was modified elsewhere. the marks keep their
+		// preliminary id, that is still resolved by the server
+		version, err = srv.Steps.Version(room.DocumentID)
+		if err == nil {
+			catchUpRoom(srv, room, version)
+		}
+		return
+	}
+
+	if err != nil {
+		logger.DebugError("could not store comment rewrite steps", err,
+			logger.String("documentid", room.DocumentID))
+		return
+	}
+
+	distributeRoomSteps(srv, room, nil, stored, decoded, doc, version, false, false)
+}
+
+// commentRewriteSteps will return the steps to replace the ids of all comment
+// marks in the given document with the respective ids of the given map
+func commentRewriteSteps(doc *model.Node, ids map[string]string) []json.RawMessage {
+
+	var steps []json.RawMessage
+
+	doc.NodesBetween(0, doc.Content.Size(),
+		func(node *model.Node, pos int, parent *model.Node, index int) bool {
+
+			for _, mark := range node.Marks {
+				if mark.Type.Name != "comment" {
+					continue
+				}
+
+				preliminaryID, _ := mark.Attrs["id"].(string)
+				id, ok := ids[preliminaryID]
+				if !ok {
+					continue
+				}
+
+				attrs := make(map[string]interface{}, len(mark.Attrs))
+				for key, value := range mark.Attrs {
+					attrs[key] = value
+				}
+				attrs["id"] = id
+
+				from, to := pos, pos+node.NodeSize()
+				for _, step := range []markStepJSON{
+					{StepTypeRemoveMark, from, to, mark.ToJSON()},
+					{StepTypeAddMark, from, to, model.MarkJSON{Type: mark.Type.Name, Attrs: attrs}},
+				} {
+					raw, err := json.Marshal(&step)
+					if err != nil {
+						logger.DebugError("could not encode comment rewrite step", err)
+						continue
+					}
+					steps = append(steps, raw)
+				}
+			}
+
+			return true
+		})
+
+	return steps
+}
@@ -0,0 +1,265 @@
+package websocket
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/model"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+)
+
+// conflictStepStore is a step log, that appends a step of another instance
+// before the first steps of the server are appended
+type conflictStepStore struct {
+	*repository.MemoryStepStore
+	conflict bool
+}
+
+func (s *conflictStepStore) Append(documentID string, baseVersion int64,
+	steps []repository.StoredStep, expiration time.Duration) (int64, error) {
+
+	if !s.conflict && len(steps) > 0 && steps[0].ClientID == serverClientID {
+		s.conflict = true
+		_, err := s.MemoryStepStore.Append(documentID, baseVersion, []repository.StoredStep{
+			{Step: testReplaceStep(1, "x"), ClientID: 2, UserID: "remote"},
+		}, expiration)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return s.MemoryStepStore.Append(documentID, baseVersion, steps, expiration)
+}
+
+// testCommentMarkStep will return a step adding a comment mark with the
+// given id to the text of the test document
+func testCommentMarkStep(commentID string) json.RawMessage {
+	return json.RawMessage(fmt.Sprintf(`{"stepType":"addMark","from":1,"to":6,`+
+		`"mark":{"type":"comment","attrs":{"id":%q}}}`, commentID))
+}
+
+// sendTestPreliminaryComment will add a comment with the given preliminary
+// id, that is saved with the given server id
+func sendTestPreliminaryComment(t *testing.T, room *WebsocketRoom, client *WebsocketClient,
+	preliminaryID, id string) {
+	t.Helper()
+
+	setTestRows(t, "a",
+		[]driver.Value{preliminaryID, client.UserID, "comment", "", testDocumentID},
+		[]string{"id"}, []driver.Value{id})
+	setTestComment(t, "a", id)
+
+	sendTestSteps(t, room, client, 1, 0, testCommentMarkStep(preliminaryID),
+		testCommentStep("addComment", fmt.Sprintf(`{"id":%q,"message":"comment"}`,
+			preliminaryID)))
+	expectTestSteps(t, client, 0, 2, 2)
+
+	// the server id is sent before the comment event
+	responses := expectResponses(t, client, MessageTypeCommentID)
+
+	var response CommentIDResponse
+	decodeTestPayload(t, responses[MessageTypeCommentID], &response.Payload)
+	if len(response.Payload.IDs) != 1 || response.Payload.IDs[preliminaryID] != id {
+		t.Errorf("expected id %s for %s, got %s", id, preliminaryID,
+			responses[MessageTypeCommentID])
+	}
+
+	expectCommentEvent(t, client, "addComment", id)
+}
+
+func TestPreliminaryComment(t *testing.T) {
+
+	steps := repository.NewMemoryStepStore()
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	sendTestPreliminaryComment(t, room, editor, "preliminary-1", "c1")
+
+	// the preliminary id of the comment mark is replaced with steps of
+	// the server
+	response := expectTestSteps(t, editor, 2, 4, 2)
+
+	for i, want := range []struct {
+		stepType StepType
+		id       string
+	}{
+		{StepTypeRemoveMark, "preliminary-1"},
+		{StepTypeAddMark, "c1"},
+	} {
+		var step markStepJSON
+		decodeTestPayload(t, response.Payload.Steps[i], &step)
+		if step.StepType != want.stepType || step.From != 1 || step.To != 6 ||
+			step.Mark.Type != "comment" || step.Mark.Attrs["id"] != want.id {
+			t.Errorf("expected %s step of comment %s, got %s", want.stepType, want.id,
+				response.Payload.Steps[i])
+		}
+		if response.Payload.ClientIDs[i] != serverClientID {
+			t.Errorf("expected steps of the server, got %v", response.Payload.ClientIDs)
+		}
+	}
+
+	expectStepLogVersion(t, steps, 4)
+}
+
+func TestPreliminaryCommentConflict(t *testing.T) {
+
+	steps := &conflictStepStore{MemoryStepStore: repository.NewMemoryStepStore()}
+	room := newTestRoom(t, newTestServices(t, steps, repository.NewMemoryBroker(), "a"))
+
+	editor := newTestClient("editor", domain.Edit)
+	initTestClient(t, room, editor, 0)
+
+	sendTestPreliminaryComment(t, room, editor, "preliminary-1", "c1")
+
+	// the steps replacing the comment marks are not stored, the clients
+	// receive the steps of the other instance instead
+	response := expectTestSteps(t, editor, 2, 3, 1)
+	if response.Payload.ClientIDs[0] != 2 {
+		t.Errorf("expected the steps of client 2, got %v", response.Payload.ClientIDs)
+	}
+
+	expectStepLogVersion(t, steps.MemoryStepStore, 3)
+
+	// the comment mark keeps its preliminary id, that is still resolved
+	sendTestSteps(t, room, editor, 1, 3,
+		testCommentStep("setCommentDone", `{"id":"preliminary-1"}`))
+	expectTestSteps(t, editor, 3, 4, 1)
+	expectCommentEvent(t, editor, "setCommentDone", "c1")
+}
+
+func TestResolveCommentID(t *testing.T) {
+
+	srv := newTestServices(t, repository.NewMemoryStepStore(),
+		repository.NewMemoryBroker(), "a")
+	setTestRows(t, "a", []driver.Value{testDocumentID, "preliminary-saved"},
+		[]string{"id"}, []driver.Value{"c3"})
+
+	room := &WebsocketRoom{
+		DocumentID: testDocumentID,
+		CommentIDs: map[string]string{"preliminary-known": "c2"},
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		want string
+	}{
+		{"server id", "c1", "c1"},
+		{"known preliminary id", "preliminary-known", "c2"},
+		{"preliminary id saved before", "preliminary-saved", "c3"},
+		{"unknown preliminary id", "preliminary-unknown", "preliminary-unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveCommentID(srv, room, tt.id); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	// ids fetched from the database are kept by the room
+	if room.CommentIDs["preliminary-saved"] != "c3" {
+		t.Errorf("expected resolved id to be kept, got %v", room.CommentIDs)
+	}
+	if _, ok := room.CommentIDs["preliminary-unknown"]; ok {
+		t.Errorf("expected unknown id not to be kept, got %v", room.CommentIDs)
+	}
+}
+
+func TestCommentRewriteSteps(t *testing.T) {
+
+	schema := parseTestSchema(t)
+
+	// the paragraph contains the texts "he", "ll" and "o" at 1-3, 3-5 and 5-6
+	var raw model.NodeJSON
+	decodeTestPayload(t, json.RawMessage(`{"type":"doc","content":[
+		{"type":"paragraph","content":[
+			{"type":"text","text":"he"},
+			{"type":"text","text":"ll","marks":[
+				{"type":"em"},
+				{"type":"comment","attrs":{"id":"preliminary-1"}}
+			]},
+			{"type":"text","text":"o","marks":[
+				{"type":"comment","attrs":{"id":"preliminary-2"}}
+			]}
+		]}
+	]}`), &raw)
+
+	doc, err := model.NodeFromJSON(schema, raw)
+	if err != nil {
+		t.Fatalf("could not parse document: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ids  map[string]string
+		want []markStepJSON
+	}{
+		{
+			name: "no resolved comments",
+			ids:  map[string]string{},
+		},
+		{
+			name: "unknown comment",
+			ids:  map[string]string{"preliminary-3": "c3"},
+		},
+		{
+			name: "single comment",
+			ids:  map[string]string{"preliminary-1": "c1"},
+			want: []markStepJSON{
+				{StepTypeRemoveMark, 3, 5, model.MarkJSON{Type: "comment",
+					Attrs: map[string]interface{}{"id": "preliminary-1"}}},
+				{StepTypeAddMark, 3, 5, model.MarkJSON{Type: "comment",
+					Attrs: map[string]interface{}{"id": "c1"}}},
+			},
+		},
+		{
+			name: "multiple comments",
+			ids:  map[string]string{"preliminary-1": "c1", "preliminary-2": "c2"},
+			want: []markStepJSON{
+				{StepTypeRemoveMark, 3, 5, model.MarkJSON{Type: "comment",
+					Attrs: map[string]interface{}{"id": "preliminary-1"}}},
+				{StepTypeAddMark, 3, 5, model.MarkJSON{Type: "comment",
+					Attrs: map[string]interface{}{"id": "c1"}}},
+				{StepTypeRemoveMark, 5, 6, model.MarkJSON{Type: "comment",
+					Attrs: map[string]interface{}{"id": "preliminary-2"}}},
+				{StepTypeAddMark, 5, 6, model.MarkJSON{Type: "comment",
+					Attrs: map[string]interface{}{"id": "c2"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			steps := commentRewriteSteps(doc, tt.ids)
+			if len(steps) != len(tt.want) {
+				t.Fatalf("expected %d steps, got %d", len(tt.want), len(steps))
+			}
+
+			for i := range steps {
+				want, err := json.Marshal(&tt.want[i])
+				if err != nil {
+					t.Fatalf("could not encode step: %v", err)
+				}
+				if string(steps[i]) != string(want) {
+					t.Errorf("expected step %s, got %s", want, steps[i])
+				}
+			}
+
+			// the steps must be valid for the schema of the document
+			for _, raw := range steps {
+				if _, err := decodeProsemirrorStep(schema, raw); err != nil {
+					t.Errorf("could not decode step %s: %v", raw, err)
+				}
+			}
+		})
+	}
+}
@@ -1,27 +1,100 @@
 package websocket
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/internal/health"
+	"dkfbasel.ch/orca/collaboration/src/internal/metrics"
 	"dkfbasel.ch/orca/pkg/logger"
 )
 
+// time to wait for dependencies to respond to readiness checks. the timeout
+// must be shorter than the default probe timeout of kubernetes (1s)
+const healthTimeout = time.Millisecond * 800
+
+// Server is used to handle websocket connections
+type Server struct {
+	*http.Server
+	hub     *WebsocketHub
+	checker *health.Checker
+}
+
 // NewServer will initialize a new server to handle websocket connections
-func NewServer(srv *environment.Services) *http.Server {
+func NewServer(srv *environment.Services, config environment.WebsocketConfig) *Server {
 
 	// initialize a hub for connections
-	hub := newHub(srv)
-
-	return &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			err := wsHandler(hub, w, r)
-			if err != nil {
-				logger.DebugError("websocket handler,", err)
-			}
-		}),
+	hub := newHub(srv, config)
+
+	mux := http.NewServeMux()
+
+	// expose metrics in the prometheus text format
+	mux.Handle("/metrics", metrics.Handler())
+
+	// report if the service is running and if all dependencies are reachable
+	checker := health.NewChecker(srv, healthTimeout)
+	mux.Handle("/healthz", checker.LivenessHandler())
+	mux.Handle("/readyz", checker.ReadinessHandler())
+
+	// handle all other requests as websocket connections
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		err := wsHandler(hub, w, r)
+		if err != nil {
+			logger.DebugError("websocket handler,", err)
+		}
+	})
+
+	server := &http.Server{
+		Handler:      mux,
 		ReadTimeout:  time.Second * 15,
 		WriteTimeout: time.Second * 15,
 	}
+
+	return &Server{Server: server, hub: hub, checker: checker}
+}
+
+// Drain will report the server as not ready, so that no new connections are
+// routed to the server before it is shut down. Existing connections are
+// still handled
+func (s *Server) Drain() {
+	s.checker.Drain()
+}
+
+// Shutdown will stop accepting new connections and wait until all rooms
+// finished their current message, all clients were informed about the
+// shutdown and all connections were closed
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	// note: the http server does not wait for websocket connections, as
+	// they are hijacked from the server
+	err := s.Server.Shutdown(ctx)
+
+	request := ShutdownRequest{
+		Context: ctx,
+		Done:    make(chan bool),
+	}
+
+	select {
+	case s.hub.Shutdown <- &request:
+		<-request.Done
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// wait until the queued messages were written to all clients
+	written := make(chan bool)
+	go func() {
+		s.hub.connections.Wait()
+		close(written)
+	}()
+
+	select {
+	case <-written:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return err
 }
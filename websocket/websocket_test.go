@@ -0,0 +1,433 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	domain "dkfbasel.ch/orca/collaboration/src/domain"
+	"dkfbasel.ch/orca/collaboration/src/internal/environment"
+	"dkfbasel.ch/orca/collaboration/src/repository"
+	"github.com/jmoiron/sqlx"
+)
+
+// id of the document used by all tests
+const testDocumentID = "document"
+
+// schema of the document used by all tests
+const testSchema = `{
+	"nodes": {"content": [
+		"doc", {"content": "block+"},
+		"paragraph", {"content": "inline*", "group": "block"},
+		"text", {"group": "inline"}
+	]},
+	"marks": {"content": [
+		"em", {},
+		"comment", {"attrs": {"id": {}}, "excludes": ""},
+		"weblink", {"attrs": {"id": {}, "url": {}, "name": {"default": ""}}}
+	]}
+}`
+
+// the test document contains a paragraph at 0-7 with the text at 1-6
+const testDocument = `{"type":"doc","content":[
+	{"type":"paragraph","content":[{"type":"text","text":"hello"}]}
+]}`
+
+// time to wait for responses of the room
+const testTimeout = time.Second * 2
+
+// testDriver is a database driver that does not need a database. Queries
+// return the rows or the error set for their arguments with queueTestRows,
+// setTestRows or setTestError. Otherwise a
+// single row with the value true is returned if all arguments are granted
+// for the database and no rows if not. Statements are always executed
+type testDriver struct{}
+
+// testDatabase contains the granted arguments and the rows and errors
+// returned for the arguments of queries
+type testDatabase struct {
+	mutex   sync.Mutex
+	granted map[string]bool
+	rows    map[string]*testRows
+	queued  map[string][]*testRows
+	errors  map[string]error
+}
+
+// all open test databases by their name
+var testDatabases sync.Map
+
+func init() {
+	sql.Register("websocket-test", testDriver{})
+}
+
+func (testDriver) Open(name string) (driver.Conn, error) {
+	db, _ := testDatabases.Load(name)
+	if db == nil {
+		return nil, fmt.Errorf("unknown test database %s", name)
+	}
+	return &testConn{db: db.(*testDatabase)}, nil
+}
+
+type testConn struct {
+	db *testDatabase
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return &testStmt{conn: c}, nil
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *testConn) Commit() error {
+	return nil
+}
+
+func (c *testConn) Rollback() error {
+	return nil
+}
+
+type testStmt struct {
+	conn *testConn
+}
+
+func (s *testStmt) Close() error {
+	return nil
+}
+
+func (s *testStmt) NumInput() int {
+	return -1
+}
+
+func (s *testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (s *testStmt) Query(args []driver.Value) (driver.Rows, error) {
+
+	db := s.conn.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if err, ok := db.errors[testQueryKey(args)]; ok {
+		return nil, err
+	}
+
+	if queued := db.queued[testQueryKey(args)]; len(queued) > 0 {
+		db.queued[testQueryKey(args)] = queued[1:]
+		return queued[0], nil
+	}
+
+	if rows, ok := db.rows[testQueryKey(args)]; ok {
+		return &testRows{columns: rows.columns, values: rows.values}, nil
+	}
+
+	if len(args) == 0 {
+		return &testRows{}, nil
+	}
+
+	for _, arg := range args {
+		value, ok := arg.(string)
+		if !ok || !db.granted[value] {
+			return &testRows{}, nil
+		}
+	}
+
+	return &testRows{columns: []string{"value"}, values: [][]driver.Value{{true}}}, nil
+}
+
+// testQueryKey will return the key of the rows for the given arguments
+func testQueryKey(args []driver.Value) string {
+	key := make([]string, len(args))
+	for i := range args {
+		key[i] = fmt.Sprint(args[i])
+	}
+	return strings.Join(key, "/")
+}
+
+// testRows will only have columns if there are rows, so that empty results
+// can be scanned into any destination
+type testRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *testRows) Columns() []string {
+	return r.columns
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// setTestRows will set the rows returned by the database of the given
+// instance for queries with the given arguments
+func setTestRows(t *testing.T, instance string, args []driver.Value, columns []string,
+	values ...[]driver.Value) {
+	t.Helper()
+
+	db, _ := testDatabases.Load(t.Name() + "/" + instance)
+	if db == nil {
+		t.Fatalf("unknown test database of instance %s", instance)
+	}
+
+	db.(*testDatabase).mutex.Lock()
+	defer db.(*testDatabase).mutex.Unlock()
+
+	db.(*testDatabase).rows[testQueryKey(args)] = &testRows{columns: columns, values: values}
+}
+
+// queueTestRows will let the next query with the given arguments return the
+// given rows for the database of the given instance. Queued rows are
+// returned before the rows set with setTestRows
+func queueTestRows(t *testing.T, instance string, args []driver.Value, columns []string,
+	values ...[]driver.Value) {
+	t.Helper()
+
+	db, _ := testDatabases.Load(t.Name() + "/" + instance)
+	if db == nil {
+		t.Fatalf("unknown test database of instance %s", instance)
+	}
+
+	db.(*testDatabase).mutex.Lock()
+	defer db.(*testDatabase).mutex.Unlock()
+
+	key := testQueryKey(args)
+	db.(*testDatabase).queued[key] = append(db.(*testDatabase).queued[key],
+		&testRows{columns: columns, values: values})
+}
+
+// setTestError will let queries with the given arguments fail for the
+// database of the given instance
+func setTestError(t *testing.T, instance string, args []driver.Value, err error) {
+	t.Helper()
+
+	db, _ := testDatabases.Load(t.Name() + "/" + instance)
+	if db == nil {
+		t.Fatalf("unknown test database of instance %s", instance)
+	}
+
+	db.(*testDatabase).mutex.Lock()
+	defer db.(*testDatabase).mutex.Unlock()
+
+	db.(*testDatabase).errors[testQueryKey(args)] = err
+}
+
+// newTestServices will return the services of an instance using the given
+// step store and broker. Database queries return true if all arguments are
+// part of the given granted values
+func newTestServices(t *testing.T, steps repository.StepStore, broker repository.Broker,
+	instance string, granted ...string) *environment.Services {
+	t.Helper()
+
+	name := t.Name() + "/" + instance
+	database := &testDatabase{
+		granted: make(map[string]bool, len(granted)),
+		rows:    make(map[string]*testRows),
+		queued:  make(map[string][]*testRows),
+		errors:  make(map[string]error),
+	}
+	for _, value := range granted {
+		database.granted[value] = true
+	}
+	testDatabases.Store(name, database)
+
+	db, err := sql.Open("websocket-test", name)
+	if err != nil {
+		t.Fatalf("could not open test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db.Close() // nolint:errcheck
+		testDatabases.Delete(name)
+	})
+
+	return &environment.Services{
+		Steps:    steps,
+		Broker:   broker,
+		Instance: instance,
+		Postgres: &repository.DB{Session: sqlx.NewDb(db, "postgres")},
+	}
+}
+
+// newTestRoom will start a room for the test document. The room is stopped
+// when the test is finished
+func newTestRoom(t *testing.T, srv *environment.Services) *WebsocketRoom {
+	t.Helper()
+	return newTestRoomWithConfig(t, srv, environment.WebsocketConfig{})
+}
+
+// newTestRoomWithConfig will start a room for the test document with the
+// given configuration. The room is stopped when the test is finished
+func newTestRoomWithConfig(t *testing.T, srv *environment.Services,
+	config environment.WebsocketConfig) *WebsocketRoom {
+	t.Helper()
+
+	room := newWebsocketRoom(srv, config, testDocumentID)
+
+	t.Cleanup(func() {
+		close(room.Stop)
+		select {
+		case <-room.Done:
+		case <-time.After(testTimeout):
+			t.Error("room was not stopped")
+		}
+	})
+
+	return room
+}
+
+// newTestClient will return a client without connection. All messages sent
+// to the client are kept in its queue
+func newTestClient(id string, permission domain.Permission) *WebsocketClient {
+
+	client := &WebsocketClient{}
+	client.ID = id
+	client.UserID = "user-" + id
+	client.DocumentID = testDocumentID
+	client.Permission = permission
+	client.Send = make(chan []byte, defaultQueueSize)
+	client.Overflow = OverflowCoalesce
+	client.Close = make(chan closeRequest, 1)
+	client.Limiter = newClientLimiter(environment.WebsocketConfig{})
+	client.ctx = context.Background()
+
+	return client
+}
+
+// joinTestRoom will register the given client in the room
+func joinTestRoom(t *testing.T, room *WebsocketRoom, client *WebsocketClient) {
+	t.Helper()
+
+	registration := newRegistration(client)
+	room.Register <- registration
+	<-registration.Done
+}
+
+// sendTestMessage will send a message of the given client to the room
+func sendTestMessage(t *testing.T, room *WebsocketRoom, client *WebsocketClient,
+	messageType MessageType, payload interface{}) {
+	t.Helper()
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("could not encode message payload: %v", err)
+	}
+
+	room.Handler <- Message{
+		Type:       messageType,
+		Payload:    raw,
+		DocumentID: room.DocumentID,
+		UserID:     client.UserID,
+		Permission: client.Permission,
+		Client:     client,
+		Reply:      client.send,
+	}
+}
+
+// syncTestRoom will wait until the room handled all previous messages. The
+// room handles one message at a time and ignores unknown message types
+func syncTestRoom(t *testing.T, room *WebsocketRoom, client *WebsocketClient) {
+	t.Helper()
+
+	sendTestMessage(t, room, client, MessageType("test-sync"), struct{}{})
+}
+
+// initTestClient will register the client in the room and initialize it with
+// the test document at the given version. The responses of the given types
+// are returned
+func initTestClient(t *testing.T, room *WebsocketRoom, client *WebsocketClient, version int64,
+	types ...MessageType) map[MessageType]json.RawMessage {
+	t.Helper()
+
+	joinTestRoom(t, room, client)
+
+	sendTestMessage(t, room, client, MessageTypeProsemirrorInit, &ProsemirrorInitMessage{
+		DocumentID:      testDocumentID,
+		DocumentSchema:  json.RawMessage(testSchema),
+		DocumentVersion: version,
+		Document:        json.RawMessage(testDocument),
+	})
+
+	return expectResponses(t, client, types...)
+}
+
+// expectResponses will wait until the client received a message of each of
+// the given types and return the payloads by type. Messages of other types
+// are skipped
+func expectResponses(t *testing.T, client *WebsocketClient,
+	types ...MessageType) map[MessageType]json.RawMessage {
+	t.Helper()
+
+	wanted := make(map[MessageType]bool, len(types))
+	for _, messageType := range types {
+		wanted[messageType] = true
+	}
+
+	received := make(map[MessageType]json.RawMessage, len(types))
+	skipped := []MessageType{}
+
+	timeout := time.After(testTimeout)
+	for len(received) < len(wanted) {
+		select {
+		case raw := <-client.Send:
+			var response struct {
+				Type    MessageType     `json:"type"`
+				Payload json.RawMessage `json:"payload"`
+			}
+			err := json.Unmarshal(raw, &response)
+			if err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+
+			if !wanted[response.Type] || received[response.Type] != nil {
+				skipped = append(skipped, response.Type)
+				continue
+			}
+			received[response.Type] = response.Payload
+
+		case <-timeout:
+			t.Fatalf("expected %v, received %v and skipped %v", types, received, skipped)
+		}
+	}
+
+	return received
+}
+
+// decodeTestPayload will decode the given response payload
+func decodeTestPayload(t *testing.T, raw json.RawMessage, payload interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(raw, payload)
+	if err != nil {
+		t.Fatalf("could not decode response payload: %v", err)
+	}
+}
+
+// testReplaceStep will return a step inserting the given text at the given
+// position
+func testReplaceStep(pos int, text string) json.RawMessage {
+	return json.RawMessage(fmt.Sprintf(`{"stepType":"replace","from":%d,"to":%d,`+
+		`"slice":{"content":[{"type":"text","text":%q}]}}`, pos, pos, text))
+}